@@ -0,0 +1,420 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	dh "github.com/isseis/go-synology-office-exporter/download_history"
+	"github.com/isseis/go-synology-office-exporter/filelock"
+	synd "github.com/isseis/go-synology-office-exporter/synology_drive_api"
+	syndexp "github.com/isseis/go-synology-office-exporter/synology_drive_exporter"
+)
+
+// historyCommand names the history maintenance subcommands.
+type historyCommand string
+
+const (
+	historyList   historyCommand = "list"
+	historySearch historyCommand = "search" // alias of list
+	historyShow   historyCommand = "show"
+	historyForget historyCommand = "forget"
+	historyPrune  historyCommand = "prune"
+	historyCSV    historyCommand = "csv"
+	historyRuns   historyCommand = "runs"
+	historyAt     historyCommand = "at"
+)
+
+// historyFileForSource returns the history file name used by the exporter for the given source.
+func historyFileForSource(source sourceType) string {
+	switch source {
+	case sourceTeamFolder:
+		return syndexp.TeamFolderHistoryFile
+	case sourceShared:
+		return syndexp.SharedWithMeHistoryFile
+	default:
+		return syndexp.MyDriveHistoryFile
+	}
+}
+
+// historyOptions holds the flags shared by all history subcommands.
+type historyOptions struct {
+	downloadDir string
+	source      string
+	historyPath string
+	pathFilter  string
+	fileID      string
+	since       string
+	until       string
+}
+
+// resolveHistoryPath returns the history file selected by the options.
+// An explicit -history path takes precedence over -output and -source.
+func (o *historyOptions) resolveHistoryPath() (string, error) {
+	if o.historyPath != "" {
+		return o.historyPath, nil
+	}
+	sources, err := parseSources(o.source)
+	if err != nil {
+		return "", err
+	}
+	if len(sources) != 1 {
+		return "", fmt.Errorf("exactly one source must be specified, got %q", o.source)
+	}
+	return filepath.Join(o.resolveDownloadDir(), historyFileForSource(sources[0])), nil
+}
+
+// resolveDownloadDir returns the export directory, falling back to SYNOLOGY_DOWNLOAD_DIR and then the current directory.
+func (o *historyOptions) resolveDownloadDir() string {
+	if o.downloadDir != "" {
+		return o.downloadDir
+	}
+	if dir := os.Getenv("SYNOLOGY_DOWNLOAD_DIR"); dir != "" {
+		return dir
+	}
+	return "."
+}
+
+// query builds a download history query from the filter flags.
+func (o *historyOptions) query() (dh.Query, error) {
+	q := dh.Query{
+		PathContains: o.pathFilter,
+		FileID:       synd.FileID(o.fileID),
+	}
+	var err error
+	if q.Since, err = parseHistoryTime(o.since); err != nil {
+		return dh.Query{}, fmt.Errorf("invalid -since: %w", err)
+	}
+	if q.Until, err = parseHistoryTime(o.until); err != nil {
+		return dh.Query{}, fmt.Errorf("invalid -until: %w", err)
+	}
+	return q, nil
+}
+
+// parseHistoryTime parses an RFC3339 timestamp or a YYYY-MM-DD date in local time.
+// An empty string yields the zero time.
+func parseHistoryTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateOnly, s, time.Local)
+}
+
+// newHistoryFlagSet creates the flag set for a history subcommand.
+func newHistoryFlagSet(cmd historyCommand, opts *historyOptions, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("history "+string(cmd), flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&opts.downloadDir, "output", "", "Directory containing the exported files and history (default: $SYNOLOGY_DOWNLOAD_DIR or current directory)")
+	fs.StringVar(&opts.source, "source", string(sourceMyDrive), "Source whose history to use (mydrive,teamfolder,shared)")
+	fs.StringVar(&opts.historyPath, "history", "", "Path to a history file (overrides -output and -source)")
+	switch cmd {
+	case historyList, historySearch, historyCSV, historyForget:
+		fs.StringVar(&opts.pathFilter, "path", "", "Only match entries whose location contains this string")
+		fs.StringVar(&opts.fileID, "file-id", "", "Only match entries with this file ID")
+		fs.StringVar(&opts.since, "since", "", "Only match entries downloaded at or after this time (RFC3339 or YYYY-MM-DD)")
+		fs.StringVar(&opts.until, "until", "", "Only match entries downloaded before this time (RFC3339 or YYYY-MM-DD)")
+	case historyShow:
+		fs.StringVar(&opts.fileID, "file-id", "", "Show the entry with this file ID instead of a location")
+	}
+	return fs
+}
+
+// printHistoryUsage prints the usage of the history subcommands.
+func printHistoryUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s history <command> [flags] [args]\n\n", filepath.Base(os.Args[0]))
+	fmt.Fprintln(w, "Commands:")
+	fmt.Fprintln(w, "  list     List entries, optionally filtered by -path, -file-id, -since and -until (alias: search)")
+	fmt.Fprintln(w, "  show     Show a single entry given its location or -file-id")
+	fmt.Fprintln(w, "  forget   Remove the given locations or matching entries so that they are exported again")
+	fmt.Fprintln(w, "  prune    Remove entries whose exported file no longer exists")
+	fmt.Fprintln(w, "  csv      Write matching entries as CSV")
+	fmt.Fprintln(w, "  runs     List the runs recorded in the journal with their event counts")
+	fmt.Fprintln(w, "  at       List the files that were present after the given run ID, according to the journal")
+	fmt.Fprintf(w, "\nRun '%s history <command> -h' for the flags of a command.\n", filepath.Base(os.Args[0]))
+}
+
+// runHistory executes a history subcommand and returns the process exit code.
+func runHistory(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		printHistoryUsage(stderr)
+		return 2
+	}
+	cmd := historyCommand(args[0])
+	switch cmd {
+	case historyList, historySearch, historyShow, historyForget, historyPrune, historyCSV, historyRuns, historyAt:
+	case "help", "-h", "-help", "--help":
+		printHistoryUsage(stdout)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "Unknown history command: %s\n\n", args[0])
+		printHistoryUsage(stderr)
+		return 2
+	}
+
+	var opts historyOptions
+	fs := newHistoryFlagSet(cmd, &opts, stderr)
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	historyPath, err := opts.resolveHistoryPath()
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	switch cmd {
+	case historyList, historySearch:
+		err = historyListCmd(historyPath, &opts, stdout)
+	case historyShow:
+		err = historyShowCmd(historyPath, &opts, fs.Args(), stdout)
+	case historyForget:
+		err = historyForgetCmd(historyPath, &opts, fs.Args(), stdout)
+	case historyPrune:
+		err = historyPruneCmd(historyPath, stdout)
+	case historyCSV:
+		err = historyCSVCmd(historyPath, &opts, stdout)
+	case historyRuns:
+		err = historyRunsCmd(historyPath, stdout)
+	case historyAt:
+		err = historyAtCmd(historyPath, fs.Args(), stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// withHistory loads the history file while holding its lock and calls fn.
+// When save is true the history is written back after fn succeeds.
+func withHistory(historyPath string, save bool, fn func(*dh.DownloadHistory) error) error {
+	if _, err := os.Stat(historyPath); err != nil {
+		return fmt.Errorf("history file not found: %w", err)
+	}
+
+	unlock, err := filelock.TryLock(historyPath)
+	if err != nil {
+		if errors.Is(err, filelock.ErrLockHeld) {
+			return fmt.Errorf("history file %s is in use by another process", historyPath)
+		}
+		return fmt.Errorf("failed to acquire lock for %s: %w", historyPath, err)
+	}
+	defer unlock()
+
+	history, err := dh.NewDownloadHistory(historyPath)
+	if err != nil {
+		return err
+	}
+	if err := history.Load(); err != nil {
+		return fmt.Errorf("failed to load history: %w", err)
+	}
+	if err := fn(history); err != nil {
+		return err
+	}
+	if save {
+		// Not a completed export run, so an interrupted run the file was checkpointed by is kept for resuming.
+		if err := history.SaveEdited(); err != nil {
+			return fmt.Errorf("failed to save history: %w", err)
+		}
+	}
+	return nil
+}
+
+// findHistoryEntries returns the entries of the history file that match the filter flags.
+func findHistoryEntries(historyPath string, opts *historyOptions) ([]dh.Entry, error) {
+	q, err := opts.query()
+	if err != nil {
+		return nil, err
+	}
+	var entries []dh.Entry
+	err = withHistory(historyPath, false, func(h *dh.DownloadHistory) error {
+		entries, err = h.FindItems(q)
+		return err
+	})
+	return entries, err
+}
+
+func historyListCmd(historyPath string, opts *historyOptions, stdout io.Writer) error {
+	entries, err := findHistoryEntries(historyPath, opts)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DOWNLOAD TIME\tFILE ID\tLOCATION")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", e.DownloadTime.Format(time.RFC3339), e.FileID, e.Location)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%d entries\n", len(entries))
+	return nil
+}
+
+func historyShowCmd(historyPath string, opts *historyOptions, args []string, stdout io.Writer) error {
+	var q dh.Query
+	var location string
+	switch {
+	case opts.fileID != "" && len(args) == 0:
+		q.FileID = synd.FileID(opts.fileID)
+	case opts.fileID == "" && len(args) == 1:
+		location = args[0]
+	default:
+		return fmt.Errorf("show requires exactly one location or -file-id")
+	}
+
+	var entries []dh.Entry
+	err := withHistory(historyPath, false, func(h *dh.DownloadHistory) error {
+		if location == "" {
+			var err error
+			entries, err = h.FindItems(q)
+			return err
+		}
+		item, exists, err := h.GetItem(location)
+		if err != nil {
+			return err
+		}
+		if exists {
+			entries = []dh.Entry{{Location: location, DownloadItem: item}}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return dh.ErrHistoryItemNotFound
+	}
+	for i, e := range entries {
+		if i > 0 {
+			fmt.Fprintln(stdout)
+		}
+		fmt.Fprintf(stdout, "Location:      %s\n", e.Location)
+		fmt.Fprintf(stdout, "File ID:       %s\n", e.FileID)
+		fmt.Fprintf(stdout, "Hash:          %s\n", e.Hash)
+		fmt.Fprintf(stdout, "Download time: %s\n", e.DownloadTime.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func historyForgetCmd(historyPath string, opts *historyOptions, args []string, stdout io.Writer) error {
+	q, err := opts.query()
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 && q != (dh.Query{}) {
+		return fmt.Errorf("forget accepts either locations or filter flags, not both")
+	}
+	if len(args) == 0 && q == (dh.Query{}) {
+		return fmt.Errorf("forget requires at least one location or filter flag")
+	}
+
+	var forgotten []string
+	err = withHistory(historyPath, true, func(h *dh.DownloadHistory) error {
+		locations := args
+		if len(locations) == 0 {
+			entries, err := h.FindItems(q)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				locations = append(locations, e.Location)
+			}
+		}
+		for _, location := range locations {
+			if err := h.RemoveItem(location); err != nil {
+				return fmt.Errorf("failed to forget %s: %w", location, err)
+			}
+			forgotten = append(forgotten, location)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, location := range forgotten {
+		fmt.Fprintf(stdout, "Forgot %s\n", location)
+	}
+	fmt.Fprintf(stdout, "%d entries forgotten\n", len(forgotten))
+	return nil
+}
+
+func historyPruneCmd(historyPath string, stdout io.Writer) error {
+	// Locations in the history are relative to the directory holding the history file.
+	baseDir := filepath.Dir(historyPath)
+	var pruned []string
+	err := withHistory(historyPath, true, func(h *dh.DownloadHistory) error {
+		var err error
+		pruned, err = h.PruneMissing(baseDir)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, location := range pruned {
+		fmt.Fprintf(stdout, "Pruned %s\n", location)
+	}
+	fmt.Fprintf(stdout, "%d entries pruned\n", len(pruned))
+	return nil
+}
+
+func historyCSVCmd(historyPath string, opts *historyOptions, stdout io.Writer) error {
+	entries, err := findHistoryEntries(historyPath, opts)
+	if err != nil {
+		return err
+	}
+	return dh.WriteCSV(stdout, entries)
+}
+
+// historyRunsCmd lists the runs recorded in the journal. The journal is append-only, so no lock is taken.
+func historyRunsCmd(historyPath string, stdout io.Writer) error {
+	records, err := dh.ReadJournal(syndexp.JournalPath(historyPath))
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN ID\tSTART\tEND\tDOWNLOADED\tSKIPPED\tREMOVED\tFAILED")
+	for _, run := range dh.ListRuns(records) {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%d\n", run.RunID,
+			run.Start.Format(time.RFC3339), run.End.Format(time.RFC3339),
+			run.Counts[dh.EventDownloaded], run.Counts[dh.EventSkipped],
+			run.Counts[dh.EventRemoved], run.Counts[dh.EventFailed])
+	}
+	return tw.Flush()
+}
+
+// historyAtCmd lists the files that were present after the given run, reconstructed from the journal.
+func historyAtCmd(historyPath string, args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return fmt.Errorf("at requires exactly one run ID")
+	}
+	records, err := dh.ReadJournal(syndexp.JournalPath(historyPath))
+	if err != nil {
+		return err
+	}
+	entries, err := dh.FilesAtRun(records, args[0])
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, args[0])
+	}
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DOWNLOAD TIME\tFILE ID\tLOCATION")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", e.DownloadTime.Format(time.RFC3339), e.FileID, e.Location)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%d files\n", len(entries))
+	return nil
+}
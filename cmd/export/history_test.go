@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dh "github.com/isseis/go-synology-office-exporter/download_history"
+	"github.com/isseis/go-synology-office-exporter/filelock"
+	syndexp "github.com/isseis/go-synology-office-exporter/synology_drive_exporter"
+)
+
+// writeTestHistory creates a My Drive history file in dir containing the given items.
+func writeTestHistory(t *testing.T, dir string, items map[string]dh.DownloadItem) string {
+	t.Helper()
+	path := filepath.Join(dir, syndexp.MyDriveHistoryFile)
+	history, err := dh.NewDownloadHistory(path)
+	require.NoError(t, err)
+	require.NoError(t, history.Load())
+	for location, item := range items {
+		require.NoError(t, history.SetDownloaded(location, item))
+	}
+	require.NoError(t, history.Save())
+	return path
+}
+
+// loadTestHistory returns all entries of the history file at path.
+func loadTestHistory(t *testing.T, path string) []dh.Entry {
+	t.Helper()
+	history, err := dh.NewDownloadHistory(path)
+	require.NoError(t, err)
+	require.NoError(t, history.Load())
+	entries, err := history.FindItems(dh.Query{})
+	require.NoError(t, err)
+	return entries
+}
+
+func TestRunHistory(t *testing.T) {
+	baseTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	items := map[string]dh.DownloadItem{
+		"mydrive/a.docx":     {FileID: "id1", Hash: "h1", DownloadTime: baseTime},
+		"mydrive/sub/b.xlsx": {FileID: "id2", Hash: "h2", DownloadTime: baseTime.Add(48 * time.Hour)},
+	}
+
+	run := func(args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := runHistory(args, &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	t.Run("list with filters", func(t *testing.T) {
+		dir := t.TempDir()
+		writeTestHistory(t, dir, items)
+
+		code, out, _ := run("list", "-output", dir)
+		assert.Equal(t, 0, code)
+		assert.Contains(t, out, "mydrive/a.docx")
+		assert.Contains(t, out, "mydrive/sub/b.xlsx")
+		assert.Contains(t, out, "2 entries")
+
+		code, out, _ = run("search", "-output", dir, "-since", "2024-05-02")
+		assert.Equal(t, 0, code)
+		assert.NotContains(t, out, "mydrive/a.docx")
+		assert.Contains(t, out, "mydrive/sub/b.xlsx")
+	})
+
+	t.Run("show by location and file ID", func(t *testing.T) {
+		dir := t.TempDir()
+		writeTestHistory(t, dir, items)
+
+		code, out, _ := run("show", "-output", dir, "mydrive/a.docx")
+		assert.Equal(t, 0, code)
+		assert.Contains(t, out, "File ID:       id1")
+
+		code, out, _ = run("show", "-output", dir, "-file-id", "id2")
+		assert.Equal(t, 0, code)
+		assert.Contains(t, out, "Location:      mydrive/sub/b.xlsx")
+
+		code, _, errOut := run("show", "-output", dir, "mydrive/missing.docx")
+		assert.Equal(t, 1, code)
+		assert.Contains(t, errOut, "not found")
+	})
+
+	t.Run("forget removes entries", func(t *testing.T) {
+		dir := t.TempDir()
+		path := writeTestHistory(t, dir, items)
+
+		code, out, _ := run("forget", "-output", dir, "mydrive/a.docx")
+		assert.Equal(t, 0, code)
+		assert.Contains(t, out, "1 entries forgotten")
+
+		entries := loadTestHistory(t, path)
+		require.Len(t, entries, 1)
+		assert.Equal(t, "mydrive/sub/b.xlsx", entries[0].Location)
+
+		code, _, _ = run("forget", "-output", dir)
+		assert.Equal(t, 1, code, "forget without arguments must fail")
+	})
+
+	t.Run("forget keeps an interrupted run resumable", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, syndexp.MyDriveHistoryFile)
+		history, err := dh.NewDownloadHistory(path)
+		require.NoError(t, err)
+		require.NoError(t, history.Load())
+		for location, item := range items {
+			require.NoError(t, history.SetDownloaded(location, item))
+		}
+		interrupted := dh.InterruptedRun{RunID: "run1", Started: baseTime}
+		require.NoError(t, history.Checkpoint(interrupted))
+
+		code, _, _ := run("forget", "-output", dir, "mydrive/a.docx")
+		assert.Equal(t, 0, code)
+
+		reloaded, err := dh.NewDownloadHistory(path)
+		require.NoError(t, err)
+		require.NoError(t, reloaded.Load())
+		got, ok := reloaded.Interrupted()
+		require.True(t, ok, "the run must still be incomplete after forget")
+		assert.Equal(t, interrupted, got)
+		assert.Len(t, loadTestHistory(t, path), 1)
+	})
+
+	t.Run("prune removes entries without local file", func(t *testing.T) {
+		dir := t.TempDir()
+		path := writeTestHistory(t, dir, items)
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "mydrive"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "mydrive", "a.docx"), []byte("x"), 0644))
+
+		code, out, _ := run("prune", "-output", dir)
+		assert.Equal(t, 0, code)
+		assert.Contains(t, out, "Pruned mydrive/sub/b.xlsx")
+
+		entries := loadTestHistory(t, path)
+		require.Len(t, entries, 1)
+		assert.Equal(t, "mydrive/a.docx", entries[0].Location)
+	})
+
+	t.Run("csv export", func(t *testing.T) {
+		dir := t.TempDir()
+		writeTestHistory(t, dir, items)
+
+		code, out, _ := run("csv", "-output", dir, "-file-id", "id1")
+		assert.Equal(t, 0, code)
+		assert.Equal(t, "location,file_id,hash,download_time\nmydrive/a.docx,id1,h1,2024-05-01T12:00:00Z\n", out)
+	})
+
+	t.Run("runs and at read the journal", func(t *testing.T) {
+		dir := t.TempDir()
+		path := writeTestHistory(t, dir, items)
+		for _, r := range []struct {
+			id     string
+			events []dh.JournalRecord
+		}{
+			{"run1", []dh.JournalRecord{{Event: dh.EventDownloaded, Location: "mydrive/a.docx", FileID: "id1"}}},
+			{"run2", []dh.JournalRecord{{Event: dh.EventRemoved, Location: "mydrive/a.docx", FileID: "id1"}}},
+		} {
+			j, err := dh.OpenJournal(syndexp.JournalPath(path), r.id)
+			require.NoError(t, err)
+			for _, rec := range r.events {
+				require.NoError(t, j.Append(rec))
+			}
+			require.NoError(t, j.Close())
+		}
+
+		code, out, _ := run("runs", "-output", dir)
+		assert.Equal(t, 0, code)
+		assert.Contains(t, out, "run1")
+		assert.Contains(t, out, "run2")
+
+		code, out, _ = run("at", "-output", dir, "run1")
+		assert.Equal(t, 0, code)
+		assert.Contains(t, out, "mydrive/a.docx")
+		assert.Contains(t, out, "1 files")
+
+		code, out, _ = run("at", "-output", dir, "run2")
+		assert.Equal(t, 0, code)
+		assert.Contains(t, out, "0 files")
+
+		code, _, errOut := run("at", "-output", dir, "run3")
+		assert.Equal(t, 1, code)
+		assert.Contains(t, errOut, "run not found")
+	})
+
+	t.Run("fails when history is locked", func(t *testing.T) {
+		dir := t.TempDir()
+		path := writeTestHistory(t, dir, items)
+		unlock, err := filelock.TryLock(path)
+		require.NoError(t, err)
+		defer unlock()
+
+		code, _, errOut := run("list", "-output", dir)
+		assert.Equal(t, 1, code)
+		assert.Contains(t, errOut, "in use by another process")
+	})
+
+	t.Run("missing history file", func(t *testing.T) {
+		code, _, errOut := run("list", "-output", t.TempDir())
+		assert.Equal(t, 1, code)
+		assert.Contains(t, errOut, "history file not found")
+	})
+
+	t.Run("unknown command", func(t *testing.T) {
+		code, _, errOut := run("bogus")
+		assert.Equal(t, 2, code)
+		assert.Contains(t, errOut, "Unknown history command")
+	})
+}
+
+func TestParseHistoryTime(t *testing.T) {
+	got, err := parseHistoryTime("2024-05-01T12:00:00Z")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), got)
+
+	got, err = parseHistoryTime("2024-05-01")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local), got)
+
+	got, err = parseHistoryTime("")
+	require.NoError(t, err)
+	assert.True(t, got.IsZero())
+
+	_, err = parseHistoryTime("yesterday")
+	assert.Error(t, err)
+}
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 
 	"github.com/isseis/go-synology-office-exporter/logger"
+	synd "github.com/isseis/go-synology-office-exporter/synology_drive_api"
 	syndexp "github.com/isseis/go-synology-office-exporter/synology_drive_exporter"
 )
 
@@ -77,6 +85,10 @@ func init() {
 func printUsage() {
 	// Print standard flag usage
 	fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "  %s [flags]           Export documents\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "  %s serve [flags]     Export documents repeatedly on a schedule\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "  %s history <command> Inspect and maintain download history\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "  %s lock-status        Show which runs hold the locks of an export directory\n\n", os.Args[0])
 	fmt.Fprintf(flag.CommandLine.Output(), "Flags:\n")
 	flag.VisitAll(func(f *flag.Flag) {
 		fmt.Fprintf(flag.CommandLine.Output(), "  -%s\n    \t%s\n", f.Name, f.Usage)
@@ -99,6 +111,7 @@ func printUsage() {
 		{"SYNOLOGY_NAS_PASS", "Synology NAS password"},
 		{"SYNOLOGY_NAS_URL", "Synology NAS URL"},
 		{"SYNOLOGY_DOWNLOAD_DIR", "Directory to save downloaded files (default: current directory)"},
+		{"SYNOLOGY_API_TOKEN", "Bearer token required by the status API of the serve command"},
 	}
 
 	fmt.Fprintln(flag.CommandLine.Output(), "\nSynology NAS environment variables:")
@@ -108,19 +121,52 @@ func printUsage() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		os.Exit(runHistory(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "lock-status" {
+		os.Exit(runLockStatus(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	// The serve command takes the same flags as a single export.
+	serveMode := len(os.Args) > 1 && os.Args[1] == "serve"
+	args := os.Args[1:]
+	if serveMode {
+		args = os.Args[2:]
+	}
+
 	flag.Usage = printUsage
 
 	// Define command-line flags for Synology connection (not handled by config)
+	apiListenFlag := flag.String("api-listen", "", "serve: Serve the status API (/healthz, /readyz, /status, /run) on this address, e.g. \"127.0.0.1:9470\"")
+	apiTokenFlag := flag.String("api-token", "", "serve: Bearer token required by the status API, except /healthz and /readyz")
 	userFlag := flag.String("user", "", "Synology NAS username")
 	passFlag := flag.String("pass", "", "Synology NAS password")
 	urlFlag := flag.String("url", "", "Synology NAS URL")
 	downloadDirFlag := flag.String("output", "", "Directory to save downloaded files")
 	sourcesFlag := flag.String("sources", "mydrive,teamfolder,shared", "Comma-separated list of sources to export (mydrive,teamfolder,shared)")
+	checkpointEveryFlag := flag.Int("checkpoint-every", syndexp.DefaultCheckpointEvery, "Save download history after this many downloaded files so an interrupted run can resume (0 disables)")
+	checkpointIntervalFlag := flag.Duration("checkpoint-interval", syndexp.DefaultCheckpointInterval, "Save download history when this much time has passed since the last save (0 disables)")
 	dryRunFlag := flag.Bool("dry-run", false, "If set, perform a dry run (no file downloads, only show statistics)")
+	forceUnlockFlag := flag.Bool("force-unlock", false, "If set, break history lock files held from another host (subject to -lock-max-age if set; not used on Linux)")
 	forceDownloadFlag := flag.Bool("force-download", false, "If set, re-download files even if they exist and have matching hashes")
+	journalMaxSizeFlag := flag.Int64("journal-max-size", syndexp.DefaultJournalMaxSize, "Rotate the history journal when it exceeds this many bytes (0 disables)")
+	journalMaxAgeFlag := flag.Duration("journal-max-age", syndexp.DefaultJournalMaxAge, "Rotate the history journal when its first record is older than this (0 disables)")
+	journalMaxBackupsFlag := flag.Int("journal-max-backups", 0, "Number of rotated history journal files to keep (0 keeps all)")
+	lockWaitFlag := flag.Duration("lock-wait", 0, "Wait this long for a history lock held by another run before failing (0 fails immediately)")
+	metricsListenFlag := flag.String("metrics-listen", "", "serve: Serve Prometheus metrics at /metrics on this address, e.g. \":9469\"")
+	metricsTextfileFlag := flag.String("metrics-textfile", "", "Write Prometheus metrics to this file after each run, for the node_exporter textfile collector (use a .prom extension)")
+	lockMaxAgeFlag := flag.Duration("lock-max-age", 0, "Treat history lock files older than this as stale even if their holder is running (0 disables; not used on Linux)")
+	reconcileFlag := flag.Bool("reconcile", false, "If set, rebuild download history from files already in the output directory without downloading")
+	shutdownGraceFlag := flag.Duration("shutdown-grace", defaultShutdownGrace, "On SIGINT/SIGTERM, wait this long for the file being exported before aborting")
+	serveOpts := registerServeFlags(flag.CommandLine)
+	tlsOpts := registerTLSFlags(flag.CommandLine)
+	transportOpts := registerTransportFlags(flag.CommandLine)
 
 	// Parse all flags
-	flag.Parse()
+	if err := flag.CommandLine.Parse(args); err != nil {
+		os.Exit(2)
+	}
 
 	// Now load config which will use the parsed flag values
 	cfg, err := logger.LoadConfig()
@@ -130,12 +176,52 @@ func main() {
 		os.Exit(1)
 	}
 
-	log := logger.NewHybridLogger(*cfg)
-	defer func() {
+	log, err := logger.NewHybridLogger(*cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating logger: %v\n", err)
+		os.Exit(1)
+	}
+	// exit releases the run lock, flushes buffered webhook logs and closes the log file before exiting,
+	// which deferred calls would not do with os.Exit.
+	var unlockRun func()
+	exit := func(code int) {
+		if unlockRun != nil {
+			unlockRun()
+		}
 		if err := log.FlushWebhook(); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to flush webhook logs: %v\n", err)
 		}
-	}()
+		if closer, ok := log.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to close log file: %v\n", err)
+			}
+		}
+		os.Exit(code)
+	}
+
+	if !serveMode && *metricsListenFlag != "" {
+		log.Error("-metrics-listen is only supported by the serve command; use -metrics-textfile for single runs")
+		exit(2)
+	}
+	if !serveMode && *apiListenFlag != "" {
+		log.Error("-api-listen is only supported by the serve command")
+		exit(2)
+	}
+	apiToken := *apiTokenFlag
+	if apiToken == "" {
+		apiToken = os.Getenv("SYNOLOGY_API_TOKEN")
+	}
+	if *apiListenFlag != "" && apiToken == "" {
+		log.Warn("The status API is served without authentication; set -api-token to require a bearer token")
+	}
+	// Check the schedule before logging in, so that a mistake is reported at once.
+	var d *daemon
+	if serveMode {
+		if d, err = serveOpts.newDaemon(time.Now(), nil, log); err != nil {
+			log.Error("Invalid schedule", "error", err)
+			exit(2)
+		}
+	}
 
 	fmt.Println("Starting Synology Office Exporter...")
 
@@ -163,48 +249,186 @@ func main() {
 			fmt.Printf("Warning: Download directory '%s' does not exist. Attempting to create it.\n", downloadDir)
 			if err := os.MkdirAll(downloadDir, 0755); err != nil {
 				fmt.Fprintf(os.Stderr, "Failed to create download directory: %v\n", err)
-				os.Exit(1)
+				exit(1)
 			}
 		} else {
 			fmt.Fprintf(os.Stderr, "Error: Specified path '%s' is not a directory\n", downloadDir)
-			os.Exit(1)
+			exit(1)
 		}
 	}
 	downloadDir, err = filepath.Abs(downloadDir)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to resolve absolute path of download directory: %v\n", err)
-		os.Exit(1)
+		exit(1)
 	}
 	fmt.Printf("Files will be downloaded to: %s\n", downloadDir)
 
 	if user == "" || pass == "" || url == "" {
 		fmt.Fprintf(os.Stderr, "Missing required parameters: user, pass, and url must be provided either as flags or environment variables\n")
-		os.Exit(1)
+		exit(1)
 	}
 
 	log.Info("Synology Office Exporter started", "version", Version)
+	sessionOpts, err := tlsOpts.sessionOptions(log)
+	if err != nil {
+		log.Error("Invalid TLS options", "error", err)
+		exit(2)
+	}
+	transportSessionOpts, err := transportOpts.sessionOptions()
+	if err != nil {
+		log.Error("Invalid HTTP options", "error", err)
+		exit(2)
+	}
+	exporterMetrics := newExporterMetrics()
+	sessionOpts = append(sessionOpts, transportSessionOpts...)
+	sessionOpts = append(sessionOpts, synd.WithAPIObserver(exporterMetrics), synd.WithRetryLogger(log))
 	exporter, err := syndexp.NewExporter(user, pass, url, downloadDir,
+		syndexp.WithSessionOptions(sessionOpts...),
 		syndexp.WithDryRun(*dryRunFlag),
 		syndexp.WithForceDownload(*forceDownloadFlag),
+		syndexp.WithReconcile(*reconcileFlag),
+		syndexp.WithJournalRotation(*journalMaxSizeFlag, *journalMaxAgeFlag),
+		syndexp.WithJournalMaxBackups(*journalMaxBackupsFlag),
+		syndexp.WithCheckpoint(*checkpointEveryFlag, *checkpointIntervalFlag),
+		syndexp.WithStaleLock(*lockMaxAgeFlag, *forceUnlockFlag),
+		syndexp.WithLockWait(*lockWaitFlag),
 		syndexp.WithLogger(syndexp.NewLoggerAdapter(log)),
 		syndexp.WithLogLevel(cfg.Level),
 	)
 	if err != nil {
 		log.Error("Failed to create exporter", "error", err)
-		os.Exit(1)
+		exit(1)
 	}
 
-	exitCode := 0
-
 	sources, err := parseSources(*sourcesFlag)
 	if err != nil {
 		log.Error("Error parsing sources", "error", err, "valid_sources", []string{string(sourceMyDrive), string(sourceTeamFolder), string(sourceShared)})
-		os.Exit(1)
+		exit(1)
 	}
 
+	if serveMode {
+		// The session stays logged in between runs; each run takes the run lock itself.
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		signals := make(chan os.Signal, 2)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		status := newStatusTracker(exporter, sources)
+		// NewExporter has logged in successfully.
+		status.loggedIn(nil)
+		runner := &serveRunner{
+			exporter:    exporter,
+			sources:     sources,
+			downloadDir: downloadDir,
+			reconcile:   *reconcileFlag,
+			lockWait:    *lockWaitFlag,
+			grace:       *shutdownGraceFlag,
+			signals:     signals,
+			log:         log,
+			metrics:     exporterMetrics,
+			textfile:    *metricsTextfileFlag,
+			status:      status,
+		}
+		d.run = runner.run
+		d.trigger = status.triggers
+		d.scheduled = status.scheduled
+
+		// The metrics and the status API share one server if they are served on the same address.
+		listeners := map[string]*http.ServeMux{}
+		var addrs []string
+		mux := func(addr string) *http.ServeMux {
+			if listeners[addr] == nil {
+				listeners[addr] = http.NewServeMux()
+				addrs = append(addrs, addr)
+			}
+			return listeners[addr]
+		}
+		if *metricsListenFlag != "" {
+			mux(*metricsListenFlag).Handle("/metrics", exporterMetrics.registry.Handler())
+		}
+		if *apiListenFlag != "" {
+			mux(*apiListenFlag).Handle("/", newStatusHandler(status, apiToken))
+		}
+		var servers []*http.Server
+		for _, addr := range addrs {
+			srv, err := startHTTPServer(addr, listeners[addr], log)
+			if err != nil {
+				log.Error("Failed to start HTTP listener", "address", addr, "error", err)
+				exit(1)
+			}
+			servers = append(servers, srv)
+		}
+		log.Info("Export service started", "sources", sources)
+		code := d.serve(ctx)
+		for _, srv := range servers {
+			stopHTTPServer(srv)
+		}
+		exit(code)
+	}
+
+	// Lock the whole export directory, so that runs with different sources cannot overwrite each other's files.
+	// A signal while waiting for the lock stops the wait.
+	lockCtx, stopLockWait := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	unlockRun, err = lockRun(lockCtx, downloadDir, exporter.RunID(), sources, *lockWaitFlag, log)
+	interruptedWhileWaiting := lockCtx.Err() != nil
+	stopLockWait()
+	if err != nil {
+		log.Error("Failed to lock export directory", "error", err)
+		if interruptedWhileWaiting {
+			exit(exitInterrupted)
+		}
+		exit(1)
+	}
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan int, 1)
+	log.Info("Export run started", "run_id", exporter.RunID())
+	go func() {
+		results := runExports(exporter, sources, *reconcileFlag, log)
+		exporterMetrics.recordResults(results, time.Now())
+		exporterMetrics.writeTextfile(*metricsTextfileFlag, log)
+		done <- exitCode(results)
+	}()
+	exit(awaitExports(done, signals, exporter, *shutdownGraceFlag, log))
+}
+
+// sourceResult is the outcome of exporting one source in a run.
+type sourceResult struct {
+	source     sourceType
+	stats      syndexp.ExportStats
+	err        error // syndexp.ErrInterrupted if the export was interrupted
+	duration   time.Duration
+	notStarted bool // The source was skipped because the exporter had been interrupted
+}
+
+// failed reports whether the source failed or had download or cleanup errors.
+func (r sourceResult) failed() bool {
+	return (r.err != nil && !errors.Is(r.err, syndexp.ErrInterrupted)) || r.stats.TotalErrs() > 0
+}
+
+// exitCode returns the process exit code for the results of a run: 1 if any source failed, 0 otherwise.
+func exitCode(results []sourceResult) int {
+	for _, r := range results {
+		if r.failed() {
+			return 1
+		}
+	}
+	return 0
+}
+
+// runExports exports each source in turn and returns the result of each.
+// Once the exporter has been interrupted, no further source is started.
+func runExports(exporter *syndexp.Exporter, sources []sourceType, reconcile bool, log logger.Logger) []sourceResult {
+	results := make([]sourceResult, 0, len(sources))
 	for _, source := range sources {
+		if exporter.Interrupted() {
+			log.Warn("Export skipped due to shutdown", "source", source)
+			results = append(results, sourceResult{source: source, notStarted: true})
+			continue
+		}
 		var stats syndexp.ExportStats
 		var err error
+		start := time.Now()
 		switch source {
 		case sourceMyDrive:
 			stats, err = exporter.ExportMyDrive()
@@ -215,20 +439,25 @@ func main() {
 		default:
 			continue
 		}
+		results = append(results, sourceResult{source: source, stats: stats, err: err, duration: time.Since(start)})
+		if errors.Is(err, syndexp.ErrInterrupted) {
+			log.Warn("Export interrupted; the next run resumes it", "source", source, "downloaded", stats.Downloaded, "skipped", stats.Skipped)
+			fmt.Printf("Export [%s] interrupted after downloading %d files\n", source, stats.Downloaded)
+			continue
+		}
 		if err != nil {
-			exitCode = 1
 			log.Error("Export failed", "source", source, "error", err)
 			fmt.Printf("Export [%s] failed: %v\n", source, err)
 			continue
 		}
-		log.Info("Export completed", "source", source, "downloaded", stats.Downloaded, "skipped", stats.Skipped, "ignored", stats.Ignored, "removed", stats.Removed, "download_errs", stats.DownloadErrs, "remove_errs", stats.RemoveErrs)
+		log.Info("Export completed", "source", source, "downloaded", stats.Downloaded, "skipped", stats.Skipped, "ignored", stats.Ignored, "removed", stats.Removed, "download_errs", stats.DownloadErrs, "remove_errs", stats.RemoveErrs, "mismatched", stats.Mismatched)
 		fmt.Printf("[%s] Downloaded: %d, Skipped: %d, Ignored: %d, Removed: %d, DownloadErrs: %d, RemoveErrs: %d\n",
 			source, stats.Downloaded, stats.Skipped, stats.Ignored, stats.Removed, stats.DownloadErrs, stats.RemoveErrs)
-		if stats.TotalErrs() > 0 {
-			exitCode = 1
+		if reconcile {
+			fmt.Printf("[%s] Reconciled: %d, Flagged for re-export: %d\n", source, stats.Skipped, stats.Mismatched)
 		}
 	}
 	log.Info("Export complete")
 	fmt.Println("Export complete")
-	os.Exit(exitCode)
+	return results
 }
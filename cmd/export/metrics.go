@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/isseis/go-synology-office-exporter/logger"
+	"github.com/isseis/go-synology-office-exporter/metrics"
+	synd "github.com/isseis/go-synology-office-exporter/synology_drive_api"
+)
+
+// metricsNamespace prefixes the names of all metrics of the exporter.
+const metricsNamespace = "synology_office_exporter_"
+
+// Values of the status label of the runs_total metric.
+const (
+	runStatusSuccess     = "success"
+	runStatusFailure     = "failure"
+	runStatusInterrupted = "interrupted"
+)
+
+// exporterMetrics holds the metrics of export runs and of the API calls they make.
+// It implements synd.APIObserver.
+type exporterMetrics struct {
+	registry     *metrics.Registry
+	files        *metrics.Counter
+	bytesWritten *metrics.Counter
+	runs         *metrics.Counter
+	runDuration  *metrics.Gauge
+	lastSuccess  *metrics.Gauge
+	historySize  *metrics.Gauge
+	apiCalls     *metrics.Counter
+	apiDuration  *metrics.Histogram
+	apiRetries   *metrics.Counter
+}
+
+// newExporterMetrics registers the metrics of the exporter in a new registry.
+func newExporterMetrics() *exporterMetrics {
+	r := metrics.NewRegistry()
+	return &exporterMetrics{
+		registry: r,
+		files: r.Counter(metricsNamespace+"files_total",
+			"Files processed by export runs, by source and result.", "source", "result"),
+		bytesWritten: r.Counter(metricsNamespace+"bytes_written_total",
+			"Bytes written to exported files, by source.", "source"),
+		runs: r.Counter(metricsNamespace+"runs_total",
+			"Exports of a source, by source and status (success, failure or interrupted).", "source", "status"),
+		runDuration: r.Gauge(metricsNamespace+"last_run_duration_seconds",
+			"Duration of the last export of a source.", "source"),
+		lastSuccess: r.Gauge(metricsNamespace+"last_success_timestamp_seconds",
+			"Unix time at which the last successful export of a source finished.", "source"),
+		historySize: r.Gauge(metricsNamespace+"history_entries",
+			"Entries in the download history of a source after its last export.", "source"),
+		apiCalls: r.Counter(metricsNamespace+"api_calls_total",
+			"Synology API calls, by API name, method and status (ok or error).", "api", "method", "status"),
+		apiDuration: r.Histogram(metricsNamespace+"api_call_duration_seconds",
+			"Duration of Synology API calls including retries, by API name and method.", metrics.DefaultBuckets, "api", "method"),
+		apiRetries: r.Counter(metricsNamespace+"api_retries_total",
+			"Retried Synology API requests, by API name and method.", "api", "method"),
+	}
+}
+
+// ObserveAPICall implements synd.APIObserver.
+func (m *exporterMetrics) ObserveAPICall(api synd.APIName, method string, duration time.Duration, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	m.apiCalls.Inc(string(api), method, status)
+	m.apiDuration.Observe(duration.Seconds(), string(api), method)
+}
+
+// ObserveAPIRetry implements synd.APIObserver.
+func (m *exporterMetrics) ObserveAPIRetry(api synd.APIName, method string) {
+	m.apiRetries.Inc(string(api), method)
+}
+
+// recordResults records the results of a run that finished at end.
+func (m *exporterMetrics) recordResults(results []sourceResult, end time.Time) {
+	for _, r := range results {
+		if r.notStarted {
+			continue
+		}
+		source := string(r.source)
+		for result, n := range map[string]int{
+			"downloaded":     r.stats.Downloaded,
+			"skipped":        r.stats.Skipped,
+			"ignored":        r.stats.Ignored,
+			"removed":        r.stats.Removed,
+			"download_error": r.stats.DownloadErrs,
+			"remove_error":   r.stats.RemoveErrs,
+		} {
+			m.files.Add(float64(n), source, result)
+		}
+		m.bytesWritten.Add(float64(r.stats.BytesWritten), source)
+		m.runDuration.Set(r.duration.Seconds(), source)
+
+		switch {
+		case r.failed():
+			m.runs.Inc(source, runStatusFailure)
+		case r.err != nil:
+			m.runs.Inc(source, runStatusInterrupted)
+		default:
+			m.runs.Inc(source, runStatusSuccess)
+			m.lastSuccess.Set(float64(end.Unix()), source)
+		}
+		// The history is only read if the export got as far as loading it.
+		if r.err == nil || r.stats.HistorySize > 0 {
+			m.historySize.Set(float64(r.stats.HistorySize), source)
+		}
+	}
+}
+
+// writeTextfile writes the metrics to path for the node_exporter textfile collector, if path is set.
+// A failure is logged but does not fail the run.
+func (m *exporterMetrics) writeTextfile(path string, log logger.Logger) {
+	if path == "" {
+		return
+	}
+	if err := m.registry.WriteTextfile(path); err != nil {
+		log.Error("Failed to write metrics textfile", "path", path, "error", err)
+	}
+}
+
+// startHTTPServer listens on addr and serves handler in the background.
+// Listening happens before it returns, so that an unusable address is reported at once.
+// The Addr of the returned server is the address actually listened on.
+func startHTTPServer(addr string, handler http.Handler, log logger.Logger) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Addr: ln.Addr().String(), Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("HTTP server failed", "address", addr, "error", err)
+		}
+	}()
+	log.Info("HTTP server listening", "address", srv.Addr)
+	return srv, nil
+}
+
+// stopHTTPServer shuts srv down, waiting briefly for requests in progress.
+func stopHTTPServer(srv *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = srv.Shutdown(ctx)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	synd "github.com/isseis/go-synology-office-exporter/synology_drive_api"
+	syndexp "github.com/isseis/go-synology-office-exporter/synology_drive_exporter"
+)
+
+// metricsText returns the metrics of m in the text exposition format.
+func metricsText(t *testing.T, m *exporterMetrics) string {
+	t.Helper()
+	var b strings.Builder
+	_, err := m.registry.WriteTo(&b)
+	require.NoError(t, err)
+	return b.String()
+}
+
+func TestExporterMetricsRecordResults(t *testing.T) {
+	m := newExporterMetrics()
+	end := time.Unix(1700000000, 0)
+	m.recordResults([]sourceResult{
+		{source: sourceMyDrive, stats: syndexp.ExportStats{Downloaded: 2, Skipped: 5, BytesWritten: 1024, HistorySize: 7}, duration: 1500 * time.Millisecond},
+		{source: sourceShared, stats: syndexp.ExportStats{Downloaded: 1, DownloadErrs: 1, HistorySize: 3}},
+		{source: sourceTeamFolder, err: errors.New("lock held")},
+	}, end)
+	m.recordResults([]sourceResult{
+		{source: sourceMyDrive, stats: syndexp.ExportStats{Downloaded: 1, HistorySize: 8}, err: syndexp.ErrInterrupted},
+		{source: sourceShared, notStarted: true},
+	}, end.Add(time.Hour))
+
+	text := metricsText(t, m)
+	for _, line := range []string{
+		`synology_office_exporter_files_total{source="mydrive",result="downloaded"} 3`,
+		`synology_office_exporter_files_total{source="mydrive",result="skipped"} 5`,
+		`synology_office_exporter_files_total{source="shared",result="download_error"} 1`,
+		`synology_office_exporter_bytes_written_total{source="mydrive"} 1024`,
+		`synology_office_exporter_last_run_duration_seconds{source="shared"} 0`,
+		`synology_office_exporter_runs_total{source="mydrive",status="success"} 1`,
+		`synology_office_exporter_runs_total{source="mydrive",status="interrupted"} 1`,
+		`synology_office_exporter_runs_total{source="shared",status="failure"} 1`,
+		`synology_office_exporter_runs_total{source="teamfolder",status="failure"} 1`,
+		`synology_office_exporter_last_success_timestamp_seconds{source="mydrive"} 1.7e+09`,
+		`synology_office_exporter_history_entries{source="mydrive"} 8`,
+		`synology_office_exporter_history_entries{source="shared"} 3`,
+	} {
+		assert.Contains(t, text, line+"\n")
+	}
+	assert.NotContains(t, text, `last_success_timestamp_seconds{source="shared"}`, "failed exports are not successes")
+	assert.NotContains(t, text, `history_entries{source="teamfolder"}`, "history was never loaded")
+}
+
+func TestExporterMetricsObserveAPI(t *testing.T) {
+	m := newExporterMetrics()
+	var observer synd.APIObserver = m
+	observer.ObserveAPICall(synd.APINameSynologyDriveFiles, "list", 20*time.Millisecond, nil)
+	observer.ObserveAPICall(synd.APINameSynologyDriveFiles, "list", 2*time.Second, errors.New("timeout"))
+	observer.ObserveAPIRetry(synd.APINameSynologyDriveFiles, "list")
+
+	text := metricsText(t, m)
+	assert.Contains(t, text, `synology_office_exporter_api_calls_total{api="SYNO.SynologyDrive.Files",method="list",status="ok"} 1`)
+	assert.Contains(t, text, `synology_office_exporter_api_calls_total{api="SYNO.SynologyDrive.Files",method="list",status="error"} 1`)
+	assert.Contains(t, text, `synology_office_exporter_api_call_duration_seconds_count{api="SYNO.SynologyDrive.Files",method="list"} 2`)
+	assert.Contains(t, text, `synology_office_exporter_api_retries_total{api="SYNO.SynologyDrive.Files",method="list"} 1`)
+}
+
+func TestExporterMetricsWriteTextfile(t *testing.T) {
+	m := newExporterMetrics()
+	m.recordResults([]sourceResult{{source: sourceMyDrive}}, time.Now())
+
+	path := filepath.Join(t.TempDir(), "exporter.prom")
+	m.writeTextfile(path, nopLogger{})
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `synology_office_exporter_runs_total{source="mydrive",status="success"} 1`)
+
+	// Without a path nothing is written, and write errors are only logged.
+	m.writeTextfile("", nopLogger{})
+	m.writeTextfile(filepath.Join(t.TempDir(), "missing", "exporter.prom"), nopLogger{})
+}
+
+func TestStartHTTPServer(t *testing.T) {
+	m := newExporterMetrics()
+	m.ObserveAPIRetry(synd.APINameSynologyDriveFiles, "list")
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.registry.Handler())
+
+	srv, err := startHTTPServer("127.0.0.1:0", mux, nopLogger{})
+	require.NoError(t, err)
+	defer stopHTTPServer(srv)
+
+	resp, err := http.Get("http://" + srv.Addr + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), "synology_office_exporter_api_retries_total")
+
+	_, err = startHTTPServer("bad address", mux, nopLogger{})
+	assert.Error(t, err)
+}
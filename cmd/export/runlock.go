@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/isseis/go-synology-office-exporter/filelock"
+	"github.com/isseis/go-synology-office-exporter/logger"
+)
+
+// runLockName is the path, relative to the export directory, locked for a whole export run.
+// The lock file itself is this name with the ".lock" suffix added by filelock.
+const runLockName = ".synology-office-exporter-run"
+
+// Details recorded in the run lock.
+const (
+	runLockRunID   = "run_id"
+	runLockSources = "sources"
+)
+
+// runLockPath returns the path locked for export runs into downloadDir.
+func runLockPath(downloadDir string) string {
+	return filepath.Join(downloadDir, runLockName)
+}
+
+// lockRun takes the lock for a whole export run into downloadDir, so that runs with different sources
+// cannot write to the same directory at the same time. It waits up to wait for a run holding the lock,
+// or fails immediately if wait is zero. The lock records the run ID and sources of this run.
+func lockRun(ctx context.Context, downloadDir, runID string, sources []sourceType, wait time.Duration, log logger.Logger) (func(), error) {
+	names := make([]string, len(sources))
+	for i, s := range sources {
+		names[i] = string(s)
+	}
+	opts := []filelock.Option{
+		filelock.WithLogger(log),
+		filelock.WithDetails(map[string]string{
+			runLockRunID:   runID,
+			runLockSources: strings.Join(names, ","),
+		}),
+	}
+
+	path := runLockPath(downloadDir)
+	var unlock func()
+	var err error
+	if wait > 0 {
+		unlock, err = filelock.Lock(ctx, path, append(opts, filelock.WithTimeout(wait))...)
+	} else {
+		unlock, err = filelock.TryLock(path, opts...)
+	}
+	if errors.Is(err, filelock.ErrLockHeld) {
+		if info, infoErr := filelock.ReadLockInfo(path); infoErr == nil {
+			return nil, fmt.Errorf("another export run is using %s (PID %d on %s since %s, sources %s): %w",
+				downloadDir, info.PID, info.Hostname, info.Timestamp, info.Details[runLockSources], err)
+		}
+		return nil, fmt.Errorf("another export run is using %s: %w", downloadDir, err)
+	}
+	return unlock, err
+}
+
+// runLockStatus implements the lock-status command, which reports the holders of the run lock and history locks
+// of an export directory. It returns the process exit code.
+func runLockStatus(args []string, stdout, stderr io.Writer) int {
+	opts := &historyOptions{}
+	fs := flag.NewFlagSet("lock-status", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&opts.downloadDir, "output", "", "Directory containing the exported files and history (default: $SYNOLOGY_DOWNLOAD_DIR or current directory)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "Unexpected arguments: %v\n", fs.Args())
+		return 2
+	}
+
+	downloadDir, err := filepath.Abs(opts.resolveDownloadDir())
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to resolve export directory: %v\n", err)
+		return 1
+	}
+
+	fmt.Fprintf(stdout, "Export directory: %s\n", downloadDir)
+	if err := printLockStatus(stdout, "Run lock", runLockPath(downloadDir)); err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	for _, source := range defaultSources() {
+		name := historyFileForSource(source)
+		if err := printLockStatus(stdout, "History lock ("+string(source)+")", filepath.Join(downloadDir, name)); err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// printLockStatus writes whether the lock for path is held and, if so, the details of its holder.
+func printLockStatus(w io.Writer, title, path string) error {
+	held, err := filelock.IsHeld(path)
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", title, err)
+	}
+	if !held {
+		fmt.Fprintf(w, "%s: free\n", title)
+		return nil
+	}
+	fmt.Fprintf(w, "%s: held\n", title)
+	info, err := filelock.ReadLockInfo(path)
+	if err != nil {
+		// The holder may have released the lock in the meantime.
+		fmt.Fprintf(w, "  Holder:        unknown (%v)\n", err)
+		return nil
+	}
+	fmt.Fprintf(w, "  PID:           %d\n", info.PID)
+	fmt.Fprintf(w, "  Host:          %s\n", info.Hostname)
+	fmt.Fprintf(w, "  Started:       %s\n", info.Timestamp)
+	for _, key := range slices.Sorted(maps.Keys(info.Details)) {
+		fmt.Fprintf(w, "  %-14s %s\n", key+":", info.Details[key])
+	}
+	return nil
+}
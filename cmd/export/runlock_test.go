@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/isseis/go-synology-office-exporter/filelock"
+	syndexp "github.com/isseis/go-synology-office-exporter/synology_drive_exporter"
+)
+
+func TestLockRun(t *testing.T) {
+	dir := t.TempDir()
+	unlock, err := lockRun(context.Background(), dir, "run1", []sourceType{sourceMyDrive, sourceShared}, 0, nopLogger{})
+	require.NoError(t, err)
+
+	info, err := filelock.ReadLockInfo(runLockPath(dir))
+	require.NoError(t, err)
+	assert.Equal(t, os.Getpid(), info.PID)
+	assert.Equal(t, "run1", info.Details[runLockRunID])
+	assert.Equal(t, "mydrive,shared", info.Details[runLockSources])
+
+	// Another run fails, even with different sources, and names the holder.
+	_, err = lockRun(context.Background(), dir, "run2", []sourceType{sourceTeamFolder}, 0, nopLogger{})
+	require.ErrorIs(t, err, filelock.ErrLockHeld)
+	assert.Contains(t, err.Error(), fmt.Sprintf("PID %d", os.Getpid()))
+	assert.Contains(t, err.Error(), "sources mydrive,shared")
+
+	// A waiting run acquires the lock once the holder finishes.
+	time.AfterFunc(100*time.Millisecond, unlock)
+	unlock2, err := lockRun(context.Background(), dir, "run2", []sourceType{sourceTeamFolder}, 10*time.Second, nopLogger{})
+	require.NoError(t, err)
+	unlock2()
+
+	t.Run("wait is cancelled", func(t *testing.T) {
+		unlock, err := lockRun(context.Background(), dir, "run1", []sourceType{sourceMyDrive}, 0, nopLogger{})
+		require.NoError(t, err)
+		defer unlock()
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		_, err = lockRun(ctx, dir, "run2", []sourceType{sourceMyDrive}, time.Minute, nopLogger{})
+		assert.True(t, errors.Is(err, context.Canceled), "got %v", err)
+	})
+}
+
+func TestRunLockStatus(t *testing.T) {
+	dir := t.TempDir()
+	run := func(args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := runLockStatus(args, &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	code, out, _ := run("-output", dir)
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out, "Run lock: free")
+	assert.Contains(t, out, "History lock (mydrive): free")
+
+	unlock, err := lockRun(context.Background(), dir, "run1", []sourceType{sourceTeamFolder}, 0, nopLogger{})
+	require.NoError(t, err)
+	defer unlock()
+	unlockHistory, err := filelock.TryLock(filepath.Join(dir, syndexp.TeamFolderHistoryFile))
+	require.NoError(t, err)
+	defer unlockHistory()
+
+	code, out, _ = run("-output", dir)
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out, "Run lock: held")
+	assert.Contains(t, out, fmt.Sprintf("PID:           %d", os.Getpid()))
+	assert.Contains(t, out, "sources:       teamfolder")
+	assert.Contains(t, out, "run_id:        run1")
+	assert.Contains(t, out, "History lock (teamfolder): held")
+	assert.Contains(t, out, "History lock (shared): free")
+
+	code, _, _ = run("-output", dir, "extra")
+	assert.Equal(t, 2, code)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntervalSchedule(t *testing.T) {
+	start := time.Date(2024, 5, 7, 2, 0, 0, 0, time.UTC)
+	s := intervalSchedule{start: start, interval: 15 * time.Minute}
+
+	assert.Equal(t, start, s.next(start.Add(-time.Hour)))
+	assert.Equal(t, start.Add(15*time.Minute), s.next(start))
+	// A run that took longer than the interval skips the missed start times.
+	assert.Equal(t, start.Add(45*time.Minute), s.next(start.Add(40*time.Minute)))
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr bool
+	}{
+		{"every minute", "* * * * *", false},
+		{"steps and lists", "*/15 8-18 * * mon-fri", false},
+		{"range with step", "0-30/10 0,12 1 jan,jul *", false},
+		{"descriptor", "@daily", false},
+		{"sunday as 7", "0 0 * * 7", false},
+		{"too few fields", "* * * *", true},
+		{"minute out of range", "60 * * * *", true},
+		{"zero step", "*/0 * * * *", true},
+		{"reversed range", "0 5-1 * * *", true},
+		{"unknown name", "0 0 * foo *", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseCron(tt.expr)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	// 2024-05-07 is a Tuesday.
+	base := time.Date(2024, 5, 7, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		name string
+		expr string
+		want time.Time
+	}{
+		{"every 15 minutes", "*/15 * * * *", time.Date(2024, 5, 7, 10, 15, 0, 0, time.UTC)},
+		{"next minute", "* * * * *", time.Date(2024, 5, 7, 10, 8, 0, 0, time.UTC)},
+		{"daily", "@daily", time.Date(2024, 5, 8, 0, 0, 0, 0, time.UTC)},
+		{"hour rolls over", "5 * * * *", time.Date(2024, 5, 7, 11, 5, 0, 0, time.UTC)},
+		{"weekday", "30 2 * * sat", time.Date(2024, 5, 11, 2, 30, 0, 0, time.UTC)},
+		{"sunday as 7", "0 0 * * 7", time.Date(2024, 5, 12, 0, 0, 0, 0, time.UTC)},
+		{"next month", "0 3 1 * *", time.Date(2024, 6, 1, 3, 0, 0, 0, time.UTC)},
+		{"next year", "0 0 1 jan *", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// With both day fields restricted, either one matches.
+		{"day of month or week", "0 0 10 * mon", time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)},
+		{"leap day", "0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"never", "0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseCron(tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, s.next(base))
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/isseis/go-synology-office-exporter/filelock"
+	"github.com/isseis/go-synology-office-exporter/logger"
+	synd "github.com/isseis/go-synology-office-exporter/synology_drive_api"
+	syndexp "github.com/isseis/go-synology-office-exporter/synology_drive_exporter"
+)
+
+// Defaults of the scheduling flags of the serve command.
+const (
+	defaultServeInterval     = 15 * time.Minute
+	defaultFailureBackoff    = 5 * time.Minute
+	defaultMaxFailureBackoff = time.Hour
+)
+
+// serveOptions holds the scheduling flags of the serve command.
+type serveOptions struct {
+	interval   time.Duration
+	cron       string
+	jitter     time.Duration
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// registerServeFlags defines the scheduling flags of the serve command on fs.
+func registerServeFlags(fs *flag.FlagSet) *serveOptions {
+	o := &serveOptions{}
+	fs.DurationVar(&o.interval, "interval", defaultServeInterval, "serve: Start a run this often, beginning at startup (ignored if -cron is set)")
+	fs.StringVar(&o.cron, "cron", "", "serve: Start runs at the times matching this cron expression, e.g. \"*/15 * * * *\" (local time)")
+	fs.DurationVar(&o.jitter, "jitter", 0, "serve: Delay each scheduled run by a random duration up to this long (0 disables)")
+	fs.DurationVar(&o.backoff, "failure-backoff", defaultFailureBackoff, "serve: After a failed run, wait at least this long before the next run, doubling on each further failure (0 disables)")
+	fs.DurationVar(&o.maxBackoff, "max-failure-backoff", defaultMaxFailureBackoff, "serve: Upper limit of the wait after failed runs")
+	return o
+}
+
+// newDaemon returns a daemon scheduled by the options, starting at now. run performs one export run.
+func (o *serveOptions) newDaemon(now time.Time, run func(context.Context) int, log logger.Logger) (*daemon, error) {
+	if o.jitter < 0 || o.backoff < 0 || o.maxBackoff < 0 {
+		return nil, errors.New("-jitter, -failure-backoff and -max-failure-backoff must not be negative")
+	}
+	d := &daemon{
+		jitter:     o.jitter,
+		backoff:    o.backoff,
+		maxBackoff: o.maxBackoff,
+		run:        run,
+		log:        log,
+		now:        time.Now,
+		random:     func(n int64) int64 { return rand.Int64N(n) },
+	}
+	if o.cron != "" {
+		s, err := parseCron(o.cron)
+		if err != nil {
+			return nil, err
+		}
+		d.schedule = s
+		return d, nil
+	}
+	if o.interval <= 0 {
+		return nil, errors.New("-interval must be positive")
+	}
+	d.schedule = intervalSchedule{start: now, interval: o.interval}
+	d.runAtStart = true
+	return d, nil
+}
+
+// daemon starts export runs on a schedule, one at a time, until it is stopped.
+type daemon struct {
+	schedule   schedule
+	runAtStart bool          // Start the first run immediately instead of at the first scheduled time
+	jitter     time.Duration // Maximum random delay added to each scheduled run
+	backoff    time.Duration // Minimum wait after a failed run, doubled on each further failure
+	maxBackoff time.Duration // Upper limit of the wait after failed runs; 0 means no limit
+	run        func(ctx context.Context) int
+	log        logger.Logger
+	now        func() time.Time
+	random     func(n int64) int64  // Returns a random number in [0, n)
+	trigger    <-chan struct{}      // Receives requests for an immediate run; nil if runs are never triggered
+	scheduled  func(next time.Time) // Called with the start of the next run; nil if not needed
+}
+
+// failureBackoff returns the minimum wait after the given number of consecutive failed runs.
+func (d *daemon) failureBackoff(failures int) time.Duration {
+	if failures == 0 || d.backoff == 0 {
+		return 0
+	}
+	delay := d.backoff
+	for i := 1; i < failures && (d.maxBackoff == 0 || delay < d.maxBackoff); i++ {
+		delay *= 2
+	}
+	if d.maxBackoff > 0 {
+		delay = min(delay, d.maxBackoff)
+	}
+	return delay
+}
+
+// nextRun returns when the next run starts after a run that ended at now, given the number of consecutive
+// failed runs. Scheduled times within the failure backoff are skipped. Returns the zero time if the schedule
+// has no further runs.
+func (d *daemon) nextRun(now time.Time, failures int) time.Time {
+	next := d.schedule.next(now)
+	notBefore := now.Add(d.failureBackoff(failures))
+	for !next.IsZero() && next.Before(notBefore) {
+		next = d.schedule.next(next)
+	}
+	if next.IsZero() {
+		return next
+	}
+	if d.jitter > 0 {
+		next = next.Add(time.Duration(d.random(int64(d.jitter))))
+	}
+	return next
+}
+
+// serve starts runs until ctx is cancelled, or until a run is stopped by a signal, and returns the exit code.
+// Each run starts only after the previous one has finished, so runs never overlap; scheduled times missed
+// during a long run are skipped. A request on the trigger channel starts a run before its scheduled time.
+func (d *daemon) serve(ctx context.Context) int {
+	next := d.now()
+	if !d.runAtStart {
+		next = d.nextRun(next, 0)
+	}
+	failures := 0
+	for {
+		if next.IsZero() {
+			d.log.Error("Schedule has no further runs")
+			return 1
+		}
+		wait := max(next.Sub(d.now()), 0)
+		d.log.Info("Next export run scheduled", "at", next.Format(time.RFC3339), "in", wait.Round(time.Second))
+		if d.scheduled != nil {
+			d.scheduled(next)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+			// A run requested just before the scheduled time is satisfied by this run.
+			select {
+			case <-d.trigger:
+			default:
+			}
+		case <-d.trigger:
+			timer.Stop()
+			d.log.Info("Export run triggered")
+		}
+		if ctx.Err() != nil {
+			d.log.Info("Export service stopped")
+			return 0
+		}
+
+		switch code := d.run(ctx); code {
+		case 0:
+			failures = 0
+		case exitInterrupted:
+			d.log.Info("Export service stopped")
+			return exitInterrupted
+		default:
+			failures++
+			d.log.Warn("Export run failed", "consecutive_failures", failures, "backoff", d.failureBackoff(failures))
+		}
+		next = d.nextRun(d.now(), failures)
+	}
+}
+
+// serveRunner performs the runs of the serve command. It keeps one exporter, and therefore one logged-in
+// session, for all runs.
+type serveRunner struct {
+	exporter    *syndexp.Exporter
+	sources     []sourceType
+	downloadDir string
+	reconcile   bool
+	lockWait    time.Duration
+	grace       time.Duration
+	signals     <-chan os.Signal
+	log         logger.Logger
+	metrics     *exporterMetrics
+	textfile    string // Metrics textfile written after each run; empty if not set
+	status      *statusTracker
+	relogin     bool // The previous run failed, possibly because the session expired
+}
+
+// run performs one export run under a new run ID, logs its summary and returns its exit code.
+// The logs of the run are flushed to the webhook when it ends.
+func (r *serveRunner) run(ctx context.Context) int {
+	runID := r.exporter.NewRun()
+	start := time.Now()
+	r.status.runStarted(runID)
+	code, results := r.export(ctx, runID)
+	r.status.runFinished(code, results)
+	r.relogin = code != 0
+	logRunSummary(r.log, runID, time.Since(start), code, results)
+	r.metrics.recordResults(results, time.Now())
+	r.metrics.writeTextfile(r.textfile, r.log)
+	// Deliver the logs of each run instead of holding them until the service stops.
+	if err := r.log.FlushWebhook(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to flush webhook logs: %v\n", err)
+	}
+	return code
+}
+
+// export performs the run identified by runID while holding the run lock of the export directory.
+// It returns the exit code and the result of each source, or nil results if the run did not finish.
+func (r *serveRunner) export(ctx context.Context, runID string) (int, []sourceResult) {
+	if err := r.ensureSession(); err != nil {
+		r.log.Error("Failed to log in", "error", err)
+		return 1, nil
+	}
+
+	unlock, err := lockRun(ctx, r.downloadDir, runID, r.sources, r.lockWait, r.log)
+	if err != nil {
+		if ctx.Err() != nil {
+			return exitInterrupted, nil
+		}
+		if errors.Is(err, filelock.ErrLockHeld) {
+			holder, _ := filelock.ReadLockInfo(runLockPath(r.downloadDir))
+			r.status.lockHeld(holder)
+		}
+		r.log.Error("Failed to lock export directory", "error", err)
+		return 1, nil
+	}
+	defer unlock()
+	r.status.lockAcquired()
+
+	r.log.Info("Export run started", "run_id", runID)
+	results := make(chan []sourceResult, 1)
+	done := make(chan int, 1)
+	go func() {
+		res := runExports(r.exporter, r.sources, r.reconcile, r.log)
+		results <- res
+		done <- exitCode(res)
+	}()
+	code := awaitExports(done, r.signals, r.exporter, r.grace, r.log)
+	select {
+	case res := <-results:
+		return code, res
+	default:
+		// The run was aborted before it finished.
+		return code, nil
+	}
+}
+
+// ensureSession makes sure the session is logged in before a run. DSM ends idle sessions after a timeout that
+// is often shorter than the schedule, so the session is checked with one API call and logged in again if the
+// NAS reports that it expired. After a failed run, it logs in again without checking.
+func (r *serveRunner) ensureSession() error {
+	if !r.relogin {
+		err := r.exporter.CheckSession()
+		if !synd.IsSessionExpired(err) {
+			// Other errors are left to the run, which reports them for each source.
+			return nil
+		}
+		r.log.Info("Session expired; logging in again", "error", err)
+	}
+	err := r.exporter.Login()
+	r.status.loggedIn(err)
+	if err != nil {
+		return err
+	}
+	if r.relogin {
+		r.log.Info("Logged in again after a failed run")
+	}
+	return nil
+}
+
+// logRunSummary logs the totals of one run of the serve command.
+func logRunSummary(log logger.Logger, runID string, duration time.Duration, code int, results []sourceResult) {
+	var total syndexp.ExportStats
+	var failed []string
+	for _, r := range results {
+		total.Downloaded += r.stats.Downloaded
+		total.Skipped += r.stats.Skipped
+		total.Ignored += r.stats.Ignored
+		total.Removed += r.stats.Removed
+		total.DownloadErrs += r.stats.DownloadErrs
+		total.RemoveErrs += r.stats.RemoveErrs
+		if r.failed() {
+			failed = append(failed, string(r.source))
+		}
+	}
+	args := []any{
+		"run_id", runID,
+		"duration", duration.Round(time.Millisecond),
+		"downloaded", total.Downloaded,
+		"skipped", total.Skipped,
+		"ignored", total.Ignored,
+		"removed", total.Removed,
+		"download_errs", total.DownloadErrs,
+		"remove_errs", total.RemoveErrs,
+	}
+	switch code {
+	case 0:
+		log.Info("Export run succeeded", args...)
+	case exitInterrupted:
+		log.Warn("Export run interrupted", args...)
+	default:
+		log.Error("Export run failed", append(args, "failed_sources", strings.Join(failed, ","))...)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	syndexp "github.com/isseis/go-synology-office-exporter/synology_drive_exporter"
+)
+
+func TestServeOptionsNewDaemon(t *testing.T) {
+	now := time.Date(2024, 5, 7, 2, 0, 0, 0, time.UTC)
+
+	d, err := (&serveOptions{interval: time.Minute}).newDaemon(now, nil, nopLogger{})
+	require.NoError(t, err)
+	assert.True(t, d.runAtStart)
+	assert.Equal(t, intervalSchedule{start: now, interval: time.Minute}, d.schedule)
+
+	d, err = (&serveOptions{interval: time.Minute, cron: "@hourly"}).newDaemon(now, nil, nopLogger{})
+	require.NoError(t, err)
+	assert.False(t, d.runAtStart)
+	assert.IsType(t, &cronSchedule{}, d.schedule)
+
+	_, err = (&serveOptions{}).newDaemon(now, nil, nopLogger{})
+	assert.Error(t, err)
+	_, err = (&serveOptions{cron: "bad"}).newDaemon(now, nil, nopLogger{})
+	assert.Error(t, err)
+	_, err = (&serveOptions{interval: time.Minute, jitter: -time.Second}).newDaemon(now, nil, nopLogger{})
+	assert.Error(t, err)
+}
+
+func TestDaemonNextRun(t *testing.T) {
+	start := time.Date(2024, 5, 7, 2, 0, 0, 0, time.UTC)
+	d := &daemon{
+		schedule:   intervalSchedule{start: start, interval: 15 * time.Minute},
+		backoff:    10 * time.Minute,
+		maxBackoff: time.Hour,
+		random:     func(n int64) int64 { return n / 2 },
+	}
+	end := start.Add(5 * time.Minute)
+
+	assert.Equal(t, start.Add(15*time.Minute), d.nextRun(end, 0))
+	// The backoff doubles with each failure and skips scheduled times within it.
+	assert.Equal(t, start.Add(15*time.Minute), d.nextRun(end, 1))
+	assert.Equal(t, start.Add(30*time.Minute), d.nextRun(end, 2))
+	assert.Equal(t, start.Add(45*time.Minute), d.nextRun(end, 3))
+	// The backoff is capped.
+	assert.Equal(t, time.Hour, d.failureBackoff(10))
+	assert.Equal(t, start.Add(75*time.Minute), d.nextRun(end, 10))
+
+	d.jitter = 2 * time.Minute
+	assert.Equal(t, start.Add(16*time.Minute), d.nextRun(end, 0))
+}
+
+func TestDaemonServe(t *testing.T) {
+	newDaemon := func(run func(context.Context) int) *daemon {
+		return &daemon{
+			schedule:   intervalSchedule{start: time.Now(), interval: time.Millisecond},
+			runAtStart: true,
+			backoff:    time.Millisecond,
+			maxBackoff: 4 * time.Millisecond,
+			run:        run,
+			log:        nopLogger{},
+			now:        time.Now,
+		}
+	}
+
+	t.Run("runs until cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		runs := 0
+		running := false
+		d := newDaemon(func(context.Context) int {
+			assert.False(t, running, "runs overlap")
+			running = true
+			defer func() { running = false }()
+			runs++
+			if runs == 3 {
+				cancel()
+			}
+			return runs % 2 // Alternate between failure and success
+		})
+		assert.Equal(t, 0, d.serve(ctx))
+		assert.Equal(t, 3, runs)
+	})
+
+	t.Run("stops after interrupted run", func(t *testing.T) {
+		runs := 0
+		d := newDaemon(func(context.Context) int {
+			runs++
+			return exitInterrupted
+		})
+		assert.Equal(t, exitInterrupted, d.serve(context.Background()))
+		assert.Equal(t, 1, runs)
+	})
+
+	t.Run("cron schedule waits for first time", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		d := newDaemon(func(context.Context) int {
+			t.Error("unexpected run")
+			return 0
+		})
+		d.runAtStart = false
+		d.schedule, _ = parseCron("0 0 1 1 *")
+		time.AfterFunc(20*time.Millisecond, cancel)
+		assert.Equal(t, 0, d.serve(ctx))
+	})
+}
+
+// recordingLogger records the level and message of each log entry.
+type recordingLogger struct {
+	nopLogger
+	entries []string
+	args    [][]interface{}
+}
+
+func (l *recordingLogger) record(level, msg string, args []interface{}) {
+	l.entries = append(l.entries, level+": "+msg)
+	l.args = append(l.args, args)
+}
+
+func (l *recordingLogger) Info(msg string, args ...interface{})  { l.record("INFO", msg, args) }
+func (l *recordingLogger) Warn(msg string, args ...interface{})  { l.record("WARN", msg, args) }
+func (l *recordingLogger) Error(msg string, args ...interface{}) { l.record("ERROR", msg, args) }
+
+func TestLogRunSummary(t *testing.T) {
+	results := []sourceResult{
+		{source: sourceMyDrive, stats: syndexp.ExportStats{Downloaded: 2, Skipped: 3}},
+		{source: sourceShared, stats: syndexp.ExportStats{Downloaded: 1, DownloadErrs: 1}},
+		{source: sourceTeamFolder, err: errors.New("list failed")},
+	}
+
+	log := &recordingLogger{}
+	logRunSummary(log, "run1", time.Second, 0, results[:1])
+	logRunSummary(log, "run2", time.Second, 1, results)
+	// Summaries of aborted runs have no results.
+	logRunSummary(log, "run3", time.Second, exitInterrupted, nil)
+
+	assert.Equal(t, []string{"INFO: Export run succeeded", "ERROR: Export run failed", "WARN: Export run interrupted"}, log.entries)
+	assert.Subset(t, log.args[1], []interface{}{"run_id", "run2", "downloaded", 3, "skipped", 3, "download_errs", 1, "failed_sources", "shared,teamfolder"})
+}
+
+// sessionNAS is a fake NAS whose session can be expired, after which the Drive API rejects requests with
+// code 119 until the next login. It records the login and list requests it receives.
+type sessionNAS struct {
+	mu       sync.Mutex
+	expired  bool
+	requests []string
+}
+
+func (n *sessionNAS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	r.ParseForm()
+	method := r.Form.Get("method")
+	switch {
+	case method == "login":
+		n.expired = false
+		n.requests = append(n.requests, "login")
+		w.Write([]byte(`{"success": true, "data": {"sid": "sid"}}`))
+	case n.expired:
+		n.requests = append(n.requests, method+": 119")
+		w.Write([]byte(`{"success": false, "error": {"code": 119}}`))
+	default:
+		n.requests = append(n.requests, method)
+		w.Write([]byte(`{"success": true, "data": {"total": 0, "items": []}}`))
+	}
+}
+
+func (n *sessionNAS) expire() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.expired = true
+	n.requests = nil
+}
+
+func TestServeRunnerLogsInAgainWhenSessionExpired(t *testing.T) {
+	nas := &sessionNAS{}
+	srv := httptest.NewServer(nas)
+	t.Cleanup(srv.Close)
+	downloadDir := t.TempDir()
+	exporter, err := syndexp.NewExporter("user", "pass", srv.URL, downloadDir)
+	require.NoError(t, err)
+	sources := []sourceType{sourceMyDrive}
+	r := &serveRunner{
+		exporter:    exporter,
+		sources:     sources,
+		downloadDir: downloadDir,
+		log:         nopLogger{},
+		metrics:     newExporterMetrics(),
+		status:      newStatusTracker(exporter, sources),
+	}
+
+	require.Equal(t, 0, r.run(context.Background()))
+	assert.Equal(t, []string{"login", "list", "list"}, nas.requests, "the session is checked before the run")
+
+	// The session times out between runs; the run logs in again and still succeeds.
+	nas.expire()
+	assert.Equal(t, 0, r.run(context.Background()))
+	assert.Equal(t, []string{"list: 119", "login", "list"}, nas.requests)
+	assert.False(t, r.relogin)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/isseis/go-synology-office-exporter/logger"
+)
+
+// exitInterrupted is the exit code used when the export is stopped by SIGINT or SIGTERM (128 + SIGINT).
+const exitInterrupted = 130
+
+// defaultShutdownGrace is the default time to wait for the file being exported after a signal.
+// It is shorter than the 10 seconds docker stop waits before sending SIGKILL.
+const defaultShutdownGrace = 8 * time.Second
+
+// interruptible is the part of the exporter used to stop it on a signal.
+type interruptible interface {
+	Interrupt()
+	Abort() error
+}
+
+// awaitExports waits for the exports running in the background to report their exit code on done.
+// If a signal arrives first, it interrupts the exporter so that no new file is started and waits up to grace
+// for the file being exported. Once the grace period expires, or on a second signal, the export in progress
+// is aborted: its history is saved as a checkpoint and its lock is released without waiting any longer.
+// Returns the exit code of the exports, or exitInterrupted if a signal was received.
+func awaitExports(done <-chan int, signals <-chan os.Signal, exporter interruptible, grace time.Duration, log logger.Logger) int {
+	var sig os.Signal
+	select {
+	case code := <-done:
+		return code
+	case sig = <-signals:
+	}
+
+	log.Warn("Received signal; finishing the current file before shutting down", "signal", sig.String(), "grace_period", grace)
+	exporter.Interrupt()
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-done:
+		log.Info("Export stopped after signal", "signal", sig.String())
+		return exitInterrupted
+	case <-timer.C:
+		log.Error("Grace period expired; aborting the export in progress", "grace_period", grace)
+	case sig = <-signals:
+		log.Error("Received second signal; aborting the export in progress", "signal", sig.String())
+	}
+	if err := exporter.Abort(); err != nil {
+		log.Error("Failed to save history checkpoint while aborting", "error", err)
+	}
+	return exitInterrupted
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// nopLogger discards all log messages.
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+func (nopLogger) FlushWebhook() error          { return nil }
+
+// fakeExporter records calls made by awaitExports.
+type fakeExporter struct {
+	interrupted chan struct{}
+	aborted     bool
+}
+
+func newFakeExporter() *fakeExporter {
+	return &fakeExporter{interrupted: make(chan struct{})}
+}
+
+func (f *fakeExporter) Interrupt() { close(f.interrupted) }
+
+func (f *fakeExporter) Abort() error {
+	f.aborted = true
+	return nil
+}
+
+func TestAwaitExports(t *testing.T) {
+	log := nopLogger{}
+
+	t.Run("exports finish without signal", func(t *testing.T) {
+		done := make(chan int, 1)
+		done <- 1
+		exporter := newFakeExporter()
+		code := awaitExports(done, make(chan os.Signal), exporter, time.Second, log)
+		assert.Equal(t, 1, code)
+		assert.False(t, exporter.aborted)
+	})
+
+	t.Run("signal waits for the current file", func(t *testing.T) {
+		done := make(chan int, 1)
+		signals := make(chan os.Signal, 1)
+		exporter := newFakeExporter()
+		go func() {
+			<-exporter.interrupted
+			done <- 0
+		}()
+		signals <- syscall.SIGTERM
+		code := awaitExports(done, signals, exporter, time.Minute, log)
+		assert.Equal(t, exitInterrupted, code)
+		assert.False(t, exporter.aborted)
+	})
+
+	t.Run("grace period expiry aborts", func(t *testing.T) {
+		signals := make(chan os.Signal, 1)
+		signals <- syscall.SIGINT
+		exporter := newFakeExporter()
+		code := awaitExports(make(chan int), signals, exporter, 10*time.Millisecond, log)
+		assert.Equal(t, exitInterrupted, code)
+		assert.True(t, exporter.aborted)
+	})
+
+	t.Run("second signal aborts", func(t *testing.T) {
+		signals := make(chan os.Signal, 2)
+		signals <- syscall.SIGINT
+		signals <- syscall.SIGINT
+		exporter := newFakeExporter()
+		code := awaitExports(make(chan int), signals, exporter, time.Minute, log)
+		assert.Equal(t, exitInterrupted, code)
+		assert.True(t, exporter.aborted)
+	})
+}
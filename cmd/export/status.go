@@ -0,0 +1,420 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/isseis/go-synology-office-exporter/filelock"
+	syndexp "github.com/isseis/go-synology-office-exporter/synology_drive_exporter"
+)
+
+// defaultLockStuckAfter is how long another process may hold the run lock before the service reports it as stuck.
+const defaultLockStuckAfter = 6 * time.Hour
+
+// progressReporter is the part of the exporter used to report the run in progress.
+type progressReporter interface {
+	Progress() (syndexp.Progress, bool)
+}
+
+// loginStatus is the outcome of the last login to the NAS.
+type loginStatus struct {
+	OK    bool      `json:"ok"`
+	At    time.Time `json:"at"`
+	Error string    `json:"error,omitempty"`
+}
+
+// sourceSummary is the outcome of exporting one source in a run.
+type sourceSummary struct {
+	Source          sourceType `json:"source"`
+	Status          string     `json:"status"`
+	Downloaded      int        `json:"downloaded"`
+	Skipped         int        `json:"skipped"`
+	Ignored         int        `json:"ignored"`
+	Removed         int        `json:"removed"`
+	DownloadErrors  int        `json:"download_errors"`
+	RemoveErrors    int        `json:"remove_errors"`
+	BytesWritten    int64      `json:"bytes_written"`
+	HistorySize     int        `json:"history_size"`
+	DurationSeconds float64    `json:"duration_seconds"`
+	Error           string     `json:"error,omitempty"`
+}
+
+// runSummary is the outcome of a finished run.
+type runSummary struct {
+	RunID    string          `json:"run_id"`
+	Started  time.Time       `json:"started"`
+	Finished time.Time       `json:"finished"`
+	Status   string          `json:"status"`
+	Sources  []sourceSummary `json:"sources"`
+}
+
+// currentRun describes the run in progress.
+type currentRun struct {
+	RunID          string     `json:"run_id"`
+	Started        time.Time  `json:"started"`
+	Source         sourceType `json:"source,omitempty"`
+	SourceStarted  *time.Time `json:"source_started,omitempty"`
+	CurrentPath    string     `json:"current_path,omitempty"`
+	Downloaded     int        `json:"downloaded"`
+	Skipped        int        `json:"skipped"`
+	Ignored        int        `json:"ignored"`
+	Errors         int        `json:"errors"`
+	SourcesDone    int        `json:"sources_done"`
+	SourcesTotal   int        `json:"sources_total"`
+	WaitingForLock bool       `json:"waiting_for_lock,omitempty"`
+}
+
+// serviceStatus is the state of the serve command reported by the status API.
+type serviceStatus struct {
+	Version  string      `json:"version"`
+	Started  time.Time   `json:"started"`
+	Ready    bool        `json:"ready"`
+	Problems []string    `json:"problems,omitempty"`
+	Login    loginStatus `json:"login"`
+	NextRun  *time.Time  `json:"next_run,omitempty"`
+	Current  *currentRun `json:"current_run,omitempty"`
+	LastRun  *runSummary `json:"last_run,omitempty"`
+}
+
+// statusTracker records the state of the serve command for the status API.
+// It is safe for concurrent use.
+type statusTracker struct {
+	mu             sync.Mutex
+	started        time.Time
+	sources        []sourceType
+	lockStuckAfter time.Duration
+	exporter       progressReporter
+	login          loginStatus
+	nextRun        time.Time
+	current        *currentRun        // Nil between runs
+	lastRun        *runSummary        // Nil before the first run has finished
+	lockHolder     *filelock.LockInfo // Holder of the run lock seen by the last run that failed to take it; nil once a run takes it
+	lockHeldSince  time.Time          // When lockHolder took the lock, or when it was seen if that is unknown
+	triggers       chan struct{}
+	now            func() time.Time
+}
+
+// newStatusTracker returns a tracker for runs of exporter exporting sources.
+func newStatusTracker(exporter progressReporter, sources []sourceType) *statusTracker {
+	return &statusTracker{
+		started:        time.Now(),
+		sources:        sources,
+		lockStuckAfter: defaultLockStuckAfter,
+		exporter:       exporter,
+		triggers:       make(chan struct{}, 1),
+		now:            time.Now,
+	}
+}
+
+// loggedIn records the outcome of a login to the NAS.
+func (s *statusTracker) loggedIn(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.login = loginStatus{OK: err == nil, At: s.now()}
+	if err != nil {
+		s.login.Error = err.Error()
+	}
+}
+
+// scheduled records when the next run starts.
+func (s *statusTracker) scheduled(next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextRun = next
+}
+
+// runStarted records the start of the run identified by runID, which waits for the run lock until lockAcquired.
+func (s *statusTracker) runStarted(runID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current = &currentRun{RunID: runID, Started: s.now(), SourcesTotal: len(s.sources), WaitingForLock: true}
+}
+
+// lockAcquired records that the run in progress holds the run lock.
+func (s *statusTracker) lockAcquired() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current != nil {
+		s.current.WaitingForLock = false
+	}
+	s.lockHolder = nil
+}
+
+// lockHeld records that the run in progress failed to take the run lock held by holder.
+// Holder is nil if the holder is unknown, for example because it released the lock in the meantime.
+func (s *statusTracker) lockHeld(holder *filelock.LockInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if holder == nil {
+		holder = &filelock.LockInfo{}
+	}
+	since, err := time.Parse(time.RFC3339, holder.Timestamp)
+	if err != nil {
+		since = s.now()
+	}
+	s.lockHolder = holder
+	s.lockHeldSince = since
+}
+
+// runFinished records the results of the run in progress, which ended with the exit code code.
+// Results are nil if the run did not get as far as exporting.
+func (s *statusTracker) runFinished(code int, results []sourceResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == nil {
+		return
+	}
+	summary := &runSummary{
+		RunID:    s.current.RunID,
+		Started:  s.current.Started,
+		Finished: s.now(),
+		Status:   runStatus(code),
+		Sources:  make([]sourceSummary, 0, len(results)),
+	}
+	for _, r := range results {
+		if r.notStarted {
+			continue
+		}
+		summary.Sources = append(summary.Sources, summarizeSource(r))
+	}
+	s.lastRun = summary
+	s.current = nil
+}
+
+// runStatus returns the status reported for a run that ended with the exit code code.
+func runStatus(code int) string {
+	switch code {
+	case 0:
+		return runStatusSuccess
+	case exitInterrupted:
+		return runStatusInterrupted
+	default:
+		return runStatusFailure
+	}
+}
+
+// summarizeSource converts the result of exporting a source for the status API.
+func summarizeSource(r sourceResult) sourceSummary {
+	summary := sourceSummary{
+		Source:          r.source,
+		Status:          runStatusSuccess,
+		Downloaded:      r.stats.Downloaded,
+		Skipped:         r.stats.Skipped,
+		Ignored:         r.stats.Ignored,
+		Removed:         r.stats.Removed,
+		DownloadErrors:  r.stats.DownloadErrs,
+		RemoveErrors:    r.stats.RemoveErrs,
+		BytesWritten:    r.stats.BytesWritten,
+		HistorySize:     r.stats.HistorySize,
+		DurationSeconds: r.duration.Seconds(),
+	}
+	switch {
+	case r.failed():
+		summary.Status = runStatusFailure
+	case r.err != nil:
+		summary.Status = runStatusInterrupted
+	}
+	if r.err != nil {
+		summary.Error = r.err.Error()
+	}
+	return summary
+}
+
+// trigger requests an immediate run. It returns false if a run is already in progress or requested.
+func (s *statusTracker) trigger() bool {
+	s.mu.Lock()
+	running := s.current != nil
+	s.mu.Unlock()
+	if running {
+		return false
+	}
+	select {
+	case s.triggers <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// snapshot returns the current state, including the progress of the run in progress.
+func (s *statusTracker) snapshot() serviceStatus {
+	s.mu.Lock()
+	status := serviceStatus{
+		Version: Version,
+		Started: s.started,
+		Login:   s.login,
+	}
+	if !s.nextRun.IsZero() {
+		next := s.nextRun
+		status.NextRun = &next
+	}
+	if s.current != nil {
+		current := *s.current
+		status.Current = &current
+	}
+	if s.lastRun != nil {
+		last := *s.lastRun
+		status.LastRun = &last
+	}
+	s.mu.Unlock()
+
+	if status.Current != nil {
+		s.addProgress(status.Current)
+	}
+	status.Problems = s.problems(status)
+	status.Ready = len(status.Problems) == 0
+	return status
+}
+
+// addProgress fills in the progress of the source being exported by the run in progress.
+func (s *statusTracker) addProgress(current *currentRun) {
+	p, ok := s.exporter.Progress()
+	if !ok || p.RunID != current.RunID {
+		return
+	}
+	current.Source = sourceForHistoryFile(p.HistoryFile)
+	started := p.Started
+	current.SourceStarted = &started
+	current.CurrentPath = p.CurrentPath
+	current.Downloaded = p.Stats.Downloaded
+	current.Skipped = p.Stats.Skipped
+	current.Ignored = p.Stats.Ignored
+	current.Errors = p.Stats.DownloadErrs
+	for i, source := range s.sources {
+		if source == current.Source {
+			current.SourcesDone = i
+		}
+	}
+}
+
+// sourceForHistoryFile returns the source whose history is kept in the named file, or "" if there is none.
+func sourceForHistoryFile(name string) sourceType {
+	for _, source := range defaultSources() {
+		if historyFileForSource(source) == name {
+			return source
+		}
+	}
+	return ""
+}
+
+// problems returns the reasons the service is not ready: the last login failed, or the run lock has been held
+// by another process for longer than lockStuckAfter. The run lock is not checked on the file system, which would
+// race with a run taking it; it is judged from what the runs of this process have seen of it.
+func (s *statusTracker) problems(status serviceStatus) []string {
+	var problems []string
+	if !status.Login.OK {
+		problems = append(problems, "last login failed")
+	}
+	if problem := s.lockProblem(status.Current); problem != "" {
+		problems = append(problems, problem)
+	}
+	return problems
+}
+
+// lockProblem describes the run lock if another process has held it for longer than lockStuckAfter:
+// the run in progress has waited for it that long, or the last run found it held by a process that took it
+// that long ago.
+func (s *statusTracker) lockProblem(current *currentRun) string {
+	now := s.now()
+	if current != nil {
+		if current.WaitingForLock && now.Sub(current.Started) >= s.lockStuckAfter {
+			return fmt.Sprintf("run %s waiting for the run lock since %s", current.RunID, current.Started.Format(time.RFC3339))
+		}
+		return ""
+	}
+	s.mu.Lock()
+	holder, since := s.lockHolder, s.lockHeldSince
+	s.mu.Unlock()
+	if holder == nil || now.Sub(since) < s.lockStuckAfter {
+		return ""
+	}
+	return fmt.Sprintf("run lock held by PID %d on %s since %s", holder.PID, holder.Hostname, since.Format(time.RFC3339))
+}
+
+// newStatusHandler returns the HTTP handler of the status API:
+//
+//	GET  /healthz           liveness; always succeeds while the process is serving
+//	GET  /readyz            readiness; fails if the last login failed or the run lock is stuck
+//	GET  /status            the whole state as JSON
+//	GET  /status/last-run   the summary of the last finished run, per source
+//	GET  /status/current    the run in progress, with its current path and progress
+//	POST /run               starts a run immediately
+//
+// If token is not empty, all endpoints except /healthz and /readyz require it as a bearer token.
+func newStatusHandler(status *statusTracker, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		snapshot := status.snapshot()
+		code := http.StatusOK
+		if !snapshot.Ready {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, map[string]any{"ready": snapshot.Ready, "problems": snapshot.Problems})
+	})
+
+	authorized := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if token != "" && !validBearerToken(r, token) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="synology-office-exporter"`)
+				writeError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+				return
+			}
+			next(w, r)
+		}
+	}
+	mux.HandleFunc("GET /status", authorized(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, status.snapshot())
+	}))
+	mux.HandleFunc("GET /status/last-run", authorized(func(w http.ResponseWriter, r *http.Request) {
+		snapshot := status.snapshot()
+		if snapshot.LastRun == nil {
+			writeError(w, http.StatusNotFound, errors.New("no run has finished yet"))
+			return
+		}
+		writeJSON(w, http.StatusOK, snapshot.LastRun)
+	}))
+	mux.HandleFunc("GET /status/current", authorized(func(w http.ResponseWriter, r *http.Request) {
+		snapshot := status.snapshot()
+		if snapshot.Current == nil {
+			writeError(w, http.StatusNotFound, errors.New("no run in progress"))
+			return
+		}
+		writeJSON(w, http.StatusOK, snapshot.Current)
+	}))
+	mux.HandleFunc("POST /run", authorized(func(w http.ResponseWriter, r *http.Request) {
+		if !status.trigger() {
+			writeError(w, http.StatusConflict, errors.New("a run is already in progress or requested"))
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "run requested"})
+	}))
+	return mux
+}
+
+// validBearerToken reports whether the request carries token in its Authorization header.
+func validBearerToken(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// writeJSON writes v as the JSON body of a response with the given status code.
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	// A write error means the client has gone away; there is nobody left to report it to.
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes err as a JSON error response with the given status code.
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/isseis/go-synology-office-exporter/filelock"
+	syndexp "github.com/isseis/go-synology-office-exporter/synology_drive_exporter"
+)
+
+// fakeProgress reports a fixed export in progress.
+type fakeProgress struct {
+	progress syndexp.Progress
+	ok       bool
+}
+
+func (f *fakeProgress) Progress() (syndexp.Progress, bool) { return f.progress, f.ok }
+
+// statusRequest sends a request to h and returns the status code and decoded JSON body of the response.
+func statusRequest(t *testing.T, h http.Handler, method, path, token string) (int, map[string]any) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var body map[string]any
+	if rec.Header().Get("Content-Type") == "application/json" {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body), rec.Body.String())
+	}
+	return rec.Code, body
+}
+
+func TestStatusHandler(t *testing.T) {
+	progress := &fakeProgress{}
+	status := newStatusTracker(progress, []sourceType{sourceMyDrive, sourceShared})
+	h := newStatusHandler(status, "")
+
+	code, body := statusRequest(t, h, http.MethodGet, "/healthz", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", body["status"])
+
+	// Nothing has logged in yet.
+	code, body = statusRequest(t, h, http.MethodGet, "/readyz", "")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, []any{"last login failed"}, body["problems"])
+	status.loggedIn(nil)
+	code, _ = statusRequest(t, h, http.MethodGet, "/readyz", "")
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _ = statusRequest(t, h, http.MethodGet, "/status/last-run", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = statusRequest(t, h, http.MethodGet, "/status/current", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// A run in progress reports the progress of the exporter.
+	status.scheduled(time.Date(2024, 5, 7, 2, 0, 0, 0, time.UTC))
+	status.runStarted("run1")
+	status.lockAcquired()
+	progress.ok = true
+	progress.progress = syndexp.Progress{
+		RunID:       "run1",
+		HistoryFile: historyFileForSource(sourceShared),
+		CurrentPath: "/shared/report.odoc",
+		Stats:       syndexp.ExportStats{Downloaded: 3, Skipped: 2},
+	}
+	code, body = statusRequest(t, h, http.MethodGet, "/status/current", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "run1", body["run_id"])
+	assert.Equal(t, "shared", body["source"])
+	assert.Equal(t, "/shared/report.odoc", body["current_path"])
+	assert.EqualValues(t, 3, body["downloaded"])
+	assert.EqualValues(t, 1, body["sources_done"])
+	assert.EqualValues(t, 2, body["sources_total"])
+
+	code, _ = statusRequest(t, h, http.MethodPost, "/run", "")
+	assert.Equal(t, http.StatusConflict, code, "run in progress")
+
+	status.runFinished(1, []sourceResult{
+		{source: sourceMyDrive, stats: syndexp.ExportStats{Downloaded: 2, BytesWritten: 100}, duration: time.Second},
+		{source: sourceShared, stats: syndexp.ExportStats{Downloaded: 3, DownloadErrs: 1}},
+		{source: sourceTeamFolder, notStarted: true},
+	})
+	code, body = statusRequest(t, h, http.MethodGet, "/status/last-run", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "run1", body["run_id"])
+	assert.Equal(t, runStatusFailure, body["status"])
+	sources := body["sources"].([]any)
+	require.Len(t, sources, 2, "sources that did not start are left out")
+	assert.Equal(t, "mydrive", sources[0].(map[string]any)["source"])
+	assert.Equal(t, runStatusSuccess, sources[0].(map[string]any)["status"])
+	assert.EqualValues(t, 100, sources[0].(map[string]any)["bytes_written"])
+	assert.Equal(t, runStatusFailure, sources[1].(map[string]any)["status"])
+
+	code, body = statusRequest(t, h, http.MethodGet, "/status", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, body["ready"])
+	assert.Equal(t, "2024-05-07T02:00:00Z", body["next_run"])
+	assert.Nil(t, body["current_run"])
+	assert.NotNil(t, body["last_run"])
+
+	// Only one triggered run is queued at a time.
+	code, _ = statusRequest(t, h, http.MethodPost, "/run", "")
+	assert.Equal(t, http.StatusAccepted, code)
+	code, _ = statusRequest(t, h, http.MethodPost, "/run", "")
+	assert.Equal(t, http.StatusConflict, code)
+	<-status.triggers
+
+	code, _ = statusRequest(t, h, http.MethodGet, "/run", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+}
+
+func TestStatusHandlerToken(t *testing.T) {
+	status := newStatusTracker(&fakeProgress{}, []sourceType{sourceMyDrive})
+	status.loggedIn(nil)
+	h := newStatusHandler(status, "secret")
+
+	// Probes do not need the token.
+	code, _ := statusRequest(t, h, http.MethodGet, "/healthz", "")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = statusRequest(t, h, http.MethodGet, "/readyz", "")
+	assert.Equal(t, http.StatusOK, code)
+
+	for _, token := range []string{"", "wrong", "secret2"} {
+		code, body := statusRequest(t, h, http.MethodGet, "/status", token)
+		assert.Equal(t, http.StatusUnauthorized, code, "token %q", token)
+		assert.Contains(t, body["error"], "bearer token")
+		code, _ = statusRequest(t, h, http.MethodPost, "/run", token)
+		assert.Equal(t, http.StatusUnauthorized, code, "token %q", token)
+	}
+	code, _ = statusRequest(t, h, http.MethodGet, "/status", "secret")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = statusRequest(t, h, http.MethodPost, "/run", "secret")
+	assert.Equal(t, http.StatusAccepted, code)
+}
+
+func TestStatusTrackerReadiness(t *testing.T) {
+	dir := t.TempDir()
+	status := newStatusTracker(&fakeProgress{}, []sourceType{sourceMyDrive})
+	status.loggedIn(errors.New("invalid password"))
+	snapshot := status.snapshot()
+	assert.False(t, snapshot.Ready)
+	assert.Equal(t, "invalid password", snapshot.Login.Error)
+	status.loggedIn(nil)
+
+	// Another run holds the run lock, and a run of this process fails to take it.
+	unlock, err := lockRun(context.Background(), dir, "other", []sourceType{sourceMyDrive}, 0, nopLogger{})
+	require.NoError(t, err)
+	status.runStarted("run1")
+	_, err = lockRun(context.Background(), dir, "run1", []sourceType{sourceMyDrive}, 0, nopLogger{})
+	require.ErrorIs(t, err, filelock.ErrLockHeld)
+	holder, err := filelock.ReadLockInfo(runLockPath(dir))
+	require.NoError(t, err)
+	status.lockHeld(holder)
+	status.runFinished(1, nil)
+	assert.True(t, status.snapshot().Ready, "a lock held briefly is not stuck")
+
+	status.now = func() time.Time { return time.Now().Add(defaultLockStuckAfter + time.Minute) }
+	snapshot = status.snapshot()
+	assert.False(t, snapshot.Ready)
+	require.Len(t, snapshot.Problems, 1)
+	assert.Contains(t, snapshot.Problems[0], "run lock held by PID")
+
+	// A run waiting for the lock that long is stuck as well.
+	status.now = time.Now
+	status.runStarted("run2")
+	status.now = func() time.Time { return time.Now().Add(defaultLockStuckAfter + time.Minute) }
+	snapshot = status.snapshot()
+	assert.False(t, snapshot.Ready)
+	require.Len(t, snapshot.Problems, 1)
+	assert.Contains(t, snapshot.Problems[0], "run run2 waiting for the run lock")
+
+	// The lock of a run of this process is not stuck, however long the run takes.
+	unlock()
+	status.lockAcquired()
+	assert.True(t, status.snapshot().Ready)
+	status.runFinished(0, nil)
+	assert.True(t, status.snapshot().Ready, "a run that took the lock clears the holder seen before")
+}
+
+// TestStatusProbeDoesNotBlockRuns verifies that readiness probes never make a run fail to take the run lock.
+func TestStatusProbeDoesNotBlockRuns(t *testing.T) {
+	dir := t.TempDir()
+	status := newStatusTracker(&fakeProgress{}, []sourceType{sourceMyDrive})
+	status.loggedIn(nil)
+	h := newStatusHandler(status, "")
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			}
+		}
+	}()
+	for i := range 200 {
+		unlock, err := lockRun(context.Background(), dir, fmt.Sprint(i), []sourceType{sourceMyDrive}, 0, nopLogger{})
+		require.NoError(t, err, "run %d failed to take the run lock during a probe", i)
+		unlock()
+	}
+	close(stop)
+	<-done
+}
+
+func TestDaemonServeTrigger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	trigger := make(chan struct{}, 1)
+	var next []time.Time
+	runs := 0
+	d := &daemon{
+		schedule: intervalSchedule{start: time.Now(), interval: time.Hour},
+		run: func(context.Context) int {
+			runs++
+			if runs == 2 {
+				cancel()
+			}
+			return 0
+		},
+		log:       nopLogger{},
+		now:       time.Now,
+		trigger:   trigger,
+		scheduled: func(t time.Time) { next = append(next, t) },
+	}
+	trigger <- struct{}{}
+	trigger2 := time.AfterFunc(20*time.Millisecond, func() { trigger <- struct{}{} })
+	defer trigger2.Stop()
+
+	assert.Equal(t, 0, d.serve(ctx))
+	assert.Equal(t, 2, runs, "each trigger starts a run before the hourly schedule")
+	// The next scheduled run is reported before each wait, including the one cut short by the cancellation.
+	require.Len(t, next, 3)
+	assert.True(t, next[0].After(time.Now().Add(50*time.Minute)))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/isseis/go-synology-office-exporter/logger"
+	synd "github.com/isseis/go-synology-office-exporter/synology_drive_api"
+)
+
+// tlsOptions holds the TLS flags for the connection to the NAS.
+type tlsOptions struct {
+	caFile     string
+	certFile   string
+	keyFile    string
+	pins       string
+	minVersion string
+	insecure   bool
+}
+
+// registerTLSFlags defines the TLS flags on fs.
+func registerTLSFlags(fs *flag.FlagSet) *tlsOptions {
+	o := &tlsOptions{}
+	fs.StringVar(&o.caFile, "tls-ca-file", "", "PEM file of CA certificates to trust for the NAS, in addition to the system roots")
+	fs.StringVar(&o.certFile, "tls-cert-file", "", "PEM file of a client certificate for mutual TLS (requires -tls-key-file)")
+	fs.StringVar(&o.keyFile, "tls-key-file", "", "PEM file of the private key of -tls-cert-file")
+	fs.StringVar(&o.pins, "tls-pin", "", "Comma-separated SHA-256 pins of the NAS certificate public key (sha256/<base64> or hex); the NAS must match one")
+	fs.StringVar(&o.minVersion, "tls-min-version", "1.2", "Minimum TLS version (1.2 or 1.3)")
+	fs.BoolVar(&o.insecure, "tls-insecure-skip-verify", false, "INSECURE: Do not verify the NAS certificate; only for lab NASes with self-signed certificates (prefer -tls-pin)")
+	return o
+}
+
+// sessionOptions returns the session options for the flags. Disabling verification is logged as a warning.
+func (o *tlsOptions) sessionOptions(log logger.Logger) ([]synd.SessionOption, error) {
+	var opts []synd.SessionOption
+	switch o.minVersion {
+	case "1.2":
+		opts = append(opts, synd.WithMinTLSVersion(tls.VersionTLS12))
+	case "1.3":
+		opts = append(opts, synd.WithMinTLSVersion(tls.VersionTLS13))
+	default:
+		return nil, fmt.Errorf("invalid -tls-min-version %q: must be 1.2 or 1.3", o.minVersion)
+	}
+	if o.caFile != "" {
+		opts = append(opts, synd.WithCAFile(o.caFile))
+	}
+	if o.certFile != "" || o.keyFile != "" {
+		opts = append(opts, synd.WithClientCertificate(o.certFile, o.keyFile))
+	}
+	var pins []string
+	for _, pin := range strings.Split(o.pins, ",") {
+		if pin = strings.TrimSpace(pin); pin != "" {
+			pins = append(pins, pin)
+		}
+	}
+	if len(pins) > 0 {
+		opts = append(opts, synd.WithCertificatePins(pins...))
+	}
+	if o.insecure {
+		opts = append(opts, synd.WithInsecureSkipVerify())
+		if len(pins) == 0 {
+			log.Warn("TLS certificate verification is DISABLED by -tls-insecure-skip-verify: the connection to the NAS, including the password, can be intercepted. Use -tls-ca-file or -tls-pin instead.")
+		} else {
+			log.Warn("TLS certificate chain verification is disabled by -tls-insecure-skip-verify; the NAS is only checked against -tls-pin")
+		}
+	}
+	return opts, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSOptionsSessionOptions(t *testing.T) {
+	parse := func(args ...string) *tlsOptions {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		o := registerTLSFlags(fs)
+		require.NoError(t, fs.Parse(args))
+		return o
+	}
+
+	log := &recordingLogger{}
+	opts, err := parse().sessionOptions(log)
+	require.NoError(t, err)
+	assert.Len(t, opts, 1, "only the minimum version by default")
+	assert.Empty(t, log.entries)
+
+	opts, err = parse("-tls-min-version", "1.3", "-tls-pin", "sha256/a, ,b", "-tls-ca-file", "ca.pem").sessionOptions(log)
+	require.NoError(t, err)
+	assert.Len(t, opts, 3)
+	assert.Empty(t, log.entries)
+
+	_, err = parse("-tls-min-version", "1.1").sessionOptions(log)
+	assert.Error(t, err)
+
+	_, err = parse("-tls-insecure-skip-verify").sessionOptions(log)
+	require.NoError(t, err)
+	_, err = parse("-tls-insecure-skip-verify", "-tls-pin", "sha256/a").sessionOptions(log)
+	require.NoError(t, err)
+	require.Len(t, log.entries, 2, "insecure mode is always logged")
+	assert.Contains(t, log.entries[0], "WARN: TLS certificate verification is DISABLED")
+	assert.Contains(t, log.entries[1], "WARN: TLS certificate chain verification is disabled")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	synd "github.com/isseis/go-synology-office-exporter/synology_drive_api"
+)
+
+// transportOptions holds the flags for the HTTP connection to the NAS.
+type transportOptions struct {
+	proxy                 string
+	requestTimeout        time.Duration
+	responseHeaderTimeout time.Duration
+	apiRate               float64
+	exportRate            float64
+	exportBandwidth       string
+}
+
+// registerTransportFlags defines the HTTP connection flags on fs.
+func registerTransportFlags(fs *flag.FlagSet) *transportOptions {
+	o := &transportOptions{}
+	fs.StringVar(&o.proxy, "proxy", "", "Proxy URL for the connection to the NAS (http, https or socks5); defaults to HTTPS_PROXY/HTTP_PROXY, honouring NO_PROXY")
+	fs.DurationVar(&o.requestTimeout, "request-timeout", 0, "Overall time limit of a request to the NAS, including downloading the exported file (0 for none)")
+	fs.DurationVar(&o.responseHeaderTimeout, "response-header-timeout", synd.DefaultResponseHeaderTimeout, "Time to wait for the NAS to start answering a request, including converting a document for export")
+	fs.Float64Var(&o.apiRate, "api-rate", 0, "Maximum API calls per second to the NAS, other than export downloads (0 for no limit)")
+	fs.Float64Var(&o.exportRate, "export-rate", 0, "Maximum export downloads per second from the NAS (0 for no limit)")
+	fs.StringVar(&o.exportBandwidth, "export-bandwidth", "", "Maximum transfer rate of export downloads in bytes per second, with an optional K, M or G suffix (e.g. 5M)")
+	return o
+}
+
+// sessionOptions returns the session options for the flags.
+func (o *transportOptions) sessionOptions() ([]synd.SessionOption, error) {
+	var opts []synd.SessionOption
+	if o.proxy != "" {
+		proxyURL, err := url.Parse(o.proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid -proxy %q: want a URL such as http://proxy:3128 or socks5://proxy:1080", o.proxy)
+		}
+		opts = append(opts, synd.WithProxy(proxyURL))
+	}
+	if o.requestTimeout < 0 {
+		return nil, fmt.Errorf("invalid -request-timeout %v: must not be negative", o.requestTimeout)
+	}
+	if o.responseHeaderTimeout <= 0 {
+		return nil, fmt.Errorf("invalid -response-header-timeout %v: must be positive", o.responseHeaderTimeout)
+	}
+	if o.requestTimeout > 0 {
+		opts = append(opts, synd.WithRequestTimeout(o.requestTimeout))
+	}
+	if o.responseHeaderTimeout != synd.DefaultResponseHeaderTimeout {
+		opts = append(opts, synd.WithResponseHeaderTimeout(o.responseHeaderTimeout))
+	}
+	if o.apiRate < 0 || o.exportRate < 0 {
+		return nil, fmt.Errorf("-api-rate and -export-rate must not be negative")
+	}
+	if o.apiRate > 0 {
+		opts = append(opts, synd.WithRateLimit(o.apiRate, rateBurst(o.apiRate)))
+	}
+	if o.exportRate > 0 {
+		opts = append(opts, synd.WithExportRateLimit(o.exportRate, rateBurst(o.exportRate)))
+	}
+	if o.exportBandwidth != "" {
+		bandwidth, err := parseByteSize(o.exportBandwidth)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -export-bandwidth: %w", err)
+		}
+		opts = append(opts, synd.WithExportBandwidth(bandwidth))
+	}
+	return opts, nil
+}
+
+// rateBurst returns the burst allowed for a rate limit: one second of calls, and at least one.
+func rateBurst(perSecond float64) int {
+	return max(1, int(perSecond))
+}
+
+// parseByteSize parses a number of bytes with an optional K, M or G suffix, in multiples of 1024.
+func parseByteSize(s string) (int64, error) {
+	multiplier := int64(1)
+	number := strings.TrimSuffix(strings.ToUpper(s), "B")
+	if n := len(number); n > 0 {
+		if shift := strings.IndexByte("KMG", number[n-1]); shift >= 0 {
+			multiplier = 1 << (10 * (shift + 1))
+			number = number[:n-1]
+		}
+	}
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("%q is not a positive number of bytes such as 512K or 5M", s)
+	}
+	return size * multiplier, nil
+}
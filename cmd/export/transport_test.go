@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportOptionsSessionOptions(t *testing.T) {
+	parse := func(args ...string) *transportOptions {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		o := registerTransportFlags(fs)
+		require.NoError(t, fs.Parse(args))
+		return o
+	}
+
+	opts, err := parse().sessionOptions()
+	require.NoError(t, err)
+	assert.Empty(t, opts)
+
+	opts, err = parse("-proxy", "socks5://proxy:1080", "-request-timeout", "1h", "-response-header-timeout", "5m").sessionOptions()
+	require.NoError(t, err)
+	assert.Len(t, opts, 3)
+
+	opts, err = parse("-api-rate", "2.5", "-export-rate", "0.5", "-export-bandwidth", "5M").sessionOptions()
+	require.NoError(t, err)
+	assert.Len(t, opts, 3)
+
+	for _, args := range [][]string{
+		{"-proxy", "proxy:3128"},
+		{"-api-rate", "-1"},
+		{"-export-bandwidth", "fast"},
+		{"-request-timeout", "-1s"},
+		{"-response-header-timeout", "0"},
+	} {
+		_, err := parse(args...).sessionOptions()
+		assert.Error(t, err, args)
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	for s, want := range map[string]int64{"100": 100, "512K": 512 << 10, "5m": 5 << 20, "1GB": 1 << 30, "2kb": 2 << 10} {
+		size, err := parseByteSize(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, want, size, s)
+	}
+	for _, s := range []string{"", "0", "-1K", "K", "1.5M", "5T"} {
+		_, err := parseByteSize(s)
+		assert.Error(t, err, s)
+	}
+}
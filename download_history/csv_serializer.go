@@ -0,0 +1,36 @@
+package download_history
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"time"
+)
+
+// csvHeader lists the columns written by WriteCSV.
+var csvHeader = []string{"location", "file_id", "hash", "download_time"}
+
+// WriteCSV writes the entries to w as CSV with a header row.
+// Download times are formatted as RFC3339, the same as in the JSON history file.
+func WriteCSV(w io.Writer, entries []Entry) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return fmt.Errorf("csv write error: %w", err)
+	}
+	for _, e := range entries {
+		record := []string{
+			e.Location,
+			string(e.FileID),
+			string(e.Hash),
+			e.DownloadTime.Format(time.RFC3339),
+		}
+		if err := cw.Write(record); err != nil {
+			return fmt.Errorf("csv write error: %w", err)
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("csv write error: %w", err)
+	}
+	return nil
+}
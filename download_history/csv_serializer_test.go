@@ -0,0 +1,35 @@
+package download_history
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteCSV(t *testing.T) {
+	entries := []Entry{
+		{
+			Location: "mydrive/a,b.docx",
+			DownloadItem: DownloadItem{
+				FileID:       "882614125167948399",
+				Hash:         "1234567890abcdef",
+				DownloadTime: time.Date(2023, 10, 1, 12, 34, 56, 0, time.UTC),
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteCSV(&buf, entries))
+	want := "location,file_id,hash,download_time\n" +
+		"\"mydrive/a,b.docx\",882614125167948399,1234567890abcdef,2023-10-01T12:34:56Z\n"
+	assert.Equal(t, want, buf.String())
+
+	t.Run("Write error", func(t *testing.T) {
+		err := WriteCSV(&mockErrorWriter{}, entries)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "csv write error")
+	})
+}
@@ -7,6 +7,9 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
+
+	synd "github.com/isseis/go-synology-office-exporter/synology_drive_api"
 )
 
 // state represents the lifecycle state of the DownloadHistory.
@@ -32,12 +35,15 @@ type DownloadHistory struct {
 	path         string
 	state        state
 	loadCallback func()
+	journal      *Journal        // Optional journal receiving a record for every state change
+	interrupted  *InterruptedRun // Unfinished run that checkpointed the loaded file, if any
 
 	// Counters are already thread-safe using atomic operations
 	DownloadCount counter
 	SkippedCount  counter
 	IgnoredCount  counter
 	ErrorCount    counter
+	MismatchCount counter
 }
 
 var (
@@ -55,8 +61,28 @@ var (
 
 	// ErrHistoryInvalidStatus is returned when the item's status does not match the expected state.
 	ErrHistoryInvalidStatus = fmt.Errorf("download history item status is invalid")
+
+	// ErrJournalAppend is returned when a state change was applied but could not be written to the journal.
+	ErrJournalAppend = errors.New("failed to append to journal")
 )
 
+// InterruptedRun identifies an export run that checkpointed the history but did not finish.
+type InterruptedRun struct {
+	RunID   string
+	Started time.Time // Start of the run; files downloaded since then were exported by the unfinished run
+}
+
+// Option configures a DownloadHistory.
+type Option func(*DownloadHistory)
+
+// WithJournal makes the history append a record to j for every state change.
+// The caller remains responsible for closing the journal.
+func WithJournal(j *Journal) Option {
+	return func(d *DownloadHistory) {
+		d.journal = j
+	}
+}
+
 // NewDownloadHistory creates a new DownloadHistory instance with the specified path
 // for later use with Save and Load methods.
 //
@@ -64,7 +90,7 @@ var (
 // The returned DownloadHistory is in the 'new' state and must be initialized with Load()
 // before any other operations can be performed.
 // Returns an error if the filename is invalid.
-func NewDownloadHistory(path string) (*DownloadHistory, error) {
+func NewDownloadHistory(path string, opts ...Option) (*DownloadHistory, error) {
 	// Basic validity check
 	if path == "" {
 		return nil, fmt.Errorf("filename cannot be empty")
@@ -80,6 +106,9 @@ func NewDownloadHistory(path string) (*DownloadHistory, error) {
 		path:  path,
 		state: stateNew,
 	}
+	for _, opt := range opts {
+		opt(history)
+	}
 	return history, nil
 }
 
@@ -115,13 +144,30 @@ func (d *DownloadHistory) Load() error {
 	defer file.Close()
 
 	// Load items in a separate function to ensure file is closed
-	items, err := loadItemsFromReader(file)
+	items, interrupted, err := loadItemsFromReader(file)
 	if err != nil {
 		d.state = stateNew // Reset state on error
 		return err
 	}
 
 	d.items = items
+	d.interrupted = interrupted
+	d.state = stateReady
+	return nil
+}
+
+// LoadEmpty initializes the history with no items, without reading the file specified during initialization.
+// A subsequent Save replaces the file, so this is used to rebuild a lost or corrupt history.
+// It returns ErrAlreadyLoaded if Load() or LoadEmpty() has already been called.
+// This method is safe for concurrent use.
+func (d *DownloadHistory) LoadEmpty() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.state != stateNew {
+		return ErrAlreadyLoaded
+	}
+	d.items = make(map[string]DownloadItem)
 	d.state = stateReady
 	return nil
 }
@@ -143,31 +189,109 @@ func (d *DownloadHistory) Save() error {
 		return ErrAlreadyClosed
 	}
 
+	if err := d.writeFile(nil); err != nil {
+		return err
+	}
+	d.state = stateSaved
+	return nil
+}
+
+// SaveEdited writes the download history to the JSON file specified during initialization after it has been
+// edited outside an export run, for example by removing entries. Unlike Save, which records a completed run,
+// it keeps the mark of an interrupted run found by Load, so that the next run still resumes it.
+// It returns an error if the file cannot be created or written to, or if the history is not in the ready state.
+// This method is safe for concurrent use.
+func (d *DownloadHistory) SaveEdited() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch d.state {
+	case stateNew:
+		return ErrNotReady
+	case stateLoading:
+		return ErrNotReady
+	case stateSaved:
+		return ErrAlreadyClosed
+	}
+
+	if err := d.writeFile(d.interrupted); err != nil {
+		return err
+	}
+	d.state = stateSaved
+	return nil
+}
+
+// Checkpoint writes the current state of the history to the file specified during initialization
+// without finishing it, so that the progress of a long run survives an interruption.
+// The file is marked as written by the unfinished run; Interrupted reports it after the next Load,
+// until a Save by a completed run clears the mark.
+// It returns an error if the file cannot be written, or if the history is not in the ready state.
+// This method is safe for concurrent use.
+func (d *DownloadHistory) Checkpoint(run InterruptedRun) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.state != stateReady {
+		return ErrNotReady
+	}
+	return d.writeFile(&run)
+}
+
+// writeFile atomically replaces the history file with the current items.
+// The items are written to a temporary file in the same directory which is then renamed over the
+// history file, so an interruption never leaves a truncated history behind.
+// The caller must hold d.mu.
+func (d *DownloadHistory) writeFile(interrupted *InterruptedRun) error {
 	dir := filepath.Dir(d.path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return fmt.Errorf("file write error: %w", err)
 	}
 
-	file, err := os.Create(d.path)
+	file, err := os.CreateTemp(dir, filepath.Base(d.path)+".tmp-*")
 	if err != nil {
 		return fmt.Errorf("file write error: %w", err)
 	}
-	defer file.Close()
+	tmpPath := file.Name()
+	defer os.Remove(tmpPath) // No-op once the file has been renamed
 
-	// Copy the items we want to save while holding the read lock
+	// Copy the items we want to save while holding the lock
 	items := make(map[string]DownloadItem, len(d.items))
 	maps.Copy(items, d.items)
 
-	if err := saveToWriter(file, items); err != nil {
-		// Try to clean up the file if there was an error
+	if err := saveToWriter(file, items, interrupted); err != nil {
 		file.Close()
-		os.Remove(d.path)
 		return err
 	}
-	d.state = stateSaved
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		return fmt.Errorf("file write error: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("file write error: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("file write error: %w", err)
+	}
+	if err := os.Rename(tmpPath, d.path); err != nil {
+		return fmt.Errorf("file write error: %w", err)
+	}
 	return nil
 }
 
+// Interrupted returns the unfinished run that last checkpointed the loaded history file.
+// The second return value is false if the file was saved by a completed run or did not exist.
+// This method is safe for concurrent use.
+func (d *DownloadHistory) Interrupted() (InterruptedRun, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.interrupted == nil {
+		return InterruptedRun{}, false
+	}
+	return *d.interrupted, true
+}
+
 // MarkSkipped sets the status of an existing item to 'skipped'.
 // Returns an error if the item does not exist, if the item's status is not 'loaded',
 // or if the history is not in the ready state.
@@ -189,7 +313,7 @@ func (d *DownloadHistory) MarkSkipped(location string) error {
 	}
 	item.DownloadStatus = StatusSkipped
 	d.items[location] = item
-	return nil
+	return d.appendJournal(EventSkipped, location, item, nil)
 }
 
 // SetDownloaded adds a new item with status 'downloaded' if it does not exist, or updates an existing item
@@ -211,10 +335,49 @@ func (d *DownloadHistory) SetDownloaded(location string, item DownloadItem) erro
 		}
 		item.DownloadStatus = StatusDownloaded
 		d.items[location] = item
-		return nil
+		return d.appendJournal(EventDownloaded, location, item, nil)
 	}
 	item.DownloadStatus = StatusDownloaded
 	d.items[location] = item
+	return d.appendJournal(EventDownloaded, location, item, nil)
+}
+
+// RecordRemoved journals that the file at location was removed from the export directory.
+// The history itself is not changed. It is a no-op if the history has no journal.
+// This method is safe for concurrent use.
+func (d *DownloadHistory) RecordRemoved(location string) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.appendJournal(EventRemoved, location, d.items[location], nil)
+}
+
+// RecordFailure journals that exporting the file at location failed with cause.
+// The history itself is not changed. It is a no-op if the history has no journal.
+// This method is safe for concurrent use.
+func (d *DownloadHistory) RecordFailure(location string, fileID synd.FileID, cause error) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.appendJournal(EventFailed, location, DownloadItem{FileID: fileID}, cause)
+}
+
+// appendJournal appends a record for a state change to the journal, if any.
+// The caller must hold d.mu.
+func (d *DownloadHistory) appendJournal(event JournalEvent, location string, item DownloadItem, cause error) error {
+	if d.journal == nil {
+		return nil
+	}
+	rec := JournalRecord{
+		Event:    event,
+		Location: location,
+		FileID:   item.FileID,
+		Hash:     item.Hash,
+	}
+	if cause != nil {
+		rec.Error = cause.Error()
+	}
+	if err := d.journal.Append(rec); err != nil {
+		return fmt.Errorf("%w: %w", ErrJournalAppend, err)
+	}
 	return nil
 }
 
@@ -254,6 +417,14 @@ func (d *DownloadHistory) GetObsoleteItems() ([]string, error) {
 	return obsolete, nil
 }
 
+// Len returns the number of entries in the history.
+// This method is safe for concurrent use.
+func (d *DownloadHistory) Len() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.items)
+}
+
 // GetStats returns the current export statistics.
 func (d *DownloadHistory) GetStats() ExportStats {
 	d.mu.RLock()
@@ -264,5 +435,6 @@ func (d *DownloadHistory) GetStats() ExportStats {
 		Skipped:    d.SkippedCount.Get(),
 		Ignored:    d.IgnoredCount.Get(),
 		Errors:     d.ErrorCount.Get(),
+		Mismatched: d.MismatchCount.Get(),
 	}
 }
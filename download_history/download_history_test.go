@@ -402,3 +402,90 @@ func TestGetObsoleteItems(t *testing.T) {
 		assert.Empty(t, items)
 	})
 }
+
+func TestLoadEmpty(t *testing.T) {
+	tempDir := t.TempDir()
+	jsonPath := filepath.Join(tempDir, "history.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte("corrupt"), 0644))
+
+	history, err := NewDownloadHistory(jsonPath)
+	require.NoError(t, err)
+	require.NoError(t, history.LoadEmpty())
+	assert.Empty(t, history.items)
+	assert.ErrorIs(t, history.LoadEmpty(), ErrAlreadyLoaded)
+	assert.ErrorIs(t, history.Load(), ErrAlreadyLoaded)
+
+	require.NoError(t, history.SetDownloaded("file1", DownloadItem{FileID: "id1", DownloadTime: time.Now()}))
+	require.NoError(t, history.Save())
+
+	reloaded, err := NewDownloadHistory(jsonPath)
+	require.NoError(t, err)
+	require.NoError(t, reloaded.Load())
+	assert.Len(t, reloaded.items, 1)
+}
+
+func TestCheckpoint(t *testing.T) {
+	tempDir := t.TempDir()
+	jsonPath := filepath.Join(tempDir, "history.json")
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	history, err := NewDownloadHistory(jsonPath)
+	require.NoError(t, err)
+	assert.ErrorIs(t, history.Checkpoint(InterruptedRun{RunID: "run1", Started: started}), ErrNotReady)
+	require.NoError(t, history.Load())
+	require.NoError(t, history.SetDownloaded("file1", DownloadItem{FileID: "id1", Hash: "h1", DownloadTime: started}))
+	require.NoError(t, history.Checkpoint(InterruptedRun{RunID: "run1", Started: started}))
+
+	// A checkpoint keeps the history open for further changes.
+	require.NoError(t, history.SetDownloaded("file2", DownloadItem{FileID: "id2", Hash: "h2", DownloadTime: started}))
+
+	entries, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "temporary files must not be left behind")
+
+	resumed, err := NewDownloadHistory(jsonPath)
+	require.NoError(t, err)
+	require.NoError(t, resumed.Load())
+	assert.Len(t, resumed.items, 1)
+	run, ok := resumed.Interrupted()
+	require.True(t, ok)
+	assert.Equal(t, InterruptedRun{RunID: "run1", Started: started}, run)
+
+	// Saving the completed run clears the mark.
+	require.NoError(t, history.Save())
+	assert.ErrorIs(t, history.Checkpoint(InterruptedRun{RunID: "run1", Started: started}), ErrNotReady)
+	completed, err := NewDownloadHistory(jsonPath)
+	require.NoError(t, err)
+	require.NoError(t, completed.Load())
+	assert.Len(t, completed.items, 2)
+	_, ok = completed.Interrupted()
+	assert.False(t, ok)
+}
+
+func TestSaveEditedKeepsInterruptedRun(t *testing.T) {
+	jsonPath := filepath.Join(t.TempDir(), "history.json")
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	history, err := NewDownloadHistory(jsonPath)
+	require.NoError(t, err)
+	require.NoError(t, history.Load())
+	require.NoError(t, history.SetDownloaded("file1", DownloadItem{FileID: "id1", Hash: "h1", DownloadTime: started}))
+	require.NoError(t, history.SetDownloaded("file2", DownloadItem{FileID: "id2", Hash: "h2", DownloadTime: started}))
+	require.NoError(t, history.Checkpoint(InterruptedRun{RunID: "run1", Started: started}))
+
+	edited, err := NewDownloadHistory(jsonPath)
+	require.NoError(t, err)
+	assert.ErrorIs(t, edited.SaveEdited(), ErrNotReady)
+	require.NoError(t, edited.Load())
+	require.NoError(t, edited.RemoveItem("file1"))
+	require.NoError(t, edited.SaveEdited())
+	assert.ErrorIs(t, edited.SaveEdited(), ErrAlreadyClosed)
+
+	reloaded, err := NewDownloadHistory(jsonPath)
+	require.NoError(t, err)
+	require.NoError(t, reloaded.Load())
+	assert.Len(t, reloaded.items, 1)
+	run, ok := reloaded.Interrupted()
+	require.True(t, ok, "editing the history must not drop the mark of the interrupted run")
+	assert.Equal(t, InterruptedRun{RunID: "run1", Started: started}, run)
+}
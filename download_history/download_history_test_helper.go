@@ -96,6 +96,7 @@ func NewDownloadHistoryForTest(t *testing.T, items map[string]DownloadItem, opts
 		SkippedCount:  counter{},
 		IgnoredCount:  counter{},
 		ErrorCount:    counter{},
+		MismatchCount: counter{},
 	}
 
 	result := &TestDownloadHistory{
@@ -0,0 +1,410 @@
+package download_history
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	synd "github.com/isseis/go-synology-office-exporter/synology_drive_api"
+)
+
+// JournalEvent is the kind of state change recorded in the journal.
+type JournalEvent string
+
+const (
+	EventDownloaded JournalEvent = "downloaded"
+	EventSkipped    JournalEvent = "skipped"
+	EventRemoved    JournalEvent = "removed"
+	EventFailed     JournalEvent = "failed"
+)
+
+// journalRotatedTimeFormat is the suffix format of rotated journal files.
+const journalRotatedTimeFormat = "20060102T150405.000000000Z"
+
+// ErrRunNotFound is returned when a run ID does not appear in the journal.
+var ErrRunNotFound = errors.New("run not found in journal")
+
+// JournalRecord is a single entry of the journal. Each line of a journal file holds one record as JSON.
+type JournalRecord struct {
+	RunID    string        `json:"run_id"`
+	Time     time.Time     `json:"time"`
+	Event    JournalEvent  `json:"event"`
+	Location string        `json:"location"`
+	FileID   synd.FileID   `json:"file_id,omitempty"`
+	Hash     synd.FileHash `json:"hash,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Journal appends records of history state changes to a JSON Lines file.
+// The file is rotated when it exceeds a maximum size or age; rotated files are kept next to it
+// with a timestamp suffix so that the full journal can be replayed. If the number of rotated files is
+// limited with WithJournalMaxBackups, the oldest are removed, and FilesAtRun cannot go back further than
+// the oldest file kept.
+// All methods are safe for concurrent use.
+type Journal struct {
+	mu         sync.Mutex
+	path       string
+	runID      string
+	maxSize    int64         // Rotate when the file would exceed this size; 0 disables size-based rotation
+	maxAge     time.Duration // Rotate when the first record is older than this; 0 disables age-based rotation
+	maxBackups int           // Number of rotated files to keep; 0 keeps all
+	file       *os.File
+	size       int64
+	started    time.Time // Time of the first record in the current file
+	now        func() time.Time
+}
+
+// JournalOption configures a Journal.
+type JournalOption func(*Journal)
+
+// WithJournalMaxSize rotates the journal before it grows beyond maxSize bytes.
+func WithJournalMaxSize(maxSize int64) JournalOption {
+	return func(j *Journal) {
+		j.maxSize = maxSize
+	}
+}
+
+// WithJournalMaxAge rotates the journal once its first record is older than maxAge.
+func WithJournalMaxAge(maxAge time.Duration) JournalOption {
+	return func(j *Journal) {
+		j.maxAge = maxAge
+	}
+}
+
+// WithJournalMaxBackups keeps only the newest maxBackups rotated files, removing older ones after each
+// rotation. Zero keeps all rotated files, so that the full journal can be replayed.
+func WithJournalMaxBackups(maxBackups int) JournalOption {
+	return func(j *Journal) {
+		j.maxBackups = maxBackups
+	}
+}
+
+// NewRunID returns a new identifier for an export run. IDs sort in the order the runs started.
+func NewRunID() string {
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b[:])
+}
+
+// OpenJournal opens the journal at path for appending records of the given run, creating it if needed.
+// The caller must call Close when the run is finished.
+func OpenJournal(path string, runID string, opts ...JournalOption) (*Journal, error) {
+	if runID == "" {
+		return nil, fmt.Errorf("run ID cannot be empty")
+	}
+	j := &Journal{
+		path:  path,
+		runID: runID,
+		now:   time.Now,
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	if err := j.open(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// open opens the current journal file and restores its size and start time.
+func (j *Journal) open() error {
+	if err := os.MkdirAll(filepath.Dir(j.path), 0755); err != nil {
+		return fmt.Errorf("journal open error: %w", err)
+	}
+	f, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("journal open error: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("journal open error: %w", err)
+	}
+	j.file = f
+	j.size = info.Size()
+	j.started = time.Time{}
+	if j.size > 0 {
+		j.started = firstRecordTime(j.path, info.ModTime())
+	}
+	return nil
+}
+
+// firstRecordTime returns the time of the first record in the journal file, or fallback if it cannot be read.
+func firstRecordTime(path string, fallback time.Time) time.Time {
+	f, err := os.Open(path)
+	if err != nil {
+		return fallback
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return fallback
+	}
+	var rec JournalRecord
+	if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil || rec.Time.IsZero() {
+		return fallback
+	}
+	return rec.Time
+}
+
+// RunID returns the run ID stamped on every record appended through this journal.
+func (j *Journal) RunID() string {
+	return j.runID
+}
+
+// Append stamps the record with the run ID and the current time and appends it to the journal,
+// rotating the file first if it has reached its size or age limit.
+func (j *Journal) Append(rec JournalRecord) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return fmt.Errorf("journal is closed")
+	}
+	rec.RunID = j.runID
+	rec.Time = j.now().UTC()
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("journal write error: %w", err)
+	}
+	line = append(line, '\n')
+
+	if j.needsRotation(rec.Time, int64(len(line))) {
+		if err := j.rotate(rec.Time); err != nil {
+			return err
+		}
+	}
+
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("journal write error: %w", err)
+	}
+	if j.started.IsZero() {
+		j.started = rec.Time
+	}
+	return nil
+}
+
+// needsRotation reports whether the current file must be rotated before writing a record of the given size.
+func (j *Journal) needsRotation(now time.Time, recordSize int64) bool {
+	if j.size == 0 {
+		return false
+	}
+	if j.maxSize > 0 && j.size+recordSize > j.maxSize {
+		return true
+	}
+	return j.maxAge > 0 && !j.started.IsZero() && now.Sub(j.started) >= j.maxAge
+}
+
+// rotate renames the current file with a timestamp suffix, starts a new one and removes excess rotated files.
+func (j *Journal) rotate(now time.Time) error {
+	if err := j.file.Close(); err != nil {
+		return fmt.Errorf("journal rotate error: %w", err)
+	}
+	j.file = nil
+	rotated := j.path + "." + now.Format(journalRotatedTimeFormat)
+	if err := os.Rename(j.path, rotated); err != nil {
+		return fmt.Errorf("journal rotate error: %w", err)
+	}
+	if err := j.open(); err != nil {
+		return err
+	}
+	return j.removeOldBackups()
+}
+
+// removeOldBackups removes rotated files beyond the newest maxBackups.
+func (j *Journal) removeOldBackups() error {
+	if j.maxBackups <= 0 {
+		return nil
+	}
+	files, err := journalFiles(j.path)
+	if err != nil {
+		return err
+	}
+	// journalFiles lists the current file last.
+	rotated := files[:len(files)-1]
+	for len(rotated) > j.maxBackups {
+		if err := os.Remove(rotated[0]); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("journal rotate error: %w", err)
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
+
+// Close flushes the journal to disk and closes it.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+	syncErr := j.file.Sync()
+	closeErr := j.file.Close()
+	j.file = nil
+	if err := errors.Join(syncErr, closeErr); err != nil {
+		return fmt.Errorf("journal close error: %w", err)
+	}
+	return nil
+}
+
+// journalFiles returns the rotated journal files for path in chronological order, followed by path itself if it exists.
+func journalFiles(path string) ([]string, error) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("journal read error: %w", err)
+	}
+	var files []string
+	current := false
+	for _, e := range entries {
+		name := e.Name()
+		if name == base {
+			current = true
+			continue
+		}
+		suffix, ok := strings.CutPrefix(name, base+".")
+		if !ok {
+			continue
+		}
+		if _, err := time.Parse(journalRotatedTimeFormat, suffix); err != nil {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	sort.Strings(files)
+	if current {
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+// ReadJournal reads every record of the journal at path, including rotated files, in the order they were written.
+// A missing journal yields no records.
+func ReadJournal(path string) ([]JournalRecord, error) {
+	files, err := journalFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	var records []JournalRecord
+	for _, name := range files {
+		recs, err := readJournalFile(name)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, recs...)
+	}
+	return records, nil
+}
+
+// readJournalFile reads the records of a single journal file.
+func readJournalFile(name string) ([]JournalRecord, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("journal read error: %w", err)
+	}
+	defer f.Close()
+
+	var records []JournalRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var rec JournalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("journal decode error at %s:%d: %w", name, lineNo, err)
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("journal read error: %w", err)
+	}
+	return records, nil
+}
+
+// RunSummary describes a single run recorded in the journal.
+type RunSummary struct {
+	RunID  string
+	Start  time.Time // Time of the first record of the run
+	End    time.Time // Time of the last record of the run
+	Counts map[JournalEvent]int
+}
+
+// ListRuns summarizes the runs found in records, in the order they first appear.
+func ListRuns(records []JournalRecord) []RunSummary {
+	var runs []RunSummary
+	index := make(map[string]int)
+	for _, rec := range records {
+		i, ok := index[rec.RunID]
+		if !ok {
+			i = len(runs)
+			index[rec.RunID] = i
+			runs = append(runs, RunSummary{RunID: rec.RunID, Start: rec.Time, Counts: make(map[JournalEvent]int)})
+		}
+		runs[i].End = rec.Time
+		runs[i].Counts[rec.Event]++
+	}
+	return runs
+}
+
+// FilesAtRun replays records up to the end of the given run and returns the files that were present
+// in the export directory at that point, sorted by location.
+// Downloaded and skipped files are present until a later removed record; failures do not change presence.
+// Returns ErrRunNotFound if the run does not appear in records.
+func FilesAtRun(records []JournalRecord, runID string) ([]Entry, error) {
+	last := -1
+	for i, rec := range records {
+		if rec.RunID == runID {
+			last = i
+		}
+	}
+	if last < 0 {
+		return nil, ErrRunNotFound
+	}
+
+	present := make(map[string]DownloadItem)
+	for _, rec := range records[:last+1] {
+		switch rec.Event {
+		case EventDownloaded:
+			present[rec.Location] = DownloadItem{FileID: rec.FileID, Hash: rec.Hash, DownloadTime: rec.Time, DownloadStatus: StatusDownloaded}
+		case EventSkipped:
+			item, ok := present[rec.Location]
+			if !ok {
+				// The file predates the journal; its original download time is unknown.
+				item = DownloadItem{FileID: rec.FileID, Hash: rec.Hash, DownloadTime: rec.Time}
+			}
+			item.DownloadStatus = StatusSkipped
+			present[rec.Location] = item
+		case EventRemoved:
+			delete(present, rec.Location)
+		}
+	}
+
+	entries := make([]Entry, 0, len(present))
+	for location, item := range present {
+		entries = append(entries, Entry{Location: location, DownloadItem: item})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Location < entries[j].Location
+	})
+	return entries, nil
+}
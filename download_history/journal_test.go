@@ -0,0 +1,229 @@
+package download_history
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock returns a controllable time source for journal tests.
+func fakeClock(start time.Time) (func() time.Time, func(time.Duration)) {
+	now := start
+	return func() time.Time { return now }, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestJournalAppendAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json.journal")
+	j, err := OpenJournal(path, "run1")
+	require.NoError(t, err)
+	require.NoError(t, j.Append(JournalRecord{Event: EventDownloaded, Location: "a.docx", FileID: "id1", Hash: "h1"}))
+	require.NoError(t, j.Append(JournalRecord{Event: EventFailed, Location: "b.docx", Error: "boom"}))
+	require.NoError(t, j.Close())
+
+	// Reopening appends to the same file.
+	j, err = OpenJournal(path, "run2")
+	require.NoError(t, err)
+	require.NoError(t, j.Append(JournalRecord{Event: EventSkipped, Location: "a.docx"}))
+	require.NoError(t, j.Close())
+	assert.Error(t, j.Append(JournalRecord{}), "append after close must fail")
+
+	records, err := ReadJournal(path)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, "run1", records[0].RunID)
+	assert.Equal(t, EventDownloaded, records[0].Event)
+	assert.False(t, records[0].Time.IsZero())
+	assert.Equal(t, "boom", records[1].Error)
+	assert.Equal(t, "run2", records[2].RunID)
+
+	t.Run("missing journal", func(t *testing.T) {
+		records, err := ReadJournal(filepath.Join(t.TempDir(), "none.journal"))
+		require.NoError(t, err)
+		assert.Empty(t, records)
+	})
+
+	t.Run("empty run ID", func(t *testing.T) {
+		_, err := OpenJournal(path, "")
+		assert.Error(t, err)
+	})
+}
+
+func TestJournalRotation(t *testing.T) {
+	t.Run("by size", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "history.json.journal")
+		j, err := OpenJournal(path, "run1", WithJournalMaxSize(200))
+		require.NoError(t, err)
+		now, advance := fakeClock(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+		j.now = now
+		for i := 0; i < 6; i++ {
+			advance(time.Second)
+			require.NoError(t, j.Append(JournalRecord{Event: EventDownloaded, Location: "file" + string(rune('a'+i)) + ".docx"}))
+		}
+		require.NoError(t, j.Close())
+
+		files, err := journalFiles(path)
+		require.NoError(t, err)
+		assert.Greater(t, len(files), 1, "journal should have been rotated")
+		for _, f := range files {
+			info, err := os.Stat(f)
+			require.NoError(t, err)
+			assert.LessOrEqual(t, info.Size(), int64(200))
+		}
+
+		records, err := ReadJournal(path)
+		require.NoError(t, err)
+		require.Len(t, records, 6)
+		for i, rec := range records {
+			assert.Equal(t, "file"+string(rune('a'+i))+".docx", rec.Location, "records must be read in write order")
+		}
+	})
+
+	t.Run("by age", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "history.json.journal")
+		j, err := OpenJournal(path, "run1", WithJournalMaxAge(24*time.Hour))
+		require.NoError(t, err)
+		now, advance := fakeClock(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+		j.now = now
+		require.NoError(t, j.Append(JournalRecord{Event: EventDownloaded, Location: "a.docx"}))
+		advance(time.Hour)
+		require.NoError(t, j.Append(JournalRecord{Event: EventDownloaded, Location: "b.docx"}))
+		require.NoError(t, j.Close())
+
+		// The start time of the current file survives reopening.
+		j, err = OpenJournal(path, "run2", WithJournalMaxAge(24*time.Hour))
+		require.NoError(t, err)
+		j.now = now
+		advance(24 * time.Hour)
+		require.NoError(t, j.Append(JournalRecord{Event: EventSkipped, Location: "a.docx"}))
+		require.NoError(t, j.Close())
+
+		files, err := journalFiles(path)
+		require.NoError(t, err)
+		require.Len(t, files, 2)
+		rotated, err := readJournalFile(files[0])
+		require.NoError(t, err)
+		assert.Len(t, rotated, 2)
+		current, err := readJournalFile(files[1])
+		require.NoError(t, err)
+		require.Len(t, current, 1)
+		assert.Equal(t, "run2", current[0].RunID)
+	})
+
+	t.Run("max backups", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "history.json.journal")
+		j, err := OpenJournal(path, "run1", WithJournalMaxAge(time.Hour), WithJournalMaxBackups(2))
+		require.NoError(t, err)
+		now, advance := fakeClock(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+		j.now = now
+		for i := 0; i < 5; i++ {
+			require.NoError(t, j.Append(JournalRecord{Event: EventDownloaded, Location: "file" + string(rune('a'+i)) + ".docx"}))
+			advance(time.Hour)
+		}
+		require.NoError(t, j.Close())
+
+		files, err := journalFiles(path)
+		require.NoError(t, err)
+		assert.Len(t, files, 3, "two rotated files and the current one")
+		records, err := ReadJournal(path)
+		require.NoError(t, err)
+		var locations []string
+		for _, rec := range records {
+			locations = append(locations, rec.Location)
+		}
+		assert.Equal(t, []string{"filec.docx", "filed.docx", "filee.docx"}, locations, "the oldest rotated files are removed")
+	})
+}
+
+func TestFilesAtRunAndListRuns(t *testing.T) {
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	records := []JournalRecord{
+		{RunID: "r1", Time: base, Event: EventDownloaded, Location: "a.docx", FileID: "id1", Hash: "h1"},
+		{RunID: "r1", Time: base.Add(time.Second), Event: EventDownloaded, Location: "b.docx", FileID: "id2", Hash: "h2"},
+		{RunID: "r2", Time: base.Add(time.Hour), Event: EventSkipped, Location: "a.docx", FileID: "id1", Hash: "h1"},
+		{RunID: "r2", Time: base.Add(time.Hour + time.Second), Event: EventDownloaded, Location: "c.docx", FileID: "id3", Hash: "h3"},
+		{RunID: "r2", Time: base.Add(time.Hour + 2*time.Second), Event: EventFailed, Location: "d.docx", FileID: "id4", Error: "boom"},
+		{RunID: "r2", Time: base.Add(time.Hour + 3*time.Second), Event: EventRemoved, Location: "b.docx", FileID: "id2"},
+	}
+
+	locations := func(entries []Entry) []string {
+		var ret []string
+		for _, e := range entries {
+			ret = append(ret, e.Location)
+		}
+		return ret
+	}
+
+	entries, err := FilesAtRun(records, "r1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.docx", "b.docx"}, locations(entries))
+
+	entries, err = FilesAtRun(records, "r2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.docx", "c.docx"}, locations(entries))
+	assert.Equal(t, base, entries[0].DownloadTime, "skipped files keep their original download time")
+
+	_, err = FilesAtRun(records, "r3")
+	assert.ErrorIs(t, err, ErrRunNotFound)
+
+	runs := ListRuns(records)
+	require.Len(t, runs, 2)
+	assert.Equal(t, "r1", runs[0].RunID)
+	assert.Equal(t, 2, runs[0].Counts[EventDownloaded])
+	assert.Equal(t, "r2", runs[1].RunID)
+	assert.Equal(t, base.Add(time.Hour), runs[1].Start)
+	assert.Equal(t, base.Add(time.Hour+3*time.Second), runs[1].End)
+	assert.Equal(t, 1, runs[1].Counts[EventRemoved])
+	assert.Equal(t, 1, runs[1].Counts[EventFailed])
+}
+
+func TestDownloadHistoryJournal(t *testing.T) {
+	dir := t.TempDir()
+	journalPath := filepath.Join(dir, "history.json.journal")
+	j, err := OpenJournal(journalPath, "run1")
+	require.NoError(t, err)
+
+	history, err := NewDownloadHistory(filepath.Join(dir, "history.json"), WithJournal(j))
+	require.NoError(t, err)
+	require.NoError(t, history.Load())
+	history.items["old.docx"] = DownloadItem{FileID: "id0", Hash: "h0", DownloadStatus: StatusLoaded}
+	history.items["kept.docx"] = DownloadItem{FileID: "id1", Hash: "h1", DownloadStatus: StatusLoaded}
+
+	require.NoError(t, history.MarkSkipped("kept.docx"))
+	require.NoError(t, history.SetDownloaded("new.docx", DownloadItem{FileID: "id2", Hash: "h2"}))
+	require.NoError(t, history.RecordFailure("bad.docx", "id3", errors.New("export failed")))
+	require.NoError(t, history.Save())
+	require.NoError(t, history.RecordRemoved("old.docx"))
+	require.NoError(t, j.Close())
+
+	records, err := ReadJournal(journalPath)
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	assert.Equal(t, EventSkipped, records[0].Event)
+	assert.Equal(t, EventDownloaded, records[1].Event)
+	assert.Equal(t, "h2", string(records[1].Hash))
+	assert.Equal(t, EventFailed, records[2].Event)
+	assert.Equal(t, "export failed", records[2].Error)
+	assert.Equal(t, EventRemoved, records[3].Event)
+	assert.Equal(t, "id0", string(records[3].FileID))
+
+	t.Run("journal errors are reported after the state change", func(t *testing.T) {
+		closed, err := OpenJournal(filepath.Join(dir, "closed.journal"), "run2")
+		require.NoError(t, err)
+		require.NoError(t, closed.Close())
+		h, err := NewDownloadHistory(filepath.Join(dir, "other.json"), WithJournal(closed))
+		require.NoError(t, err)
+		require.NoError(t, h.Load())
+		err = h.SetDownloaded("x.docx", DownloadItem{FileID: "id"})
+		assert.ErrorIs(t, err, ErrJournalAppend)
+		_, exists, _ := h.GetItem("x.docx")
+		assert.True(t, exists)
+	})
+}
@@ -19,6 +19,15 @@ type jsonHeader struct {
 	Version int    `json:"version"`
 	Magic   string `json:"magic"`
 	Created string `json:"created"`
+
+	// Incomplete is set when the file is a checkpoint of a run that has not finished yet.
+	Incomplete *jsonIncompleteRun `json:"incomplete_run,omitempty"`
+}
+
+// jsonIncompleteRun is used for marshaling/unmarshaling InterruptedRun.
+type jsonIncompleteRun struct {
+	RunID   string `json:"run_id"`
+	Started string `json:"started"`
 }
 
 // jsonDownloadItem is used for marshaling/unmarshaling DownloadItem to/from JSON.
@@ -35,27 +44,37 @@ type jsonDownloadHistory struct {
 }
 
 // loadItemsFromReader loads items from a reader without holding any locks.
-// It returns the loaded items and any error encountered.
-func loadItemsFromReader(r io.Reader) (map[string]DownloadItem, error) {
+// It returns the loaded items, the unfinished run the file was checkpointed by (nil if the file was saved
+// by a completed run) and any error encountered.
+func loadItemsFromReader(r io.Reader) (map[string]DownloadItem, *InterruptedRun, error) {
 	content, err := io.ReadAll(r)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read content: %w", err)
+		return nil, nil, fmt.Errorf("failed to read content: %w", err)
 	}
 
 	var history jsonDownloadHistory
 	if err := json.Unmarshal(content, &history); err != nil {
-		return nil, fmt.Errorf("failed to decode history: %w", err)
+		return nil, nil, fmt.Errorf("failed to decode history: %w", err)
 	}
 
 	if err := history.Header.validate(); err != nil {
-		return nil, fmt.Errorf("invalid history header: %w", err)
+		return nil, nil, fmt.Errorf("invalid history header: %w", err)
+	}
+
+	var interrupted *InterruptedRun
+	if inc := history.Header.Incomplete; inc != nil {
+		started, err := time.Parse(time.RFC3339, inc.Started)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse run start time: %w", err)
+		}
+		interrupted = &InterruptedRun{RunID: inc.RunID, Started: started}
 	}
 
 	items := make(map[string]DownloadItem, len(history.Items))
 	for _, item := range history.Items {
 		downloadTime, err := time.Parse(time.RFC3339, item.DownloadTime)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse download time: %w", err)
+			return nil, nil, fmt.Errorf("failed to parse download time: %w", err)
 		}
 
 		di := DownloadItem{
@@ -66,18 +85,19 @@ func loadItemsFromReader(r io.Reader) (map[string]DownloadItem, error) {
 		}
 
 		if _, exists := items[item.Location]; exists {
-			return nil, fmt.Errorf("duplicate location: %s", item.Location)
+			return nil, nil, fmt.Errorf("duplicate location: %s", item.Location)
 		}
 
 		items[item.Location] = di
 	}
 
-	return items, nil
+	return items, interrupted, nil
 }
 
 // saveToWriter writes the provided items to the writer in JSON format.
+// If interrupted is not nil, the file is marked as a checkpoint of that unfinished run.
 // This function does not hold any locks and is safe to call with the items map.
-func saveToWriter(w io.Writer, items map[string]DownloadItem) error {
+func saveToWriter(w io.Writer, items map[string]DownloadItem, interrupted *InterruptedRun) error {
 	// Convert items to JSON structure
 	jsonItems := make([]jsonDownloadItem, 0, len(items))
 	for location, item := range items {
@@ -97,6 +117,12 @@ func saveToWriter(w io.Writer, items map[string]DownloadItem) error {
 		},
 		Items: jsonItems,
 	}
+	if interrupted != nil {
+		history.Header.Incomplete = &jsonIncompleteRun{
+			RunID:   interrupted.RunID,
+			Started: interrupted.Started.Format(time.RFC3339),
+		}
+	}
 
 	encoder := json.NewEncoder(w)
 	encoder.SetIndent("", "  ")
@@ -33,7 +33,7 @@ func TestLoadFromReader(t *testing.T) {
 		]
 	}`
 
-	items, err := loadItemsFromReader(strings.NewReader(json))
+	items, _, err := loadItemsFromReader(strings.NewReader(json))
 	require.NoError(t, err)
 
 	item, exists := items["/path/to/file.odoc"]
@@ -60,7 +60,7 @@ func TestLoadFromReader(t *testing.T) {
 			]
 		` // Missing closing bracket
 
-		_, err := loadItemsFromReader(strings.NewReader(invalidJSON))
+		_, _, err := loadItemsFromReader(strings.NewReader(invalidJSON))
 		assert.NotNil(t, err)
 		assert.Contains(t, err.Error(), "unexpected end of JSON input")
 	})
@@ -76,7 +76,7 @@ func TestLoadFromReader(t *testing.T) {
 			"items": []
 		}`
 
-		_, err := loadItemsFromReader(strings.NewReader(invalidVersionJSON))
+		_, _, err := loadItemsFromReader(strings.NewReader(invalidVersionJSON))
 		assert.NotNil(t, err)
 		assert.Contains(t, err.Error(), "unsupported version: 1")
 	})
@@ -92,7 +92,7 @@ func TestLoadFromReader(t *testing.T) {
 			"items": []
 		}`
 
-		_, err := loadItemsFromReader(strings.NewReader(invalidMagicJSON))
+		_, _, err := loadItemsFromReader(strings.NewReader(invalidMagicJSON))
 		assert.NotNil(t, err)
 		assert.Contains(t, err.Error(), "invalid magic: WRONG_MAGIC_STRING")
 	})
@@ -115,7 +115,7 @@ func TestLoadFromReader(t *testing.T) {
 			]
 		}`
 
-		_, err := loadItemsFromReader(strings.NewReader(invalidDateJSON))
+		_, _, err := loadItemsFromReader(strings.NewReader(invalidDateJSON))
 		assert.NotNil(t, err)
 		assert.Contains(t, err.Error(), "failed to parse download time")
 	})
@@ -144,7 +144,7 @@ func TestLoadFromReader(t *testing.T) {
 			]
 		}`
 
-		_, err := loadItemsFromReader(strings.NewReader(duplicateLocationJSON))
+		_, _, err := loadItemsFromReader(strings.NewReader(duplicateLocationJSON))
 		assert.NotNil(t, err)
 		assert.Contains(t, err.Error(), "duplicate location")
 	})
@@ -171,7 +171,7 @@ func TestSaveToWriter(t *testing.T) {
 	t.Run("Successful write", func(t *testing.T) {
 		var buf strings.Builder
 		// Save to custom writer using the package function
-		err := saveToWriter(&buf, items)
+		err := saveToWriter(&buf, items, nil)
 		assert.Nil(t, err)
 
 		// Verify the output contains expected data
@@ -188,7 +188,7 @@ func TestSaveToWriter(t *testing.T) {
 		assert.Contains(t, output, "2023-10-02T08:17:39Z")
 
 		// Parse the saved data to ensure it's valid
-		loadedItems, err := loadItemsFromReader(strings.NewReader(output))
+		loadedItems, _, err := loadItemsFromReader(strings.NewReader(output))
 		assert.Nil(t, err)
 		assert.Len(t, loadedItems, 2)
 
@@ -209,7 +209,7 @@ func TestSaveToWriter(t *testing.T) {
 		// Create a mock writer that returns an error on Write
 		errorWriter := &mockErrorWriter{}
 		// Save to custom writer that returns an error
-		err := saveToWriter(errorWriter, items)
+		err := saveToWriter(errorWriter, items, nil)
 		assert.NotNil(t, err)
 		assert.Contains(t, err.Error(), "file write error")
 	})
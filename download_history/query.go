@@ -0,0 +1,113 @@
+package download_history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	synd "github.com/isseis/go-synology-office-exporter/synology_drive_api"
+)
+
+// Entry pairs a DownloadItem with the location it is recorded under.
+type Entry struct {
+	Location string
+	DownloadItem
+}
+
+// Query selects history entries. Zero-valued fields are ignored, so an empty Query matches every entry.
+type Query struct {
+	PathContains string      // Matches entries whose location contains this substring
+	FileID       synd.FileID // Matches entries with exactly this file ID
+	Since        time.Time   // Matches entries downloaded at or after this time
+	Until        time.Time   // Matches entries downloaded before this time
+}
+
+// matches reports whether the entry satisfies every condition of the query.
+func (q Query) matches(location string, item DownloadItem) bool {
+	if q.PathContains != "" && !strings.Contains(location, q.PathContains) {
+		return false
+	}
+	if q.FileID != "" && item.FileID != q.FileID {
+		return false
+	}
+	if !q.Since.IsZero() && item.DownloadTime.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !item.DownloadTime.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// FindItems returns the entries matching the query, sorted by location.
+// Returns an error if the history is not in the ready state.
+// This method is safe for concurrent use.
+func (d *DownloadHistory) FindItems(q Query) ([]Entry, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.state != stateReady {
+		return nil, ErrNotReady
+	}
+
+	var entries []Entry
+	for location, item := range d.items {
+		if q.matches(location, item) {
+			entries = append(entries, Entry{Location: location, DownloadItem: item})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Location < entries[j].Location
+	})
+	return entries, nil
+}
+
+// RemoveItem deletes the entry recorded under location, so that the file is exported again on the next run.
+// Returns ErrHistoryItemNotFound if the entry does not exist, or an error if the history is not in the ready state.
+// This method is safe for concurrent use.
+func (d *DownloadHistory) RemoveItem(location string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.state != stateReady {
+		return ErrNotReady
+	}
+
+	if _, ok := d.items[location]; !ok {
+		return ErrHistoryItemNotFound
+	}
+	delete(d.items, location)
+	return nil
+}
+
+// PruneMissing deletes every entry whose file no longer exists under baseDir and returns the removed locations, sorted.
+// Returns an error if the history is not in the ready state or if a file cannot be inspected.
+// This method is safe for concurrent use.
+func (d *DownloadHistory) PruneMissing(baseDir string) ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.state != stateReady {
+		return nil, ErrNotReady
+	}
+
+	var pruned []string
+	for location := range d.items {
+		_, err := os.Stat(filepath.Join(baseDir, location))
+		if err == nil {
+			continue
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to stat %s: %w", location, err)
+		}
+		pruned = append(pruned, location)
+	}
+	for _, location := range pruned {
+		delete(d.items, location)
+	}
+	sort.Strings(pruned)
+	return pruned, nil
+}
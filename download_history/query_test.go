@@ -0,0 +1,109 @@
+//go:build test
+// +build test
+
+package download_history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindItems(t *testing.T) {
+	baseTime := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	items := map[string]DownloadItem{
+		"mydrive/a.docx":     {FileID: "id1", Hash: "h1", DownloadTime: baseTime},
+		"mydrive/sub/b.xlsx": {FileID: "id2", Hash: "h2", DownloadTime: baseTime.Add(24 * time.Hour)},
+		"team/c.pptx":        {FileID: "id3", Hash: "h3", DownloadTime: baseTime.Add(48 * time.Hour)},
+	}
+
+	locations := func(entries []Entry) []string {
+		var ret []string
+		for _, e := range entries {
+			ret = append(ret, e.Location)
+		}
+		return ret
+	}
+
+	cases := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"empty query matches all", Query{}, []string{"mydrive/a.docx", "mydrive/sub/b.xlsx", "team/c.pptx"}},
+		{"path substring", Query{PathContains: "mydrive/"}, []string{"mydrive/a.docx", "mydrive/sub/b.xlsx"}},
+		{"file ID", Query{FileID: "id3"}, []string{"team/c.pptx"}},
+		{"since is inclusive", Query{Since: baseTime.Add(24 * time.Hour)}, []string{"mydrive/sub/b.xlsx", "team/c.pptx"}},
+		{"until is exclusive", Query{Until: baseTime.Add(24 * time.Hour)}, []string{"mydrive/a.docx"}},
+		{"combined conditions", Query{PathContains: "mydrive", Since: baseTime.Add(time.Hour)}, []string{"mydrive/sub/b.xlsx"}},
+		{"no match", Query{FileID: "missing"}, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			th := NewDownloadHistoryForTest(t, items)
+			defer th.Close()
+			entries, err := th.FindItems(tc.query)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, locations(entries))
+		})
+	}
+
+	t.Run("returns error when not ready", func(t *testing.T) {
+		th := NewDownloadHistoryForTest(t, items, WithInitialState(stateNew))
+		defer th.Close()
+		_, err := th.FindItems(Query{})
+		assert.ErrorIs(t, err, ErrNotReady)
+	})
+}
+
+func TestRemoveItem(t *testing.T) {
+	item := DownloadItem{FileID: "id1", Hash: "h1", DownloadStatus: StatusLoaded}
+
+	t.Run("removes existing item", func(t *testing.T) {
+		th := NewDownloadHistoryForTest(t, map[string]DownloadItem{"file1": item})
+		defer th.Close()
+		require.NoError(t, th.RemoveItem("file1"))
+		_, exists, err := th.GetItem("file1")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		th := NewDownloadHistoryForTest(t, map[string]DownloadItem{})
+		defer th.Close()
+		assert.ErrorIs(t, th.RemoveItem("file1"), ErrHistoryItemNotFound)
+	})
+
+	t.Run("returns error after save", func(t *testing.T) {
+		th := NewDownloadHistoryForTest(t, map[string]DownloadItem{"file1": item}, WithTempDir("history.json"))
+		defer th.Close()
+		require.NoError(t, th.Save())
+		assert.ErrorIs(t, th.RemoveItem("file1"), ErrNotReady)
+	})
+}
+
+func TestPruneMissing(t *testing.T) {
+	baseDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(baseDir, "mydrive"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(baseDir, "mydrive", "present.docx"), []byte("x"), 0644))
+
+	th := NewDownloadHistoryForTest(t, map[string]DownloadItem{
+		"mydrive/present.docx": {FileID: "id1"},
+		"mydrive/gone.docx":    {FileID: "id2"},
+		"team/gone.xlsx":       {FileID: "id3"},
+	})
+	defer th.Close()
+
+	pruned, err := th.PruneMissing(baseDir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mydrive/gone.docx", "team/gone.xlsx"}, pruned)
+
+	entries, err := th.FindItems(Query{})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "mydrive/present.docx", entries[0].Location)
+}
@@ -6,4 +6,5 @@ type ExportStats struct {
 	Skipped    int // Number of skipped files (already up-to-date)
 	Ignored    int // Number of ignored files (not exportable)
 	Errors     int // Number of errors occurred
+	Mismatched int // Number of local files that did not match the remote file during reconcile
 }
@@ -0,0 +1,155 @@
+package filelock
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// unreadableLockGrace is how long a lock file with unreadable content is assumed to be in the middle of
+// being written by its holder. After that it is considered left behind by a crash.
+const unreadableLockGrace = time.Minute
+
+// tryLockExclusive acquires the lock by creating lockFile exclusively, breaking a stale lock file first.
+// It is the lock implementation on platforms without kernel locks.
+func tryLockExclusive(lockFile string, o *options) (func(), error) {
+	unlock, err := createLockFile(lockFile, o)
+	if !errors.Is(err, ErrLockHeld) {
+		return unlock, err
+	}
+	broken, err := o.breakIfStale(lockFile)
+	if err != nil {
+		return nil, errors.Join(ErrLockHeld, err)
+	}
+	if !broken {
+		return nil, ErrLockHeld
+	}
+	return createLockFile(lockFile, o)
+}
+
+// createLockFile creates lockFile exclusively and writes the lock info of this process into it.
+// Returns ErrLockHeld if the file already exists.
+func createLockFile(lockFile string, o *options) (func(), error) {
+	f, err := os.OpenFile(lockFile, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, ErrLockHeld
+		}
+		return nil, fmt.Errorf("failed to create lock file: %w", err)
+	}
+	defer f.Close()
+
+	if err := o.writeLockInfo(f); err != nil {
+		os.Remove(lockFile) // Clean up if we fail to write
+		return nil, err
+	}
+	return func() { removeLockFile(lockFile) }, nil
+}
+
+// isHeldExclusive reports whether lockFile exists and is not stale.
+func isHeldExclusive(lockFile string, o *options) (bool, error) {
+	data, err := os.ReadFile(lockFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to read lock file: %w", err)
+	}
+	stat, err := os.Stat(lockFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to stat lock file: %w", err)
+	}
+	return o.staleReason(data, stat.ModTime()) == "", nil
+}
+
+// staleReason returns why the lock described by data is stale, or an empty string if it must be honoured.
+// modTime is the modification time of the lock file, used when the lock info cannot be read.
+func (o *options) staleReason(data []byte, modTime time.Time) string {
+	now := o.now()
+	var info LockInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		if now.Sub(modTime) >= unreadableLockGrace {
+			return "lock file is unreadable"
+		}
+		return ""
+	}
+
+	acquired, err := time.Parse(time.RFC3339, info.Timestamp)
+	if err != nil {
+		acquired = modTime
+	}
+	expired := o.maxAge > 0 && now.Sub(acquired) >= o.maxAge
+
+	hostname, _ := os.Hostname()
+	if info.Hostname == "" || info.Hostname != hostname {
+		switch {
+		case !o.force:
+			return ""
+		case o.maxAge == 0:
+			return "forced break of lock from another host"
+		case expired:
+			return "lock from another host exceeded maximum age"
+		}
+		return ""
+	}
+	if !processAlive(info.PID) {
+		return "holder process is not running"
+	}
+	if expired {
+		return "lock exceeded maximum age"
+	}
+	return ""
+}
+
+// breakIfStale removes lockFile if it is stale and reports whether it did.
+// The lock file is first moved aside and compared with the content judged stale, so that a lock acquired
+// by another process in the meantime is restored instead of being removed.
+func (o *options) breakIfStale(lockFile string) (bool, error) {
+	data, err := os.ReadFile(lockFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil // Released in the meantime
+		}
+		return false, fmt.Errorf("failed to read lock file: %w", err)
+	}
+	stat, err := os.Stat(lockFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, fmt.Errorf("failed to stat lock file: %w", err)
+	}
+	reason := o.staleReason(data, stat.ModTime())
+	if reason == "" {
+		return false, nil
+	}
+
+	aside := fmt.Sprintf("%s.stale.%d", lockFile, os.Getpid())
+	if err := os.Rename(lockFile, aside); err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, fmt.Errorf("failed to break stale lock: %w", err)
+	}
+	defer os.Remove(aside)
+	current, err := os.ReadFile(aside)
+	if err != nil || !bytes.Equal(current, data) {
+		// Another process replaced the stale lock after it was inspected; put its lock back.
+		if err := os.Link(aside, lockFile); err != nil && !os.IsExist(err) {
+			return false, fmt.Errorf("failed to restore lock file: %w", err)
+		}
+		return false, nil
+	}
+
+	var info LockInfo
+	_ = json.Unmarshal(data, &info) // Best effort; an unreadable lock is logged with zero values
+	o.logger.Warn("Breaking stale lock", "path", lockFile, "reason", reason,
+		"pid", info.PID, "hostname", info.Hostname, "timestamp", info.Timestamp)
+	return true, nil
+}
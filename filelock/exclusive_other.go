@@ -0,0 +1,13 @@
+//go:build !linux
+
+package filelock
+
+// acquire takes the lock by creating lockFile exclusively, as kernel locks are not used on this platform.
+func acquire(lockFile string, o *options) (func(), error) {
+	return tryLockExclusive(lockFile, o)
+}
+
+// isHeld reports whether lockFile exists and is not stale.
+func isHeld(lockFile string, o *options) (bool, error) {
+	return isHeldExclusive(lockFile, o)
+}
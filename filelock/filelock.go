@@ -6,6 +6,7 @@ package filelock
 import (
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"time"
@@ -16,15 +17,131 @@ type LockInfo struct {
 	PID       int    `json:"pid"`       // Process ID of the lock holder
 	Timestamp string `json:"timestamp"` // When the lock was acquired (RFC3339 format)
 	Hostname  string `json:"hostname"`  // Host where the lock was acquired
+
+	Details map[string]string `json:"details,omitempty"` // Optional holder details set with WithDetails
 }
 
 // ErrLockHeld is returned when attempting to acquire a lock that is already held.
 var ErrLockHeld = fmt.Errorf("lock already held")
 
+// Logger receives a log entry whenever a stale lock is broken or taken over, and while Lock waits for a lock.
+type Logger interface {
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+}
+
+// Default wait settings of Lock.
+const (
+	DefaultInitialBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff     = 5 * time.Second
+	DefaultWaitLogPeriod  = 30 * time.Second
+)
+
+// options holds the settings applied by Option functions.
+type options struct {
+	maxAge  time.Duration
+	force   bool
+	logger  Logger
+	now     func() time.Time
+	details map[string]string
+
+	timeout        time.Duration // Lock gives up after this long; zero waits until the context is done
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	waitLogPeriod  time.Duration // Lock logs the holder again after waiting this long
+}
+
+// Option configures how a lock is acquired.
+type Option func(*options)
+
+// WithMaxAge treats locks older than maxAge as stale even if their holder appears to be running,
+// which covers a PID reused by an unrelated process. Zero disables the age limit.
+// It has no effect on Linux, where a kernel lock is only ever held by a running process.
+func WithMaxAge(maxAge time.Duration) Option {
+	return func(o *options) {
+		o.maxAge = maxAge
+	}
+}
+
+// WithForce allows breaking locks acquired on another host, whose holder cannot be checked.
+// Combined with WithMaxAge only locks older than the maximum age are broken; otherwise any lock from another host is.
+// It has no effect on Linux, where a kernel lock is never broken.
+func WithForce(force bool) Option {
+	return func(o *options) {
+		o.force = force
+	}
+}
+
+// WithLogger sets the logger that records broken stale locks. The default is slog.Default().
+func WithLogger(logger Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithDetails records details about the holder, such as what it is working on, in the lock file.
+// They are returned by ReadLockInfo for diagnostics.
+func WithDetails(details map[string]string) Option {
+	return func(o *options) {
+		o.details = details
+	}
+}
+
+// WithTimeout makes Lock give up waiting after timeout. Zero waits until the context is done.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithBackoff sets the wait between attempts of Lock: it starts at initial and doubles after every attempt
+// up to max.
+func WithBackoff(initial, max time.Duration) Option {
+	return func(o *options) {
+		o.initialBackoff = initial
+		o.maxBackoff = max
+	}
+}
+
+// WithWaitLogPeriod sets how often Lock logs the lock holder while waiting. The holder is always logged when
+// the wait starts.
+func WithWaitLogPeriod(period time.Duration) Option {
+	return func(o *options) {
+		o.waitLogPeriod = period
+	}
+}
+
+// newOptions returns the default options with opts applied.
+func newOptions(opts []Option) *options {
+	o := &options{
+		logger:         slog.Default(),
+		now:            time.Now,
+		initialBackoff: DefaultInitialBackoff,
+		maxBackoff:     DefaultMaxBackoff,
+		waitLogPeriod:  DefaultWaitLogPeriod,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
 // TryLock attempts to acquire a lock for the given file.
 // Returns a function to release the lock, or an error if the lock could not be acquired.
-// The lock is automatically released if the process exits.
-func TryLock(path string) (func(), error) {
+//
+// On Linux the lock is an open file description lock on <path>.lock, which the kernel releases when the holder exits,
+// however it exits. A lock file left behind by a holder that has exited is taken over with a log entry.
+//
+// On other platforms the lock is the exclusively created file <path>.lock, which is left behind if the
+// holder does not exit cleanly. If the file exists, TryLock checks whether the lock is stale: a lock acquired
+// on this host whose process is no longer running, or one older than the maximum age set by WithMaxAge.
+// Stale locks are broken with a log entry and acquired. Locks acquired on another host are only broken
+// with WithForce.
+//
+// In both cases the lock file contains the LockInfo of the holder, which ReadLockInfo returns.
+func TryLock(path string, opts ...Option) (func(), error) {
+	o := newOptions(opts)
+
 	// Convert to absolute path to handle relative paths consistently
 	absPath, err := filepath.Abs(path)
 	if err != nil {
@@ -32,57 +149,57 @@ func TryLock(path string) (func(), error) {
 	}
 
 	// Create lock file with .lock extension
-	lockFile := absPath + ".lock"
-
-	// Check if lock file exists and read its content if it does
-	if _, err := os.Stat(lockFile); err == nil {
-		return nil, ErrLockHeld
-	}
+	return acquire(absPath+".lock", o)
+}
 
-	// Prepare lock info
+// newLockInfo returns the lock info describing this process.
+func (o *options) newLockInfo() LockInfo {
 	info := LockInfo{
 		PID:       os.Getpid(),
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Details:   o.details,
 	}
-
 	info.Hostname, _ = os.Hostname() // Ignore error, hostname is optional
+	return info
+}
 
-	// Create and write lock file
-	f, err := os.OpenFile(lockFile, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
-	if err != nil {
-		if os.IsExist(err) {
-			return nil, ErrLockHeld
-		}
-		return nil, fmt.Errorf("failed to create lock file: %w", err)
-	}
-	defer f.Close()
-
+// writeLockInfo writes the lock info of this process to f and syncs it to disk.
+func (o *options) writeLockInfo(f *os.File) error {
 	encoder := json.NewEncoder(f)
 	encoder.SetIndent("", "  ") // Pretty print for debugging
-	if err := encoder.Encode(info); err != nil {
-		os.Remove(lockFile) // Clean up if we fail to write
-		return nil, fmt.Errorf("failed to write lock info: %w", err)
+	if err := encoder.Encode(o.newLockInfo()); err != nil {
+		return fmt.Errorf("failed to write lock info: %w", err)
 	}
 
 	// Ensure the data is written to disk
 	if err := f.Sync(); err != nil {
-		os.Remove(lockFile) // Clean up if we fail to sync
-		return nil, fmt.Errorf("failed to sync lock file: %w", err)
+		return fmt.Errorf("failed to sync lock file: %w", err)
 	}
+	return nil
+}
 
-	// Create cleanup function
-	unlock := func() {
-		if err := os.Remove(lockFile); err != nil && !os.IsNotExist(err) {
-			// Log the error but don't return it as the unlock function signature doesn't support it
-			fmt.Fprintf(os.Stderr, "Warning: failed to remove lock file: %v\n", err)
-		}
+// removeLockFile removes lockFile, warning on stderr if it fails.
+func removeLockFile(lockFile string) {
+	if err := os.Remove(lockFile); err != nil && !os.IsNotExist(err) {
+		// Log the error but don't return it as the unlock function signature doesn't support it
+		fmt.Fprintf(os.Stderr, "Warning: failed to remove lock file: %v\n", err)
 	}
+}
 
-	return unlock, nil
+// IsHeld reports whether the lock for the given file is currently held.
+// A lock file left behind by a holder that has exited is not reported as held; on platforms without
+// kernel locks the stale lock checks of TryLock apply, using opts.
+func IsHeld(path string, opts ...Option) (bool, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	return isHeld(absPath+".lock", newOptions(opts))
 }
 
 // ReadLockInfo reads and parses the lock file information.
 // Returns the lock info if the file exists and is valid, or an error otherwise.
+// On Linux the lock file may remain after its holder has exited, so the info may describe a former holder.
 func ReadLockInfo(path string) (*LockInfo, error) {
 	absPath, err := filepath.Abs(path)
 	if err != nil {
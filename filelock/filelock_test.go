@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -198,3 +200,172 @@ func TestLockFileContent(t *testing.T) {
 		}
 	}
 }
+
+// recordingLogger collects log messages.
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages []string // Warnings
+	infos    []string
+}
+
+func (l *recordingLogger) Info(msg string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.infos = append(l.infos, fmt.Sprint(append([]any{msg}, args...)...))
+}
+
+func (l *recordingLogger) Warn(msg string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, fmt.Sprint(append([]any{msg}, args...)...))
+}
+
+// writeTestLockInfo writes a lock file for path as if it had been acquired by another process.
+func writeTestLockInfo(t *testing.T, path string, info LockInfo) {
+	t.Helper()
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatalf("Failed to marshal lock info: %v", err)
+	}
+	if err := os.WriteFile(path+".lock", data, 0o600); err != nil {
+		t.Fatalf("Failed to write lock file: %v", err)
+	}
+}
+
+// deadPID returns the PID of a process that has already exited.
+func deadPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Failed to run helper process: %v", err)
+	}
+	return cmd.Process.Pid
+}
+
+// tryLockExclusiveAt acquires the lock for path with the exclusive lock file implementation,
+// which is the one used on platforms without kernel locks.
+func tryLockExclusiveAt(path string, opts ...Option) (func(), error) {
+	return tryLockExclusive(path+".lock", newOptions(opts))
+}
+
+func TestTryLockExclusiveStale(t *testing.T) {
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		t.Skip("Skipping test because the hostname is unavailable")
+	}
+	now := time.Now().UTC()
+	old := now.Add(-2 * time.Hour).Format(time.RFC3339)
+	recent := now.Format(time.RFC3339)
+	dead := deadPID(t)
+
+	tests := []struct {
+		name      string
+		info      LockInfo
+		opts      []Option
+		wantBreak bool
+	}{
+		{"holder not running", LockInfo{PID: dead, Hostname: hostname, Timestamp: recent}, nil, true},
+		{"holder running", LockInfo{PID: os.Getpid(), Hostname: hostname, Timestamp: recent}, nil, false},
+		{"holder running within max age", LockInfo{PID: os.Getpid(), Hostname: hostname, Timestamp: recent}, []Option{WithMaxAge(time.Hour)}, false},
+		{"holder running beyond max age", LockInfo{PID: os.Getpid(), Hostname: hostname, Timestamp: old}, []Option{WithMaxAge(time.Hour)}, true},
+		{"other host", LockInfo{PID: dead, Hostname: "other-" + hostname, Timestamp: old}, []Option{WithMaxAge(time.Hour)}, false},
+		{"other host forced", LockInfo{PID: os.Getpid(), Hostname: "other-" + hostname, Timestamp: recent}, []Option{WithForce(true)}, true},
+		{"other host forced within max age", LockInfo{PID: os.Getpid(), Hostname: "other-" + hostname, Timestamp: recent}, []Option{WithForce(true), WithMaxAge(time.Hour)}, false},
+		{"other host forced beyond max age", LockInfo{PID: os.Getpid(), Hostname: "other-" + hostname, Timestamp: old}, []Option{WithForce(true), WithMaxAge(time.Hour)}, true},
+		{"unknown host", LockInfo{PID: dead}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "history.json")
+			writeTestLockInfo(t, path, tt.info)
+			log := &recordingLogger{}
+
+			unlock, err := tryLockExclusiveAt(path, append(tt.opts, WithLogger(log))...)
+			if !tt.wantBreak {
+				if err != ErrLockHeld {
+					t.Fatalf("Expected ErrLockHeld, got %v", err)
+				}
+				if len(log.messages) != 0 {
+					t.Errorf("Unexpected log entries: %v", log.messages)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected stale lock to be broken, got %v", err)
+			}
+			defer unlock()
+			if len(log.messages) != 1 || !strings.Contains(log.messages[0], "Breaking stale lock") {
+				t.Errorf("Expected a log entry for the broken lock, got %v", log.messages)
+			}
+			info, err := ReadLockInfo(path)
+			if err != nil {
+				t.Fatalf("Failed to read lock info: %v", err)
+			}
+			if info.PID != os.Getpid() {
+				t.Errorf("Expected lock to be held by PID %d, got %d", os.Getpid(), info.PID)
+			}
+			matches, _ := filepath.Glob(path + ".lock.stale.*")
+			if len(matches) != 0 {
+				t.Errorf("Stale lock files left behind: %v", matches)
+			}
+		})
+	}
+
+	t.Run("unreadable lock file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "history.json")
+		if err := os.WriteFile(path+".lock", nil, 0o600); err != nil {
+			t.Fatalf("Failed to write lock file: %v", err)
+		}
+		if _, err := tryLockExclusiveAt(path, WithLogger(&recordingLogger{})); err != ErrLockHeld {
+			t.Fatalf("A lock being written must be honoured, got %v", err)
+		}
+
+		past := time.Now().Add(-2 * unreadableLockGrace)
+		if err := os.Chtimes(path+".lock", past, past); err != nil {
+			t.Fatalf("Failed to change lock file time: %v", err)
+		}
+		unlock, err := tryLockExclusiveAt(path, WithLogger(&recordingLogger{}))
+		if err != nil {
+			t.Fatalf("Expected unreadable old lock to be broken, got %v", err)
+		}
+		unlock()
+	})
+}
+
+func TestLockDetails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run")
+	unlock, err := TryLock(path, WithDetails(map[string]string{"sources": "mydrive,shared"}))
+	if err != nil {
+		t.Fatalf("TryLock failed: %v", err)
+	}
+	defer unlock()
+
+	info, err := ReadLockInfo(path)
+	if err != nil {
+		t.Fatalf("Failed to read lock info: %v", err)
+	}
+	if info.Details["sources"] != "mydrive,shared" {
+		t.Errorf("Expected sources detail, got %v", info.Details)
+	}
+}
+
+func TestIsHeld(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+	if held, err := IsHeld(path); err != nil || held {
+		t.Fatalf("Expected free lock, got held=%v err=%v", held, err)
+	}
+
+	unlock, err := TryLock(path)
+	if err != nil {
+		t.Fatalf("TryLock failed: %v", err)
+	}
+	if held, err := IsHeld(path); err != nil || !held {
+		t.Errorf("Expected held lock, got held=%v err=%v", held, err)
+	}
+
+	unlock()
+	if held, err := IsHeld(path); err != nil || held {
+		t.Errorf("Expected free lock after unlock, got held=%v err=%v", held, err)
+	}
+}
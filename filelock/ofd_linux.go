@@ -0,0 +1,129 @@
+//go:build linux
+
+package filelock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// fcntl(2) commands for open file description locks, which the syscall package does not define.
+// They have the same values on all Linux architectures.
+const (
+	fOFDGetLk = 36
+	fOFDSetLk = 37
+)
+
+// wholeFile returns a lock of type typ on the whole file.
+func wholeFile(typ int16) *syscall.Flock_t {
+	return &syscall.Flock_t{Type: typ, Whence: io.SeekStart}
+}
+
+// acquire takes an open file description lock (F_OFD_SETLK) on lockFile and writes the lock info of this
+// process into it. Like flock(2), the lock belongs to the open file and the kernel releases it when the process
+// exits, so a lock can never outlive its holder; a lock file left behind is simply locked again. Unlike flock(2),
+// the lock can be tested with F_OFD_GETLK without taking it, see isHeld. WithMaxAge and WithForce have no effect.
+func acquire(lockFile string, o *options) (func(), error) {
+	for {
+		f, err := os.OpenFile(lockFile, os.O_CREATE|os.O_RDWR, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create lock file: %w", err)
+		}
+		if err := syscall.FcntlFlock(f.Fd(), fOFDSetLk, wholeFile(syscall.F_WRLCK)); err != nil {
+			f.Close()
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EACCES) {
+				return nil, ErrLockHeld
+			}
+			return nil, fmt.Errorf("failed to lock file: %w", err)
+		}
+
+		// A holder removes the lock file before releasing the lock. If that happened after the file was
+		// opened above, the lock is on a removed file and another process may create a new one; start over.
+		current, err := isCurrentFile(f, lockFile)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if !current {
+			f.Close()
+			continue
+		}
+
+		o.logLeftover(f, lockFile)
+		if err := resetFile(f); err == nil {
+			err = o.writeLockInfo(f)
+		}
+		if err != nil {
+			removeLockFile(lockFile)
+			f.Close()
+			return nil, err
+		}
+		return func() {
+			// Remove the file while still holding the lock, so a waiting process never locks a removed file unnoticed.
+			removeLockFile(lockFile)
+			f.Close()
+		}, nil
+	}
+}
+
+// isCurrentFile reports whether the open file f is still the file at path.
+func isCurrentFile(f *os.File, path string) (bool, error) {
+	opened, err := f.Stat()
+	if err != nil {
+		return false, fmt.Errorf("failed to stat lock file: %w", err)
+	}
+	current, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to stat lock file: %w", err)
+	}
+	return os.SameFile(opened, current), nil
+}
+
+// resetFile truncates f and rewinds it for writing new lock info.
+func resetFile(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return fmt.Errorf("failed to write lock info: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to write lock info: %w", err)
+	}
+	return nil
+}
+
+// logLeftover logs the lock info found in a lock file that was not removed by its previous holder,
+// which happens when the holder exits without unlocking.
+func (o *options) logLeftover(f *os.File, lockFile string) {
+	data, err := io.ReadAll(f)
+	if err != nil || len(data) == 0 {
+		return
+	}
+	var info LockInfo
+	_ = json.Unmarshal(data, &info) // Best effort; an unreadable lock is logged with zero values
+	o.logger.Warn("Breaking stale lock", "path", lockFile, "reason", "holder exited without releasing the lock",
+		"pid", info.PID, "hostname", info.Hostname, "timestamp", info.Timestamp)
+}
+
+// isHeld reports whether another open file description holds the lock on lockFile.
+// It tests the lock with F_OFD_GETLK, which does not take it, so it never makes a concurrent TryLock fail.
+func isHeld(lockFile string, o *options) (bool, error) {
+	f, err := os.Open(lockFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	defer f.Close()
+	lock := wholeFile(syscall.F_WRLCK)
+	if err := syscall.FcntlFlock(f.Fd(), fOFDGetLk, lock); err != nil {
+		return false, fmt.Errorf("failed to check lock file: %w", err)
+	}
+	return lock.Type != syscall.F_UNLCK, nil
+}
@@ -0,0 +1,124 @@
+//go:build linux
+
+package filelock
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestHelperHoldLock is not a real test. It acquires the lock named by FILELOCK_HELPER_PATH when run
+// as a child process by TestLockReleasedOnProcessExit and holds it until killed.
+func TestHelperHoldLock(t *testing.T) {
+	path := os.Getenv("FILELOCK_HELPER_PATH")
+	if path == "" {
+		t.Skip("Helper process for TestLockReleasedOnProcessExit")
+	}
+	if _, err := TryLock(path); err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+	fmt.Println("locked")
+	time.Sleep(time.Minute)
+	os.Exit(0)
+}
+
+func TestLockReleasedOnProcessExit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperHoldLock$")
+	cmd.Env = append(os.Environ(), "FILELOCK_HELPER_PATH="+path)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start helper process: %v", err)
+	}
+	defer cmd.Process.Kill()
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != "locked" {
+		t.Fatalf("Helper process failed to lock: %q, %v", line, err)
+	}
+
+	if _, err := TryLock(path); err != ErrLockHeld {
+		t.Fatalf("Expected ErrLockHeld while the helper holds the lock, got %v", err)
+	}
+	info, err := ReadLockInfo(path)
+	if err != nil {
+		t.Fatalf("Failed to read lock info: %v", err)
+	}
+	if info.PID != cmd.Process.Pid {
+		t.Errorf("Expected lock info of PID %d, got %d", cmd.Process.Pid, info.PID)
+	}
+
+	// Killing the holder leaves the lock file behind, but the kernel releases the lock.
+	if err := cmd.Process.Kill(); err != nil {
+		t.Fatalf("Failed to kill helper process: %v", err)
+	}
+	_ = cmd.Wait()
+	if _, err := os.Stat(path + ".lock"); err != nil {
+		t.Fatalf("Expected lock file to be left behind: %v", err)
+	}
+	if held, err := IsHeld(path); err != nil || held {
+		t.Errorf("Expected a left behind lock file not to be held, got held=%v err=%v", held, err)
+	}
+
+	log := &recordingLogger{}
+	unlock, err := TryLock(path, WithLogger(log))
+	if err != nil {
+		t.Fatalf("Expected lock to be released by the kernel, got %v", err)
+	}
+	defer unlock()
+	if len(log.messages) != 1 || !strings.Contains(log.messages[0], fmt.Sprint(cmd.Process.Pid)) {
+		t.Errorf("Expected a log entry naming the former holder, got %v", log.messages)
+	}
+	info, err = ReadLockInfo(path)
+	if err != nil {
+		t.Fatalf("Failed to read lock info: %v", err)
+	}
+	if info.PID != os.Getpid() {
+		t.Errorf("Expected lock info of PID %d, got %d", os.Getpid(), info.PID)
+	}
+}
+
+func TestIsHeldDoesNotTakeLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				if _, err := IsHeld(path); err != nil {
+					t.Errorf("IsHeld failed: %v", err)
+					return
+				}
+			}
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		unlock, err := TryLock(path)
+		if err != nil {
+			t.Fatalf("TryLock failed while IsHeld was checking the lock: %v", err)
+		}
+		if held, err := IsHeld(path); err != nil || !held {
+			t.Fatalf("Expected the lock to be reported as held, got held=%v err=%v", held, err)
+		}
+		unlock()
+	}
+	close(stop)
+	wg.Wait()
+}
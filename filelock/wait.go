@@ -0,0 +1,73 @@
+package filelock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// Lock acquires the lock for the given file like TryLock, but waits while the lock is held by another process.
+// It retries with the backoff set by WithBackoff until the lock is acquired, the timeout set by WithTimeout
+// expires or ctx is done. The holder of the lock is logged when the wait starts and periodically afterwards.
+//
+// If the lock could not be acquired, the returned error wraps both ErrLockHeld and the context error,
+// context.DeadlineExceeded on timeout.
+func Lock(ctx context.Context, path string, opts ...Option) (func(), error) {
+	o := newOptions(opts)
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	lockFile := absPath + ".lock"
+
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+
+	backoff := o.initialBackoff
+	if backoff <= 0 {
+		backoff = DefaultInitialBackoff
+	}
+	maxBackoff := max(o.maxBackoff, backoff)
+	var waitStart, lastLog time.Time
+	for {
+		unlock, err := acquire(lockFile, o)
+		if !errors.Is(err, ErrLockHeld) {
+			if err == nil && !waitStart.IsZero() {
+				o.logger.Info("Acquired lock after waiting", "path", lockFile, "waited", o.now().Sub(waitStart).Round(time.Millisecond))
+			}
+			return unlock, err
+		}
+
+		now := o.now()
+		if waitStart.IsZero() {
+			waitStart = now
+		}
+		if lastLog.IsZero() || (o.waitLogPeriod > 0 && now.Sub(lastLog) >= o.waitLogPeriod) {
+			o.logHolder(lockFile, absPath, now.Sub(waitStart))
+			lastLog = now
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%w: %w", ErrLockHeld, ctx.Err())
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// logHolder logs that Lock is waiting for the lock held by the process described in the lock file.
+func (o *options) logHolder(lockFile, path string, waited time.Duration) {
+	args := []any{"path", lockFile, "waited", waited.Round(time.Millisecond)}
+	if info, err := ReadLockInfo(path); err == nil {
+		args = append(args, "holder_pid", info.PID, "holder_hostname", info.Hostname, "holder_since", info.Timestamp)
+	}
+	o.logger.Info("Waiting for lock held by another process", args...)
+}
@@ -0,0 +1,106 @@
+package filelock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	t.Run("free lock is acquired immediately", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "history.json")
+		log := &recordingLogger{}
+		unlock, err := Lock(context.Background(), path, WithLogger(log))
+		if err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		unlock()
+		if len(log.infos) != 0 {
+			t.Errorf("Unexpected log entries: %v", log.infos)
+		}
+	})
+
+	t.Run("waits until the holder releases the lock", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "history.json")
+		release, err := TryLock(path)
+		if err != nil {
+			t.Fatalf("TryLock failed: %v", err)
+		}
+		time.AfterFunc(100*time.Millisecond, release)
+
+		log := &recordingLogger{}
+		unlock, err := Lock(context.Background(), path, WithLogger(log), WithTimeout(10*time.Second),
+			WithBackoff(10*time.Millisecond, 20*time.Millisecond))
+		if err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		unlock()
+
+		log.mu.Lock()
+		defer log.mu.Unlock()
+		if len(log.infos) != 2 {
+			t.Fatalf("Expected a waiting and an acquired log entry, got %v", log.infos)
+		}
+		if !strings.Contains(log.infos[0], "Waiting for lock") || !strings.Contains(log.infos[0], fmt.Sprint(os.Getpid())) {
+			t.Errorf("Expected the holder to be logged while waiting, got %q", log.infos[0])
+		}
+		if !strings.Contains(log.infos[1], "Acquired lock after waiting") {
+			t.Errorf("Expected the acquisition to be logged, got %q", log.infos[1])
+		}
+	})
+
+	t.Run("holder is logged periodically", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "history.json")
+		release, err := TryLock(path)
+		if err != nil {
+			t.Fatalf("TryLock failed: %v", err)
+		}
+		defer release()
+
+		log := &recordingLogger{}
+		_, err = Lock(context.Background(), path, WithLogger(log), WithTimeout(200*time.Millisecond),
+			WithBackoff(10*time.Millisecond, 10*time.Millisecond), WithWaitLogPeriod(50*time.Millisecond))
+		if !errors.Is(err, ErrLockHeld) {
+			t.Fatalf("Expected ErrLockHeld, got %v", err)
+		}
+		if len(log.infos) < 2 {
+			t.Errorf("Expected the holder to be logged more than once, got %v", log.infos)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "history.json")
+		release, err := TryLock(path)
+		if err != nil {
+			t.Fatalf("TryLock failed: %v", err)
+		}
+		defer release()
+
+		_, err = Lock(context.Background(), path, WithLogger(&recordingLogger{}), WithTimeout(50*time.Millisecond),
+			WithBackoff(10*time.Millisecond, 10*time.Millisecond))
+		if !errors.Is(err, ErrLockHeld) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected ErrLockHeld and DeadlineExceeded, got %v", err)
+		}
+	})
+
+	t.Run("context cancellation", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "history.json")
+		release, err := TryLock(path)
+		if err != nil {
+			t.Fatalf("TryLock failed: %v", err)
+		}
+		defer release()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		_, err = Lock(ctx, path, WithLogger(&recordingLogger{}))
+		if !errors.Is(err, ErrLockHeld) || !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected ErrLockHeld and Canceled, got %v", err)
+		}
+	})
+}
@@ -1,58 +1,66 @@
 package logger
 
 import (
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // LoadConfig loads logger config from flags and environment variables.
 // Flags take precedence over environment variables.
 // The caller must call flag.Parse() before calling this function.
 func LoadConfig() (*Config, error) {
-	// Start with default values
-	levelStr := ""
-	webhookURL := ""
-	appName := ""
-	envName := ""
-
-	// Use flag values if they were set
-	if logLevelFlag != nil && *logLevelFlag != "" {
-		levelStr = *logLevelFlag
-	}
-	if webhookURLFlag != nil && *webhookURLFlag != "" {
-		webhookURL = *webhookURLFlag
-	}
-	if appNameFlag != nil && *appNameFlag != "" {
-		appName = *appNameFlag
+	levelStr := flagOrEnv(logLevelFlag, "LOG_LEVEL", "info")
+	format, err := ParseFormat(strings.ToLower(flagOrEnv(logFormatFlag, "LOG_FORMAT", "")))
+	if err != nil {
+		return nil, err
 	}
-	if envFlag != nil && *envFlag != "" {
-		envName = *envFlag
-	}
-
-	// Fall back to environment variables if flags not set
-	if levelStr == "" {
-		levelStr = getEnv("LOG_LEVEL", "info")
+	maxSizeStr := flagOrEnv(logMaxSizeFlag, "LOG_MAX_SIZE", "0")
+	maxSize, err := strconv.ParseInt(maxSizeStr, 10, 64)
+	if err != nil || maxSize < 0 {
+		return nil, fmt.Errorf("invalid log max size %q", maxSizeStr)
 	}
-	if webhookURL == "" {
-		webhookURL = os.Getenv("LOG_WEBHOOK_URL")
+	maxAgeStr := flagOrEnv(logMaxAgeFlag, "LOG_MAX_AGE", "0")
+	maxAge, err := time.ParseDuration(maxAgeStr)
+	if err != nil || maxAge < 0 {
+		return nil, fmt.Errorf("invalid log max age %q", maxAgeStr)
 	}
-	if appName == "" {
-		appName = getEnv("APP_NAME", "synology-office-exporter")
+	maxBackupsStr := flagOrEnv(logMaxBackupsFlag, "LOG_MAX_BACKUPS", "0")
+	maxBackups, err := strconv.Atoi(maxBackupsStr)
+	if err != nil || maxBackups < 0 {
+		return nil, fmt.Errorf("invalid log max backups %q", maxBackupsStr)
 	}
-	if envName == "" {
-		envName = getEnv("ENV", "development")
+	webhookFormat, err := ParseWebhookFormat(flagOrEnv(webhookFormatFlag, "LOG_WEBHOOK_FORMAT", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook format: %w", err)
 	}
 
-	level := ParseLevel(strings.ToLower(levelStr))
-
 	return &Config{
-		Level:       level,
-		WebhookURL:  webhookURL,
-		AppName:     appName,
-		Environment: envName,
+		Level:         ParseLevel(strings.ToLower(levelStr)),
+		Format:        format,
+		Output:        flagOrEnv(logOutputFlag, "LOG_OUTPUT", OutputStderr),
+		MaxSize:       maxSize,
+		MaxAge:        maxAge,
+		MaxBackups:    maxBackups,
+		WebhookURL:    flagOrEnv(webhookURLFlag, "LOG_WEBHOOK_URL", ""),
+		WebhookFormat: webhookFormat,
+		WebhookLevel:  ParseLevel(strings.ToLower(flagOrEnv(webhookLevelFlag, "LOG_WEBHOOK_LEVEL", levelStr))),
+		AppName:       flagOrEnv(appNameFlag, "APP_NAME", "synology-office-exporter"),
+		Environment:   flagOrEnv(envFlag, "ENV", "development"),
 	}, nil
 }
 
+// flagOrEnv returns the value of the flag if it was set, else the environment variable key if it is set,
+// else defaultValue.
+func flagOrEnv(flagValue *string, key, defaultValue string) string {
+	if flagValue != nil && *flagValue != "" {
+		return *flagValue
+	}
+	return getEnv(key, defaultValue)
+}
+
 func getEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
@@ -72,7 +80,14 @@ type EnvVarHelp struct {
 func GetEnvVarsHelp() []EnvVarHelp {
 	return []EnvVarHelp{
 		{"LOG_LEVEL", "Log level (debug, info, warn, error)"},
+		{"LOG_FORMAT", "Log format (text, json)"},
+		{"LOG_OUTPUT", "Log destination: stderr, stdout or a file path"},
+		{"LOG_MAX_SIZE", "Rotate the log file when it exceeds this many bytes"},
+		{"LOG_MAX_AGE", "Rotate the log file after it has been written to for this long"},
+		{"LOG_MAX_BACKUPS", "Number of rotated log files to keep (0 keeps all)"},
 		{"LOG_WEBHOOK_URL", "Webhook URL for logging"},
+		{"LOG_WEBHOOK_FORMAT", "Webhook payload format (auto, slack, discord, teams, generic)"},
+		{"LOG_WEBHOOK_LEVEL", "Minimum level of logs sent to the webhook (default: LOG_LEVEL)"},
 		{"APP_NAME", "Application name"},
 		{"ENV", "Environment (development, staging, production)"},
 	}
@@ -3,9 +3,13 @@ package logger
 import (
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
+	"os"
 	"sync"
 	"time"
+
+	"github.com/isseis/go-synology-office-exporter/redact"
 )
 
 // Logger is the interface for application-wide logging.
@@ -17,39 +21,65 @@ type Logger interface {
 	FlushWebhook() error
 }
 
-// hybridLogger outputs to stdout in real-time and buffers logs for webhook.
+// hybridLogger writes logs to its output in real-time and buffers logs for webhook.
 type hybridLogger struct {
-	webhookBuffer []slog.Record
-	mu            sync.Mutex
-	minLevel      Level
-	webhookURL    string
-	appName       string
-	env           string
+	webhookBuffer   []slog.Record
+	mu              sync.Mutex
+	minLevel        Level
+	webhookMinLevel Level
+	handler         slog.Handler
+	output          io.Closer      // Log file to close; nil for stdout and stderr
+	webhook         *webhookSender // Nil if no webhook URL is configured
 }
 
-// NewHybridLogger creates a new hybrid logger.
-func NewHybridLogger(cfg Config) Logger {
-	return &hybridLogger{
-		minLevel:   cfg.Level,
-		webhookURL: cfg.WebhookURL,
-		appName:    cfg.AppName,
-		env:        cfg.Environment,
+// NewHybridLogger creates a new hybrid logger writing to the output selected by cfg.
+// If the output is a file, the returned logger implements io.Closer; close it before exiting.
+func NewHybridLogger(cfg Config) (Logger, error) {
+	h := &hybridLogger{
+		minLevel:        cfg.Level,
+		webhookMinLevel: cfg.WebhookLevel,
 	}
-}
-
-// sendToWebhook is a stub for now to allow build. Implement as needed.
-func sendToWebhook(webhookURL, appName, env string, logs []slog.Record) error {
-	return fmt.Errorf("sendToWebhook not implemented yet")
+	var w io.Writer
+	switch cfg.Output {
+	case "", OutputStderr:
+		w = os.Stderr
+	case OutputStdout:
+		w = os.Stdout
+	default:
+		file, err := openRotatingFile(cfg.Output, cfg.MaxSize, cfg.MaxAge, cfg.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		w, h.output = file, file
+	}
+	// The level is filtered by log, so the handler passes every record it is given.
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	switch cfg.Format {
+	case "", FormatText:
+		h.handler = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h.handler = slog.NewJSONHandler(w, opts)
+	default:
+		if h.output != nil {
+			h.output.Close()
+		}
+		return nil, fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+	if cfg.WebhookURL != "" {
+		h.webhook = newWebhookSender(cfg.WebhookURL, cfg.WebhookFormat, cfg.AppName, cfg.Environment)
+	}
+	return h, nil
 }
 
 func (h *hybridLogger) log(level slog.Level, msg string, args ...interface{}) {
 	if levelFromSlog(level) < h.minLevel {
 		return
 	}
-	rec := slog.NewRecord(time.Now(), level, msg, 0)
-	rec.Add(args...)
-	_ = slog.Default().Handler().Handle(context.Background(), rec)
-	if h.webhookURL != "" {
+	rec := newRedactedRecord(time.Now(), level, msg, args)
+	if err := h.handler.Handle(context.Background(), rec); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write log: %v\n", err)
+	}
+	if h.webhook != nil && levelFromSlog(level) >= h.webhookMinLevel {
 		h.mu.Lock()
 		defer h.mu.Unlock()
 		h.webhookBuffer = append(h.webhookBuffer, rec)
@@ -60,9 +90,11 @@ func (h *hybridLogger) Debug(msg string, args ...interface{}) { h.log(slog.Level
 func (h *hybridLogger) Info(msg string, args ...interface{})  { h.log(slog.LevelInfo, msg, args...) }
 func (h *hybridLogger) Warn(msg string, args ...interface{})  { h.log(slog.LevelWarn, msg, args...) }
 func (h *hybridLogger) Error(msg string, args ...interface{}) { h.log(slog.LevelError, msg, args...) }
+
+// FlushWebhook sends the buffered logs to the webhook and empties the buffer.
 func (h *hybridLogger) FlushWebhook() error {
 	h.mu.Lock()
-	if h.webhookURL == "" || len(h.webhookBuffer) == 0 {
+	if h.webhook == nil || len(h.webhookBuffer) == 0 {
 		h.mu.Unlock()
 		return nil
 	}
@@ -70,7 +102,55 @@ func (h *hybridLogger) FlushWebhook() error {
 	copy(logs, h.webhookBuffer)
 	h.webhookBuffer = h.webhookBuffer[:0]
 	h.mu.Unlock()
-	return sendToWebhook(h.webhookURL, h.appName, h.env, logs)
+	return h.webhook.send(logs)
+}
+
+// Close closes the log file, if the output is one. Later logs are not written to it.
+func (h *hybridLogger) Close() error {
+	if h.output == nil {
+		return nil
+	}
+	return h.output.Close()
+}
+
+// newRedactedRecord returns a record of msg and the attributes in args with secrets redacted, so that neither
+// the handler nor the webhook ever sees them.
+func newRedactedRecord(t time.Time, level slog.Level, msg string, args []interface{}) slog.Record {
+	parsed := slog.NewRecord(t, level, msg, 0)
+	parsed.Add(args...)
+	rec := slog.NewRecord(t, level, redact.String(msg), 0)
+	parsed.Attrs(func(a slog.Attr) bool {
+		rec.AddAttrs(redactAttr(a))
+		return true
+	})
+	return rec
+}
+
+// redactAttr returns a with its value replaced by redact.Placeholder if its key names a secret, or with
+// secrets in its value redacted.
+func redactAttr(a slog.Attr) slog.Attr {
+	if redact.IsSensitiveKey(a.Key) {
+		return slog.String(a.Key, redact.Placeholder)
+	}
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redact.String(v.String()))
+	case slog.KindGroup:
+		attrs := v.Group()
+		redacted := make([]slog.Attr, len(attrs))
+		for i, attr := range attrs {
+			redacted[i] = redactAttr(attr)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(redacted...)}
+	case slog.KindAny:
+		// Values such as errors and URLs are written as text; keep the value unless its text holds a secret.
+		text := fmt.Sprint(v.Any())
+		if redactedText := redact.String(text); redactedText != text {
+			return slog.String(a.Key, redactedText)
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
 }
 
 // levelFromSlog converts slog.Level to our Level type
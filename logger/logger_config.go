@@ -2,14 +2,23 @@ package logger
 
 import (
 	"flag"
+	"fmt"
+	"time"
 )
 
 // Command line flags
 var (
-	logLevelFlag   = flag.String("log-level", "", "Log level (debug, info, warn, error)")
-	webhookURLFlag = flag.String("webhook-url", "", "Webhook URL for logging")
-	appNameFlag    = flag.String("app-name", "", "Application name")
-	envFlag        = flag.String("env", "", "Environment (development, staging, production)")
+	logLevelFlag      = flag.String("log-level", "", "Log level (debug, info, warn, error)")
+	logFormatFlag     = flag.String("log-format", "", "Log format (text, json)")
+	logOutputFlag     = flag.String("log-output", "", "Log destination: stderr, stdout or a file path")
+	logMaxSizeFlag    = flag.String("log-max-size", "", "Rotate the log file when it exceeds this many bytes (0 disables)")
+	logMaxAgeFlag     = flag.String("log-max-age", "", "Rotate the log file after it has been written to for this long, e.g. 24h (0 disables)")
+	logMaxBackupsFlag = flag.String("log-max-backups", "", "Number of rotated log files to keep (0 keeps all)")
+	webhookURLFlag    = flag.String("webhook-url", "", "Webhook URL for logging")
+	webhookFormatFlag = flag.String("webhook-format", "", "Webhook payload format (auto, slack, discord, teams, generic)")
+	webhookLevelFlag  = flag.String("webhook-level", "", "Minimum level of logs sent to the webhook (debug, info, warn, error; default: -log-level)")
+	appNameFlag       = flag.String("app-name", "", "Application name")
+	envFlag           = flag.String("env", "", "Environment (development, staging, production)")
 )
 
 // RegisterFlags is a no-op function kept for backward compatibility.
@@ -31,12 +40,47 @@ const (
 	LevelError
 )
 
+// Format selects the handler that formats log records.
+type Format string
+
+const (
+	// FormatText writes records as key=value pairs, one per line.
+	FormatText Format = "text"
+	// FormatJSON writes records as JSON objects, one per line.
+	FormatJSON Format = "json"
+)
+
+// Values of Config.Output that select a standard stream instead of a file.
+const (
+	OutputStderr = "stderr"
+	OutputStdout = "stdout"
+)
+
 // Config holds configuration for the logger.
 type Config struct {
-	Level       Level  // Log level
-	WebhookURL  string // Webhook URL for sending logs
-	AppName     string // Application name
-	Environment string // Environment (development, staging, production)
+	Level         Level         // Log level
+	Format        Format        // Log format; text if empty
+	Output        string        // OutputStderr, OutputStdout or a file path; stderr if empty
+	MaxSize       int64         // Rotate the log file when it would exceed this many bytes; 0 disables
+	MaxAge        time.Duration // Rotate the log file after it has been written to for this long; 0 disables
+	MaxBackups    int           // Number of rotated log files to keep; 0 keeps all
+	WebhookURL    string        // Webhook URL for sending logs
+	WebhookFormat WebhookFormat // Webhook payload format; detected from WebhookURL if empty
+	WebhookLevel  Level         // Minimum level of logs sent to the webhook, in addition to Level
+	AppName       string        // Application name
+	Environment   string        // Environment (development, staging, production)
+}
+
+// ParseFormat parses a log format name; "" selects FormatText.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case "", FormatText:
+		return FormatText, nil
+	case FormatJSON:
+		return FormatJSON, nil
+	default:
+		return "", fmt.Errorf("unknown log format %q (valid: text, json)", s)
+	}
 }
 
 // ParseLevel parses a string into a Level (defaults to LevelInfo).
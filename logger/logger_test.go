@@ -1,7 +1,11 @@
 package logger
 
 import (
+	"errors"
+	"io"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -46,3 +50,38 @@ func TestLoadConfig_FromEnv(t *testing.T) {
 		t.Errorf("unexpected env: %v", cfg.Environment)
 	}
 }
+
+func TestHybridLoggerRedactsSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.log")
+	srv := newWebhookServer(t)
+	log, err := NewHybridLogger(Config{Level: LevelInfo, Format: FormatJSON, Output: path, WebhookURL: srv.URL, WebhookFormat: WebhookFormatGeneric})
+	if err != nil {
+		t.Fatalf("NewHybridLogger: %v", err)
+	}
+	loginURL, _ := url.Parse("https://nas:5001/webapi/entry.cgi?api=SYNO.API.Auth&passwd=s3cret")
+	log.Error("Login failed for entry.cgi?passwd=s3cret",
+		"error", errors.New(`Get "https://nas:5001/webapi/entry.cgi?_sid=sid123": EOF`),
+		"url", loginURL,
+		"password", "s3cret",
+		"request", []string{"Cookie: id=sid123"},
+		"count", 3,
+	)
+	if err := log.FlushWebhook(); err != nil {
+		t.Fatalf("FlushWebhook: %v", err)
+	}
+	if err := log.(io.Closer).Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	outputs := map[string]string{"log file": readFile(t, path), "webhook": strings.Join(srv.posted(), "\n")}
+	for name, out := range outputs {
+		for _, secret := range []string{"s3cret", "sid123"} {
+			if strings.Contains(out, secret) {
+				t.Errorf("%s contains %q: %s", name, secret, out)
+			}
+		}
+		if !strings.Contains(out, `"count":3`) {
+			t.Errorf("%s lost attributes without secrets: %s", name, out)
+		}
+	}
+}
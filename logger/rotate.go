@@ -0,0 +1,195 @@
+package logger
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the timestamp appended to the names of rotated log files. It sorts in time order.
+const backupTimeFormat = "20060102T150405.000"
+
+// rotatingFile is a log file that is renamed and replaced by an empty file when it grows past maxSize bytes,
+// or when it has been written to for longer than maxAge. Only the newest maxBackups rotated files are kept.
+// A zero limit disables it. It is safe for concurrent use.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	now        func() time.Time
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time // When the current file was opened; its age is measured from here
+}
+
+// openRotatingFile opens the log file at path for appending, creating it if needed.
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups, now: time.Now}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open opens the log file, appending to an existing one.
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+	r.file = f
+	r.size = info.Size()
+	r.opened = r.now()
+	return nil
+}
+
+// Write appends p to the log file, rotating it first if p would take it past the size limit or it is too old.
+// A record larger than the size limit is still written, to a new file of its own.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	if r.needsRotation(int64(len(p))) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// needsRotation reports whether the file must be rotated before writing n more bytes.
+func (r *rotatingFile) needsRotation(n int64) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.maxSize > 0 && r.size+n > r.maxSize {
+		return true
+	}
+	return r.maxAge > 0 && r.now().Sub(r.opened) >= r.maxAge
+}
+
+// rotate renames the current file with a timestamp suffix, opens a new one and removes excess backups.
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+	r.file = nil
+	backup, err := r.backupName(r.now())
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(r.path, backup); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	return r.removeOldBackups()
+}
+
+// backupName returns an unused name for a file rotated at t. Files rotated within the same millisecond get a
+// counter suffix, as in export.log.20240507T020000.000.1, so that an earlier backup is never overwritten.
+func (r *rotatingFile) backupName(t time.Time) (string, error) {
+	name := r.path + "." + t.UTC().Format(backupTimeFormat)
+	for n := 0; ; n++ {
+		backup := name
+		if n > 0 {
+			backup += "." + strconv.Itoa(n)
+		}
+		if _, err := os.Lstat(backup); os.IsNotExist(err) {
+			return backup, nil
+		} else if err != nil {
+			return "", fmt.Errorf("failed to rotate log file: %w", err)
+		}
+	}
+}
+
+// removeOldBackups removes rotated files beyond the newest maxBackups.
+func (r *rotatingFile) removeOldBackups() error {
+	if r.maxBackups <= 0 {
+		return nil
+	}
+	backups, err := r.backups()
+	if err != nil {
+		return err
+	}
+	for len(backups) > r.maxBackups {
+		if err := os.Remove(backups[0]); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove old log file: %w", err)
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// backups returns the paths of the rotated files, oldest first.
+func (r *rotatingFile) backups() ([]string, error) {
+	dir, base := filepath.Split(r.path)
+	entries, err := os.ReadDir(filepath.Clean(dir + "."))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list log files: %w", err)
+	}
+	type backup struct {
+		path      string
+		timestamp string
+		n         int
+	}
+	var found []backup
+	for _, e := range entries {
+		suffix, ok := strings.CutPrefix(e.Name(), base+".")
+		if !ok || e.IsDir() || len(suffix) < len(backupTimeFormat) {
+			continue
+		}
+		timestamp, counter := suffix[:len(backupTimeFormat)], suffix[len(backupTimeFormat):]
+		if _, err := time.Parse(backupTimeFormat, timestamp); err != nil {
+			continue
+		}
+		n := 0
+		if counter != "" {
+			digits, ok := strings.CutPrefix(counter, ".")
+			if n, err = strconv.Atoi(digits); !ok || err != nil || n < 1 {
+				continue
+			}
+		}
+		found = append(found, backup{filepath.Join(dir, e.Name()), timestamp, n})
+	}
+	// Timestamps sort in time order as strings; files rotated within the same millisecond by their counter.
+	slices.SortFunc(found, func(a, b backup) int {
+		return cmp.Or(strings.Compare(a.timestamp, b.timestamp), cmp.Compare(a.n, b.n))
+	})
+	backups := make([]string, len(found))
+	for i, b := range found {
+		backups[i] = b.path
+	}
+	return backups, nil
+}
+
+// Close closes the log file. Later writes fail.
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
@@ -0,0 +1,208 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readFile returns the content of path, failing the test if it cannot be read.
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(%s): %v", path, err)
+	}
+	return string(data)
+}
+
+func TestRotatingFileSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.log")
+	if err := os.WriteFile(path, []byte("old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := openRotatingFile(path, 10, 0, 2)
+	if err != nil {
+		t.Fatalf("openRotatingFile: %v", err)
+	}
+	defer r.Close()
+	clock := time.Date(2024, 5, 7, 2, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { clock = clock.Add(time.Second); return clock }
+
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "a line longer than the limit\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	// The existing file is appended to, and the oldest backups are removed.
+	backups, err := r.backups()
+	if err != nil {
+		t.Fatalf("backups: %v", err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 backups, got %v", backups)
+	}
+	if got := readFile(t, backups[0]); got != "bbbb\ncccc\n" {
+		t.Errorf("unexpected older backup: %q", got)
+	}
+	if got := readFile(t, backups[1]); got != "dddd\n" {
+		t.Errorf("unexpected newer backup: %q", got)
+	}
+	if got := readFile(t, path); got != "a line longer than the limit\n" {
+		t.Errorf("unexpected current file: %q", got)
+	}
+}
+
+func TestRotatingFileSameMillisecond(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.log")
+	r, err := openRotatingFile(path, 5, 0, 10)
+	if err != nil {
+		t.Fatalf("openRotatingFile: %v", err)
+	}
+	defer r.Close()
+	clock := time.Date(2024, 5, 7, 2, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return clock }
+
+	// Every record fills a file, so each write rotates within the same millisecond.
+	for i := range 12 {
+		if _, err := fmt.Fprintf(r, "r%02d\n", i); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	backups, err := r.backups()
+	if err != nil {
+		t.Fatalf("backups: %v", err)
+	}
+	if len(backups) != 10 {
+		t.Fatalf("expected 10 backups, got %v", backups)
+	}
+	// No backup is overwritten, and the oldest is removed first.
+	for i, backup := range backups {
+		if got, want := readFile(t, backup), fmt.Sprintf("r%02d\n", i+1); got != want {
+			t.Errorf("backup %s: got %q, want %q", backup, got, want)
+		}
+	}
+	if want := path + ".20240507T020000.000.10"; backups[9] != want {
+		t.Errorf("unexpected newest backup %s, want %s", backups[9], want)
+	}
+	if got := readFile(t, path); got != "r11\n" {
+		t.Errorf("unexpected current file: %q", got)
+	}
+}
+
+func TestRotatingFileAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "export.log")
+	clock := time.Date(2024, 5, 7, 2, 0, 0, 0, time.UTC)
+	r, err := openRotatingFile(path, 0, time.Hour, 0)
+	if err != nil {
+		t.Fatalf("openRotatingFile: %v", err)
+	}
+	defer r.Close()
+	r.now = func() time.Time { return clock }
+	r.opened = clock
+
+	write := func(s string) {
+		t.Helper()
+		if _, err := r.Write([]byte(s)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	write("first\n")
+	clock = clock.Add(59 * time.Minute)
+	write("second\n")
+	clock = clock.Add(time.Minute)
+	write("third\n")
+
+	if got := readFile(t, path+".20240507T030000.000"); got != "first\nsecond\n" {
+		t.Errorf("unexpected backup: %q", got)
+	}
+	if got := readFile(t, path); got != "third\n" {
+		t.Errorf("unexpected current file: %q", got)
+	}
+
+	// Unrelated files next to the log are neither counted nor removed as backups.
+	if err := os.WriteFile(path+".bak", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := r.backups()
+	if err != nil || len(backups) != 1 {
+		t.Errorf("unexpected backups %v: %v", backups, err)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := r.Write([]byte("closed\n")); err == nil {
+		t.Error("expected error writing to a closed file")
+	}
+}
+
+func TestNewHybridLoggerOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.log")
+	log, err := NewHybridLogger(Config{Level: LevelDebug, Format: FormatJSON, Output: path})
+	if err != nil {
+		t.Fatalf("NewHybridLogger: %v", err)
+	}
+	log.Debug("debug message", "source", "mydrive")
+	log.Info("info message")
+	if err := log.(interface{ Close() error }).Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(readFile(t, path)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", lines)
+	}
+	var rec map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("invalid JSON line %q: %v", lines[0], err)
+	}
+	// Debug records pass when the configured level allows them.
+	if rec["level"] != "DEBUG" || rec["msg"] != "debug message" || rec["source"] != "mydrive" {
+		t.Errorf("unexpected record: %v", rec)
+	}
+
+	if _, err := NewHybridLogger(Config{Format: "xml"}); err == nil {
+		t.Error("expected error for unknown format")
+	}
+	if _, err := NewHybridLogger(Config{Output: filepath.Join(t.TempDir(), "missing", "export.log")}); err == nil {
+		t.Error("expected error for unwritable output")
+	}
+}
+
+func TestLoadConfig_Output(t *testing.T) {
+	t.Setenv("LOG_FORMAT", "JSON")
+	t.Setenv("LOG_OUTPUT", "/var/log/exporter.log")
+	t.Setenv("LOG_MAX_SIZE", "1048576")
+	t.Setenv("LOG_MAX_AGE", "24h")
+	t.Setenv("LOG_MAX_BACKUPS", "7")
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Format != FormatJSON || cfg.Output != "/var/log/exporter.log" || cfg.MaxSize != 1048576 ||
+		cfg.MaxAge != 24*time.Hour || cfg.MaxBackups != 7 {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+
+	for key, value := range map[string]string{
+		"LOG_FORMAT":      "xml",
+		"LOG_MAX_SIZE":    "-1",
+		"LOG_MAX_AGE":     "daily",
+		"LOG_MAX_BACKUPS": "many",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			if _, err := LoadConfig(); err == nil {
+				t.Errorf("expected error for %s=%s", key, value)
+			}
+		})
+	}
+}
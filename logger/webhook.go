@@ -0,0 +1,324 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// WebhookFormat selects the payload format of the webhook.
+type WebhookFormat string
+
+const (
+	// WebhookFormatAuto detects the format from the webhook URL, falling back to WebhookFormatGeneric.
+	WebhookFormatAuto WebhookFormat = ""
+	// WebhookFormatSlack posts messages to a Slack incoming webhook.
+	WebhookFormatSlack WebhookFormat = "slack"
+	// WebhookFormatDiscord posts messages to a Discord webhook.
+	WebhookFormatDiscord WebhookFormat = "discord"
+	// WebhookFormatTeams posts Adaptive Cards to a Microsoft Teams webhook.
+	WebhookFormatTeams WebhookFormat = "teams"
+	// WebhookFormatGeneric posts the records as a JSON document.
+	WebhookFormatGeneric WebhookFormat = "generic"
+)
+
+// ParseWebhookFormat parses a webhook format name; "" and "auto" select WebhookFormatAuto.
+func ParseWebhookFormat(s string) (WebhookFormat, error) {
+	switch f := WebhookFormat(strings.ToLower(s)); f {
+	case WebhookFormatAuto, "auto":
+		return WebhookFormatAuto, nil
+	case WebhookFormatSlack, WebhookFormatDiscord, WebhookFormatTeams, WebhookFormatGeneric:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown webhook format %q (valid: auto, slack, discord, teams, generic)", s)
+	}
+}
+
+// detectWebhookFormat returns the format of the service the webhook URL belongs to.
+func detectWebhookFormat(url string) WebhookFormat {
+	switch {
+	case strings.Contains(url, "hooks.slack.com/"):
+		return WebhookFormatSlack
+	case strings.Contains(url, "discord.com/api/webhooks/"), strings.Contains(url, "discordapp.com/api/webhooks/"):
+		return WebhookFormatDiscord
+	case strings.Contains(url, ".webhook.office.com/"), strings.Contains(url, ".logic.azure.com"):
+		return WebhookFormatTeams
+	default:
+		return WebhookFormatGeneric
+	}
+}
+
+// Limits of a single webhook message. Text formats are limited in characters of the message text,
+// the generic format in records per payload.
+const (
+	slackMaxText           = 3900  // Slack truncates long messages; stay below the 4000 it displays in full
+	discordMaxContent      = 2000  // Discord rejects longer message content
+	teamsMaxText           = 20000 // Teams rejects payloads over about 28 KB
+	genericMaxRecords      = 100
+	defaultWebhookAttempts = 3
+	defaultWebhookBackoff  = time.Second
+	maxWebhookRetryAfter   = 30 * time.Second
+	webhookRequestTimeout  = 10 * time.Second
+)
+
+// webhookSender posts buffered log records to a webhook, split into messages the service accepts.
+type webhookSender struct {
+	url         string
+	format      WebhookFormat
+	appName     string
+	env         string
+	client      *http.Client
+	maxAttempts int                 // Attempts per message, including the first
+	backoff     time.Duration       // Wait before the first retry, doubled on each further retry
+	sleep       func(time.Duration) // Replaced in tests
+}
+
+// newWebhookSender returns a sender posting to url in format, detecting the format from the URL if it is
+// WebhookFormatAuto.
+func newWebhookSender(url string, format WebhookFormat, appName, env string) *webhookSender {
+	if format == WebhookFormatAuto {
+		format = detectWebhookFormat(url)
+	}
+	return &webhookSender{
+		url:         url,
+		format:      format,
+		appName:     appName,
+		env:         env,
+		client:      &http.Client{Timeout: webhookRequestTimeout},
+		maxAttempts: defaultWebhookAttempts,
+		backoff:     defaultWebhookBackoff,
+		sleep:       time.Sleep,
+	}
+}
+
+// send posts logs in as few messages as the limits of the format allow.
+// A message that still fails after retrying does not stop the remaining ones; all failures are returned.
+func (w *webhookSender) send(logs []slog.Record) error {
+	payloads, err := w.payloads(logs)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for i, payload := range payloads {
+		if err := w.post(payload); err != nil {
+			errs = append(errs, fmt.Errorf("webhook message %d of %d: %w", i+1, len(payloads), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// payloads returns the JSON bodies of the messages carrying logs.
+func (w *webhookSender) payloads(logs []slog.Record) ([][]byte, error) {
+	var payloads []any
+	switch w.format {
+	case WebhookFormatSlack:
+		for _, text := range splitLines(formatRecords(logs), slackMaxText-len(w.title())-1) {
+			payloads = append(payloads, map[string]string{"text": w.title() + "\n" + text})
+		}
+	case WebhookFormatDiscord:
+		for _, text := range splitLines(formatRecords(logs), discordMaxContent-len(w.title())-1) {
+			payloads = append(payloads, map[string]string{"username": w.appName, "content": w.title() + "\n" + text})
+		}
+	case WebhookFormatTeams:
+		for _, text := range splitLines(formatRecords(logs), teamsMaxText) {
+			payloads = append(payloads, teamsCard(w.title(), text))
+		}
+	default:
+		for start := 0; start < len(logs); start += genericMaxRecords {
+			end := min(start+genericMaxRecords, len(logs))
+			payloads = append(payloads, w.genericPayload(logs[start:end]))
+		}
+	}
+
+	bodies := make([][]byte, 0, len(payloads))
+	for _, p := range payloads {
+		body, err := json.Marshal(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode webhook payload: %w", err)
+		}
+		bodies = append(bodies, body)
+	}
+	return bodies, nil
+}
+
+// title returns the heading of text messages, which names the application and environment.
+func (w *webhookSender) title() string {
+	return fmt.Sprintf("%s (%s)", w.appName, w.env)
+}
+
+// teamsCard returns a Teams message with an Adaptive Card showing text under title.
+func teamsCard(title, text string) map[string]any {
+	return map[string]any{
+		"type": "message",
+		"attachments": []any{map[string]any{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]any{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body": []any{
+					map[string]any{"type": "TextBlock", "text": title, "weight": "Bolder", "size": "Medium"},
+					map[string]any{"type": "TextBlock", "text": text, "wrap": true, "fontType": "Monospace"},
+				},
+			},
+		}},
+	}
+}
+
+// genericRecord is one log record in the generic JSON format.
+type genericRecord struct {
+	Time    time.Time      `json:"time"`
+	Level   string         `json:"level"`
+	Message string         `json:"message"`
+	Attrs   map[string]any `json:"attrs,omitempty"`
+}
+
+// genericPayload returns the generic JSON document carrying logs.
+func (w *webhookSender) genericPayload(logs []slog.Record) map[string]any {
+	records := make([]genericRecord, 0, len(logs))
+	for _, r := range logs {
+		rec := genericRecord{Time: r.Time, Level: r.Level.String(), Message: r.Message}
+		r.Attrs(func(a slog.Attr) bool {
+			if rec.Attrs == nil {
+				rec.Attrs = make(map[string]any)
+			}
+			rec.Attrs[a.Key] = attrValue(a.Value)
+			return true
+		})
+		records = append(records, rec)
+	}
+	return map[string]any{"app_name": w.appName, "environment": w.env, "logs": records}
+}
+
+// attrValue converts v to a value that encodes to readable JSON.
+func attrValue(v slog.Value) any {
+	v = v.Resolve()
+	switch v.Kind() {
+	case slog.KindGroup:
+		group := make(map[string]any)
+		for _, a := range v.Group() {
+			group[a.Key] = attrValue(a.Value)
+		}
+		return group
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return err.Error()
+		}
+		if _, err := json.Marshal(v.Any()); err != nil {
+			return v.String()
+		}
+		return v.Any()
+	default:
+		return v.Any()
+	}
+}
+
+// formatRecords formats each record as one line of text: time, level, message and attributes.
+func formatRecords(logs []slog.Record) []string {
+	lines := make([]string, 0, len(logs))
+	for _, r := range logs {
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s %s %s", r.Time.Format(time.RFC3339), r.Level, r.Message)
+		r.Attrs(func(a slog.Attr) bool {
+			fmt.Fprintf(&b, " %s=%v", a.Key, a.Value.Resolve())
+			return true
+		})
+		lines = append(lines, b.String())
+	}
+	return lines
+}
+
+// splitLines joins lines into texts of at most limit bytes, breaking only between lines.
+// A line longer than limit is truncated.
+func splitLines(lines []string, limit int) []string {
+	var texts []string
+	var b strings.Builder
+	for _, line := range lines {
+		line = truncate(line, limit)
+		if b.Len() > 0 && b.Len()+1+len(line) > limit {
+			texts = append(texts, b.String())
+			b.Reset()
+		}
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(line)
+	}
+	if b.Len() > 0 {
+		texts = append(texts, b.String())
+	}
+	return texts
+}
+
+// truncate shortens s to at most limit bytes without splitting a UTF-8 sequence, marking the cut with an ellipsis.
+func truncate(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	const ellipsis = "…"
+	cut := max(limit-len(ellipsis), 0)
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + ellipsis
+}
+
+// webhookStatusError is returned when the webhook responds with an unsuccessful status.
+type webhookStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *webhookStatusError) Error() string {
+	return fmt.Sprintf("webhook returned %d: %s", e.StatusCode, e.Body)
+}
+
+// post sends one message, retrying with exponential backoff on network errors, rate limiting and server errors.
+// A Retry-After header replaces the backoff, up to maxWebhookRetryAfter.
+func (w *webhookSender) post(body []byte) error {
+	delay := w.backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retryAfter time.Duration
+		var retry bool
+		retryAfter, retry, err = w.postOnce(body)
+		if err == nil || !retry || attempt >= w.maxAttempts {
+			return err
+		}
+		wait := delay
+		if retryAfter > 0 {
+			wait = min(retryAfter, maxWebhookRetryAfter)
+		}
+		w.sleep(wait)
+		delay *= 2
+	}
+}
+
+// postOnce sends one request. It returns the wait requested by the server, and whether a failure is worth retrying.
+func (w *webhookSender) postOnce(body []byte) (time.Duration, bool, error) {
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return 0, false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = &webhookStatusError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(msg))}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	var retryAfter time.Duration
+	if seconds, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil && seconds > 0 {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	return retryAfter, retry, err
+}
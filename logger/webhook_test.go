@@ -0,0 +1,275 @@
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookServer records the bodies posted to it and responds with the queued status codes, then 200.
+type webhookServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	bodies   []string
+	statuses []int
+}
+
+func newWebhookServer(t *testing.T, statuses ...int) *webhookServer {
+	t.Helper()
+	s := &webhookServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.bodies = append(s.bodies, string(body))
+		if len(s.statuses) > 0 {
+			code := s.statuses[0]
+			s.statuses = s.statuses[1:]
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(code)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *webhookServer) posted() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.bodies...)
+}
+
+// testSender returns a sender for url that records its waits instead of sleeping.
+func testSender(url string, format WebhookFormat, waits *[]time.Duration) *webhookSender {
+	w := newWebhookSender(url, format, "test-app", "staging")
+	w.sleep = func(d time.Duration) { *waits = append(*waits, d) }
+	return w
+}
+
+func testRecords(n int, msg string) []slog.Record {
+	logs := make([]slog.Record, n)
+	for i := range logs {
+		logs[i] = slog.NewRecord(time.Date(2024, 5, 7, 2, 0, 0, 0, time.UTC), slog.LevelWarn, msg, 0)
+		logs[i].Add("count", i, "error", errors.New("boom"))
+	}
+	return logs
+}
+
+func TestParseWebhookFormat(t *testing.T) {
+	for in, want := range map[string]WebhookFormat{
+		"":        WebhookFormatAuto,
+		"auto":    WebhookFormatAuto,
+		"Slack":   WebhookFormatSlack,
+		"discord": WebhookFormatDiscord,
+		"teams":   WebhookFormatTeams,
+		"generic": WebhookFormatGeneric,
+	} {
+		got, err := ParseWebhookFormat(in)
+		if err != nil || got != want {
+			t.Errorf("ParseWebhookFormat(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseWebhookFormat("irc"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestDetectWebhookFormat(t *testing.T) {
+	for url, want := range map[string]WebhookFormat{
+		"https://hooks.slack.com/services/T0/B0/XYZ":                  WebhookFormatSlack,
+		"https://discord.com/api/webhooks/1/abc":                      WebhookFormatDiscord,
+		"https://example.webhook.office.com/webhookb2/abc":            WebhookFormatTeams,
+		"https://prod-01.westus.logic.azure.com:443/workflows/abc":    WebhookFormatTeams,
+		"https://logs.example.com/ingest":                             WebhookFormatGeneric,
+		"http://127.0.0.1:8080/api/webhooks/discord.com/api/webhooks": WebhookFormatGeneric,
+	} {
+		if got := detectWebhookFormat(url); got != want {
+			t.Errorf("detectWebhookFormat(%q) = %q, want %q", url, got, want)
+		}
+	}
+}
+
+func TestWebhookSenderFormats(t *testing.T) {
+	logs := testRecords(2, "export failed")
+	for _, tc := range []struct {
+		format WebhookFormat
+		check  func(t *testing.T, payload map[string]any)
+	}{
+		{WebhookFormatSlack, func(t *testing.T, p map[string]any) {
+			text, _ := p["text"].(string)
+			if !strings.HasPrefix(text, "test-app (staging)\n") || !strings.Contains(text, "WARN export failed count=1 error=boom") {
+				t.Errorf("unexpected Slack text: %q", text)
+			}
+		}},
+		{WebhookFormatDiscord, func(t *testing.T, p map[string]any) {
+			if p["username"] != "test-app" || !strings.Contains(p["content"].(string), "test-app (staging)") {
+				t.Errorf("unexpected Discord payload: %v", p)
+			}
+		}},
+		{WebhookFormatTeams, func(t *testing.T, p map[string]any) {
+			card := p["attachments"].([]any)[0].(map[string]any)["content"].(map[string]any)
+			body := card["body"].([]any)
+			if card["type"] != "AdaptiveCard" || body[0].(map[string]any)["text"] != "test-app (staging)" ||
+				!strings.Contains(body[1].(map[string]any)["text"].(string), "export failed") {
+				t.Errorf("unexpected Teams card: %v", card)
+			}
+		}},
+		{WebhookFormatGeneric, func(t *testing.T, p map[string]any) {
+			records := p["logs"].([]any)
+			first := records[0].(map[string]any)
+			attrs := first["attrs"].(map[string]any)
+			if p["app_name"] != "test-app" || p["environment"] != "staging" || len(records) != 2 ||
+				first["level"] != "WARN" || first["message"] != "export failed" || attrs["error"] != "boom" || attrs["count"] != 0.0 {
+				t.Errorf("unexpected generic payload: %v", p)
+			}
+		}},
+	} {
+		t.Run(string(tc.format), func(t *testing.T) {
+			srv := newWebhookServer(t)
+			var waits []time.Duration
+			if err := testSender(srv.URL, tc.format, &waits).send(logs); err != nil {
+				t.Fatalf("send: %v", err)
+			}
+			posted := srv.posted()
+			if len(posted) != 1 {
+				t.Fatalf("expected 1 message, got %d", len(posted))
+			}
+			var payload map[string]any
+			if err := json.Unmarshal([]byte(posted[0]), &payload); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+			tc.check(t, payload)
+		})
+	}
+}
+
+func TestWebhookSenderSplitsMessages(t *testing.T) {
+	srv := newWebhookServer(t)
+	var waits []time.Duration
+	logs := testRecords(30, strings.Repeat("x", 100))
+	if err := testSender(srv.URL, WebhookFormatDiscord, &waits).send(logs); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	posted := srv.posted()
+	if len(posted) < 2 {
+		t.Fatalf("expected the logs to be split, got %d message(s)", len(posted))
+	}
+	lines := 0
+	for _, body := range posted {
+		var p map[string]string
+		if err := json.Unmarshal([]byte(body), &p); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+		if n := len(p["content"]); n > discordMaxContent {
+			t.Errorf("message of %d bytes exceeds the Discord limit", n)
+		}
+		if !strings.HasPrefix(p["content"], "test-app (staging)\n") {
+			t.Errorf("message without title: %q", p["content"])
+		}
+		lines += strings.Count(p["content"], "\n")
+	}
+	if lines != len(logs) {
+		t.Errorf("expected %d log lines in total, got %d", len(logs), lines)
+	}
+
+	srv = newWebhookServer(t)
+	if err := testSender(srv.URL, WebhookFormatGeneric, &waits).send(testRecords(genericMaxRecords+1, "msg")); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if n := len(srv.posted()); n != 2 {
+		t.Errorf("expected 2 generic payloads, got %d", n)
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+	got := splitLines([]string{"aaaa", "bb", "cc", "ééééé"}, 6)
+	want := []string{"aaaa", "bb\ncc", "é…"} // The ellipsis takes 3 of the 6 bytes
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("splitLines = %q, want %q", got, want)
+	}
+}
+
+func TestWebhookSenderRetry(t *testing.T) {
+	t.Run("retries server errors with backoff", func(t *testing.T) {
+		srv := newWebhookServer(t, http.StatusInternalServerError, http.StatusTooManyRequests)
+		var waits []time.Duration
+		w := testSender(srv.URL, WebhookFormatSlack, &waits)
+		w.backoff = 100 * time.Millisecond
+		if err := w.send(testRecords(1, "msg")); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+		if n := len(srv.posted()); n != 3 {
+			t.Errorf("expected 3 attempts, got %d", n)
+		}
+		// Retry-After replaces the backoff.
+		if len(waits) != 2 || waits[0] != 2*time.Second || waits[1] != 2*time.Second {
+			t.Errorf("unexpected waits: %v", waits)
+		}
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		srv := newWebhookServer(t, 503, 503, 503, 503)
+		var waits []time.Duration
+		err := testSender(srv.URL, WebhookFormatSlack, &waits).send(testRecords(1, "msg"))
+		var statusErr *webhookStatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != 503 {
+			t.Errorf("expected status error 503, got %v", err)
+		}
+		if n := len(srv.posted()); n != defaultWebhookAttempts {
+			t.Errorf("expected %d attempts, got %d", defaultWebhookAttempts, n)
+		}
+	})
+
+	t.Run("does not retry client errors", func(t *testing.T) {
+		srv := newWebhookServer(t, http.StatusBadRequest)
+		var waits []time.Duration
+		if err := testSender(srv.URL, WebhookFormatSlack, &waits).send(testRecords(1, "msg")); err == nil {
+			t.Error("expected error")
+		}
+		if n := len(srv.posted()); n != 1 {
+			t.Errorf("expected 1 attempt, got %d", n)
+		}
+	})
+}
+
+func TestHybridLoggerFlushWebhook(t *testing.T) {
+	srv := newWebhookServer(t)
+	log, err := NewHybridLogger(Config{
+		Level:         LevelInfo,
+		WebhookURL:    srv.URL,
+		WebhookFormat: WebhookFormatGeneric,
+		WebhookLevel:  LevelWarn,
+		AppName:       "test-app",
+		Environment:   "staging",
+	})
+	if err != nil {
+		t.Fatalf("NewHybridLogger: %v", err)
+	}
+	log.Info("not sent")
+	log.Warn("sent", "source", "mydrive")
+	log.Error("also sent")
+	if err := log.FlushWebhook(); err != nil {
+		t.Fatalf("FlushWebhook: %v", err)
+	}
+	posted := srv.posted()
+	if len(posted) != 1 {
+		t.Fatalf("expected 1 payload, got %d", len(posted))
+	}
+	if strings.Contains(posted[0], "not sent") || !strings.Contains(posted[0], `"message":"also sent"`) {
+		t.Errorf("unexpected payload: %s", posted[0])
+	}
+
+	// The buffer is empty after flushing.
+	if err := log.FlushWebhook(); err != nil || len(srv.posted()) != 1 {
+		t.Errorf("second flush sent again: %v", err)
+	}
+}
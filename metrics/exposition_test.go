@@ -0,0 +1,204 @@
+package metrics
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// goldenRegistry returns a registry exercising the escaping, special values and histogram lines of the format.
+func goldenRegistry() *Registry {
+	r := NewRegistry()
+	calls := r.Counter("synology_api_calls_total", `API calls by "api" and result.`, "api", "result")
+	calls.Inc("SYNO.SynologyDrive.Files", "success")
+	calls.Add(2.5, "SYNO.SynologyDrive.Files", "error")
+	calls.Inc(`C:\Drive\"quoted"`, "line\nbreak")
+	calls.Inc("日本語", "")
+
+	values := r.Gauge("job:values", "Special and\nextreme values, with a \\ backslash.", "kind")
+	values.Set(math.Inf(1), "inf")
+	values.Set(math.Inf(-1), "neg_inf")
+	values.Set(math.NaN(), "nan")
+	values.Set(1e21, "large")
+	values.Set(1.5e-7, "small")
+	values.Set(12345678, "integer")
+	values.Set(-0.25, "negative")
+
+	r.Gauge("up", "Up.").Set(1)
+	r.Gauge("never_set", "Omitted until set.", "source")
+
+	unlabelled := r.Histogram("run_seconds", "Run duration.", []float64{1, 60, 3600})
+	for _, v := range []float64{0.5, 1, 59.9, 60, 7200} {
+		unlabelled.Observe(v)
+	}
+	latency := r.Histogram("call_seconds", "Call latency.", []float64{0.005, 0.25}, "api")
+	latency.Observe(0.001, "list")
+	latency.Observe(0.3, "list")
+	latency.Observe(0.25, "export")
+	return r
+}
+
+func TestWriteToGolden(t *testing.T) {
+	var b strings.Builder
+	_, err := goldenRegistry().WriteTo(&b)
+	require.NoError(t, err)
+	golden := filepath.Join("testdata", "exposition.txt")
+	if *update {
+		require.NoError(t, os.WriteFile(golden, []byte(b.String()), 0o644))
+	}
+	want, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), b.String())
+	checkExposition(t, b.String())
+}
+
+func TestRegisterRejectsInvalidNames(t *testing.T) {
+	r := NewRegistry()
+	assert.Panics(t, func() { r.Counter("1st_total", "Bad name.") })
+	assert.Panics(t, func() { r.Counter("calls-total", "Bad name.") })
+	assert.Panics(t, func() { r.Gauge("g", "Bad label.", "source-name") })
+	assert.Panics(t, func() { r.Gauge("g", "Reserved label.", "__name") })
+	assert.Panics(t, func() { r.Gauge("g", "Duplicate label.", "a", "a") })
+	assert.Panics(t, func() { r.Histogram("h", "Reserved label.", []float64{1}, "le") })
+	assert.Panics(t, func() { r.Histogram("h", "Duplicate bound.", []float64{1, 1}) })
+	assert.Panics(t, func() { r.Histogram("h", "Explicit +Inf.", []float64{1, math.Inf(1)}) })
+	assert.NotPanics(t, func() { r.Counter("ns:calls_total", "Colons are allowed.", "_private") })
+}
+
+// checkExposition checks text against the rules of the Prometheus text exposition format that a scraper enforces:
+// HELP and TYPE lines precede the samples of their family, each family appears once, names, labels and values are
+// well-formed, no sample is repeated, and histogram buckets are cumulative and end with +Inf matching _count.
+func checkExposition(t *testing.T, text string) {
+	t.Helper()
+	require.True(t, strings.HasSuffix(text, "\n"), "the output ends with a newline")
+	sampleRE := regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{.*\})? (\S+)$`)
+	helpRE := regexp.MustCompile(`^# HELP ([a-zA-Z_:][a-zA-Z0-9_:]*) (.*)$`)
+	typeRE := regexp.MustCompile(`^# TYPE ([a-zA-Z_:][a-zA-Z0-9_:]*) (counter|gauge|histogram)$`)
+
+	seenFamilies := map[string]bool{}
+	seenSamples := map[string]bool{}
+	var family, typ, helpFor string
+	type histogramSeries struct {
+		bounds, counts []float64
+		count          float64
+		hasSum         bool
+	}
+	histograms := map[string]*histogramSeries{}
+
+	for i, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		where := fmt.Sprintf("line %d %q", i+1, line)
+		if m := helpRE.FindStringSubmatch(line); m != nil {
+			require.False(t, seenFamilies[m[1]], "%s: family repeated", where)
+			require.NoError(t, checkEscapes(m[2], false), where)
+			helpFor = m[1]
+			continue
+		}
+		if m := typeRE.FindStringSubmatch(line); m != nil {
+			require.Equal(t, helpFor, m[1], "%s: TYPE follows the HELP of its family", where)
+			family, typ = m[1], m[2]
+			seenFamilies[family] = true
+			continue
+		}
+		m := sampleRE.FindStringSubmatch(line)
+		require.NotNil(t, m, "%s: not a HELP, TYPE or sample line", where)
+		name, rawLabels, rawValue := m[1], m[2], m[3]
+		require.NotEmpty(t, family, "%s: sample before any TYPE", where)
+		labels, err := parseLabels(rawLabels)
+		require.NoError(t, err, where)
+		value, err := strconv.ParseFloat(rawValue, 64)
+		require.NoError(t, err, where)
+		key := name + rawLabels
+		require.False(t, seenSamples[key], "%s: sample repeated", where)
+		seenSamples[key] = true
+
+		if typ != "histogram" {
+			require.Equal(t, family, name, "%s: sample of another family", where)
+			continue
+		}
+		le, hasLE := labels["le"]
+		delete(labels, "le")
+		series := fmt.Sprint(labels)
+		h := histograms[family+series]
+		if h == nil {
+			h = &histogramSeries{}
+			histograms[family+series] = h
+		}
+		switch name {
+		case family + "_bucket":
+			require.True(t, hasLE, "%s: bucket without le", where)
+			bound, err := strconv.ParseFloat(le, 64)
+			require.NoError(t, err, where)
+			if n := len(h.bounds); n > 0 {
+				require.Greater(t, bound, h.bounds[n-1], "%s: bucket bounds increase", where)
+				require.GreaterOrEqual(t, value, h.counts[n-1], "%s: buckets are cumulative", where)
+			}
+			h.bounds = append(h.bounds, bound)
+			h.counts = append(h.counts, value)
+		case family + "_sum":
+			h.hasSum = true
+		case family + "_count":
+			h.count = value
+		default:
+			t.Fatalf("%s: sample of another family", where)
+		}
+	}
+	for name, h := range histograms {
+		require.NotEmpty(t, h.bounds, "%s: histogram without buckets", name)
+		assert.True(t, math.IsInf(h.bounds[len(h.bounds)-1], 1), "%s: the last bucket is +Inf", name)
+		assert.Equal(t, h.counts[len(h.counts)-1], h.count, "%s: _count equals the +Inf bucket", name)
+		assert.True(t, h.hasSum, "%s: histogram without _sum", name)
+	}
+}
+
+// parseLabels parses the label set of a sample line, such as {a="x",b="y\"z"}, returning the unescaped values.
+func parseLabels(s string) (map[string]string, error) {
+	labels := map[string]string{}
+	if s == "" {
+		return labels, nil
+	}
+	pairRE := regexp.MustCompile(`^([a-zA-Z_][a-zA-Z0-9_]*)="((?:[^"\\]|\\.)*)"`)
+	rest := strings.TrimSuffix(strings.TrimPrefix(s, "{"), "}")
+	for rest != "" {
+		m := pairRE.FindStringSubmatch(rest)
+		if m == nil {
+			return nil, fmt.Errorf("malformed label pair at %q", rest)
+		}
+		if _, ok := labels[m[1]]; ok {
+			return nil, fmt.Errorf("label %s repeated", m[1])
+		}
+		if err := checkEscapes(m[2], true); err != nil {
+			return nil, err
+		}
+		labels[m[1]] = m[2]
+		rest = strings.TrimPrefix(rest[len(m[0]):], ",")
+	}
+	return labels, nil
+}
+
+// checkEscapes checks that s contains only the escape sequences allowed in HELP text or, if quoted is set,
+// in label values, and no raw line breaks.
+func checkEscapes(s string, quoted bool) error {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\n':
+			return fmt.Errorf("raw line break in %q", s)
+		case '\\':
+			i++
+			if i == len(s) || (s[i] != '\\' && s[i] != 'n' && (!quoted || s[i] != '"')) {
+				return fmt.Errorf("invalid escape in %q", s)
+			}
+		}
+	}
+	return nil
+}
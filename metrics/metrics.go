@@ -0,0 +1,336 @@
+// Package metrics collects counters, gauges and histograms and writes them in the Prometheus text exposition
+// format, either over HTTP for scraping or to a file for the node_exporter textfile collector.
+//
+// The format is written by hand rather than with the Prometheus client library, so that the exporter does not
+// depend on it and its transitive dependencies for a few metrics. The tests check the output against a golden
+// file and the rules of the format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the Prometheus text exposition format written by WriteTo.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the histogram bucket upper bounds, in seconds, suited to the latency of API calls.
+var DefaultBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// metricType is the type of a metric family as written in its TYPE line.
+type metricType string
+
+const (
+	typeCounter   metricType = "counter"
+	typeGauge     metricType = "gauge"
+	typeHistogram metricType = "histogram"
+)
+
+// Registry holds metric families and writes their current values.
+// It is safe for concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// family is a metric with a fixed set of label names, holding one series per combination of label values.
+type family struct {
+	name    string
+	help    string
+	typ     metricType
+	labels  []string
+	buckets []float64          // Upper bounds of histogram buckets, excluding +Inf
+	series  map[string]*series // Keyed by the joined label values
+}
+
+// series holds the value of one combination of label values.
+type series struct {
+	labelValues []string
+	value       float64  // Counter or gauge value, or histogram sum
+	count       uint64   // Histogram observation count
+	bucketCount []uint64 // Histogram observations per bucket (not cumulative)
+}
+
+// Valid metric and label names in the exposition format.
+var (
+	metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRE  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// register adds a family to the registry. It panics if the name is already registered or if the name or label names
+// are not valid in the exposition format, which are programming errors.
+func (r *Registry) register(f *family) *family {
+	if !metricNameRE.MatchString(f.name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", f.name))
+	}
+	for i, label := range f.labels {
+		switch {
+		case !labelNameRE.MatchString(label) || strings.HasPrefix(label, "__"):
+			panic(fmt.Sprintf("metrics: invalid label name %q of %s", label, f.name))
+		case slices.Contains(f.labels[:i], label):
+			panic(fmt.Sprintf("metrics: duplicate label %q of %s", label, f.name))
+		case f.typ == typeHistogram && label == "le":
+			panic("metrics: histogram " + f.name + " cannot have the label le")
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.families[f.name]; ok {
+		panic("metrics: duplicate metric " + f.name)
+	}
+	f.series = make(map[string]*series)
+	r.families[f.name] = f
+	return f
+}
+
+// get returns the series for the label values, creating it if needed. The caller must hold r.mu.
+// It panics if the number of label values does not match the label names, which is a programming error.
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: slices.Clone(labelValues)}
+		if f.typ == typeHistogram {
+			s.bucketCount = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Counter is a metric family whose values only go up.
+type Counter struct {
+	r *Registry
+	f *family
+}
+
+// Counter registers a counter with the given label names.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r: r, f: r.register(&family{name: name, help: help, typ: typeCounter, labels: labels})}
+}
+
+// Add adds v, which must not be negative, to the series with the given label values.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counter " + c.f.name + " cannot decrease")
+	}
+	c.r.mu.Lock()
+	defer c.r.mu.Unlock()
+	c.f.get(labelValues).value += v
+}
+
+// Inc adds one to the series with the given label values.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Gauge is a metric family whose values are set to the current state.
+type Gauge struct {
+	r *Registry
+	f *family
+}
+
+// Gauge registers a gauge with the given label names.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r: r, f: r.register(&family{name: name, help: help, typ: typeGauge, labels: labels})}
+}
+
+// Set sets the series with the given label values to v.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.r.mu.Lock()
+	defer g.r.mu.Unlock()
+	g.f.get(labelValues).value = v
+}
+
+// Histogram is a metric family counting observations in buckets, such as request latencies.
+type Histogram struct {
+	r *Registry
+	f *family
+}
+
+// Histogram registers a histogram with the given bucket upper bounds and label names. The bounds must be
+// increasing and finite; the +Inf bucket is always added.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	for i, bound := range buckets {
+		if math.IsInf(bound, 0) || math.IsNaN(bound) || (i > 0 && bound <= buckets[i-1]) {
+			panic("metrics: buckets of " + name + " are not increasing and finite")
+		}
+	}
+	return &Histogram{r: r, f: r.register(&family{name: name, help: help, typ: typeHistogram, labels: labels, buckets: buckets})}
+}
+
+// Observe adds the observation v to the series with the given label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.r.mu.Lock()
+	defer h.r.mu.Unlock()
+	s := h.f.get(labelValues)
+	s.value += v
+	s.count++
+	if i, _ := slices.BinarySearch(h.f.buckets, v); i < len(s.bucketCount) {
+		s.bucketCount[i]++
+	}
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format, sorted by name and label values.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		r.families[name].write(cw)
+	}
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+// write writes the HELP and TYPE lines and all series of the family.
+func (f *family) write(w *countingWriter) {
+	if len(f.series) == 0 {
+		return
+	}
+	w.printf("# HELP %s %s\n", f.name, escapeHelp(f.help))
+	w.printf("# TYPE %s %s\n", f.name, f.typ)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.typ != typeHistogram {
+			w.printf("%s%s %s\n", f.name, formatLabels(f.labels, s.labelValues, "", ""), formatValue(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, bound := range f.buckets {
+			cumulative += s.bucketCount[i]
+			w.printf("%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.labelValues, "le", formatValue(bound)), cumulative)
+		}
+		w.printf("%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.labelValues, "le", "+Inf"), s.count)
+		w.printf("%s_sum%s %s\n", f.name, formatLabels(f.labels, s.labelValues, "", ""), formatValue(s.value))
+		w.printf("%s_count%s %d\n", f.name, formatLabels(f.labels, s.labelValues, "", ""), s.count)
+	}
+}
+
+// formatLabels formats the label pairs of a series, with an extra label appended if extraName is not empty.
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", name, escapeLabelValue(values[i]))
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", extraName, extraValue)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// formatValue formats a sample value as Prometheus expects, including the special values.
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string       { return helpEscaper.Replace(s) }
+func escapeLabelValue(s string) string { return labelEscaper.Replace(s) }
+
+// countingWriter writes formatted output, remembering the number of bytes written and the first error.
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) printf(format string, args ...any) {
+	if c.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(c.w, format, args...)
+	c.n += int64(n)
+	c.err = err
+}
+
+// Handler returns an HTTP handler that serves the metrics for scraping.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		// A write error means the client has gone away; there is nobody left to report it to.
+		_, _ = r.WriteTo(w)
+	})
+}
+
+// WriteTextfile atomically replaces the file at path with the current metrics, for the node_exporter
+// textfile collector. The collector only reads files with the ".prom" extension.
+func (r *Registry) WriteTextfile(path string) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	tmpPath := file.Name()
+	defer os.Remove(tmpPath) // No-op once the file has been renamed
+
+	if _, err := r.WriteTo(file); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	r := NewRegistry()
+	files := r.Counter("files_total", "Files by result.", "source", "result")
+	size := r.Gauge("history_entries", "Entries in the\nhistory.", "source")
+	latency := r.Histogram("call_seconds", "Call latency.", []float64{0.1, 1}, "api")
+	r.Gauge("unused", "Never set.")
+
+	files.Inc("mydrive", "downloaded")
+	files.Add(2, "mydrive", "downloaded")
+	files.Inc("shared", `say "hi"`)
+	size.Set(42, "mydrive")
+	latency.Observe(0.05, "list")
+	latency.Observe(0.1, "list")
+	latency.Observe(3, "list")
+
+	var b strings.Builder
+	n, err := r.WriteTo(&b)
+	require.NoError(t, err)
+	assert.Equal(t, int64(b.Len()), n)
+	assert.Equal(t, `# HELP call_seconds Call latency.
+# TYPE call_seconds histogram
+call_seconds_bucket{api="list",le="0.1"} 2
+call_seconds_bucket{api="list",le="1"} 2
+call_seconds_bucket{api="list",le="+Inf"} 3
+call_seconds_sum{api="list"} 3.15
+call_seconds_count{api="list"} 3
+# HELP files_total Files by result.
+# TYPE files_total counter
+files_total{source="mydrive",result="downloaded"} 3
+files_total{source="shared",result="say \"hi\""} 1
+# HELP history_entries Entries in the\nhistory.
+# TYPE history_entries gauge
+history_entries{source="mydrive"} 42
+`, b.String())
+}
+
+func TestRegistryMisuse(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("c", "Counter.", "label")
+	assert.Panics(t, func() { r.Gauge("c", "Duplicate.") })
+	assert.Panics(t, func() { c.Inc() })
+	assert.Panics(t, func() { c.Add(-1, "x") })
+	assert.Panics(t, func() { r.Histogram("h", "Unsorted.", []float64{1, 0.5}) })
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Gauge("up", "Up.").Set(1)
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, ContentType, rec.Header().Get("Content-Type"))
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "up 1\n")
+}
+
+func TestWriteTextfile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "exporter.prom")
+	r := NewRegistry()
+	g := r.Gauge("up", "Up.")
+
+	g.Set(1)
+	require.NoError(t, r.WriteTextfile(path))
+	g.Set(0)
+	require.NoError(t, r.WriteTextfile(path))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "up 0\n")
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "temporary files are removed")
+
+	assert.Error(t, r.WriteTextfile(filepath.Join(dir, "missing", "exporter.prom")))
+}
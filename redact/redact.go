@@ -0,0 +1,94 @@
+// Package redact removes secrets from URLs, error messages and log values before they are written anywhere.
+//
+// It strips the values of the query parameters used by the Synology API for credentials and sessions
+// (passwd, _sid and otp_code), passwords in URL user info, and cookie values in Cookie and Set-Cookie headers.
+package redact
+
+import (
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Placeholder replaces redacted values.
+const Placeholder = "REDACTED"
+
+// sensitiveParams are the query parameters whose values are secret.
+var sensitiveParams = []string{"passwd", "_sid", "otp_code"}
+
+var (
+	// paramPattern matches a sensitive parameter and its value in a query string, form body or JSON object.
+	paramPattern = regexp.MustCompile(`(?i)\b(` + strings.Join(sensitiveParams, "|") + `)(=|"\s*:\s*")([^&\s"',;]*)`)
+	// userInfoPattern matches the password in the user info of a URL.
+	userInfoPattern = regexp.MustCompile(`(://[^/\s:@]*:)[^/\s@]*@`)
+	// cookiePattern matches a Cookie or Set-Cookie header up to the end of its line.
+	cookiePattern = regexp.MustCompile(`(?i)((?:set-)?cookie:\s*)([^\r\n]*)`)
+	// cookieValuePattern matches the value of one name=value pair in a cookie header.
+	cookieValuePattern = regexp.MustCompile(`(^|;\s*)([^=;\s]+)=([^;]*)`)
+)
+
+// String returns s with the values of sensitive parameters, URL passwords and cookies replaced by Placeholder.
+func String(s string) string {
+	s = paramPattern.ReplaceAllString(s, "${1}${2}"+Placeholder)
+	s = userInfoPattern.ReplaceAllString(s, "${1}"+Placeholder+"@")
+	return cookiePattern.ReplaceAllStringFunc(s, func(header string) string {
+		m := cookiePattern.FindStringSubmatch(header)
+		return m[1] + Cookie(m[2])
+	})
+}
+
+// Cookie returns the value of a Cookie or Set-Cookie header with the value of every cookie replaced by
+// Placeholder. Cookie attributes such as Path keep their values.
+func Cookie(header string) string {
+	first := true
+	return cookieValuePattern.ReplaceAllStringFunc(header, func(pair string) string {
+		m := cookieValuePattern.FindStringSubmatch(pair)
+		if !first && isCookieAttribute(m[2]) {
+			return pair
+		}
+		first = false
+		return m[1] + m[2] + "=" + Placeholder
+	})
+}
+
+// isCookieAttribute reports whether name is an attribute of a Set-Cookie header rather than a cookie.
+func isCookieAttribute(name string) bool {
+	switch strings.ToLower(name) {
+	case "path", "domain", "expires", "max-age", "samesite", "priority":
+		return true
+	}
+	return false
+}
+
+// URL returns u as a string with the values of sensitive query parameters and its password replaced by
+// Placeholder. u is not modified.
+func URL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	redacted := *u
+	if redacted.User != nil {
+		if _, ok := redacted.User.Password(); ok {
+			redacted.User = url.UserPassword(redacted.User.Username(), Placeholder)
+		}
+	}
+	query := redacted.Query()
+	changed := false
+	for key := range query {
+		if IsSensitiveKey(key) {
+			query.Set(key, Placeholder)
+			changed = true
+		}
+	}
+	if changed {
+		redacted.RawQuery = query.Encode()
+	}
+	return redacted.String()
+}
+
+// IsSensitiveKey reports whether values under key, as a query parameter or log attribute, are secret.
+func IsSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	return slices.Contains(sensitiveParams, key) || key == "password" || key == "cookie" || key == "set-cookie"
+}
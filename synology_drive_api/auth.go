@@ -36,6 +36,7 @@ func (s *SynologySession) Login() error {
 			"session": synologySessionName,
 			"format":  "cookie",
 		},
+		post: true, // Keep the password out of URLs logged by proxies
 	}
 
 	var resp loginResponseV3
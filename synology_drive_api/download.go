@@ -0,0 +1,148 @@
+package synology_drive_api
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// download holds the part of a file received so far, and what is needed to resume receiving it.
+type download struct {
+	content bytes.Buffer
+	// validator is the strong ETag or the Last-Modified date of the response the content came from,
+	// sent in If-Range when resuming; empty if the server does not support resuming it.
+	validator string
+	// contentType is the Content-Type of the response the content came from.
+	contentType string
+}
+
+// resumeHeader returns the headers requesting the rest of the file, or nil if it must be downloaded from the start.
+func (d *download) resumeHeader() http.Header {
+	if d.content.Len() == 0 || d.validator == "" {
+		return nil
+	}
+	return http.Header{
+		"Range":    {fmt.Sprintf("bytes=%d-", d.content.Len())},
+		"If-Range": {d.validator},
+	}
+}
+
+// restart discards the content received so far.
+func (d *download) restart() {
+	d.content.Reset()
+	d.validator = ""
+	d.contentType = ""
+}
+
+// receive appends the body of a 200 or 206 response to the content. A 200 response has the whole file, so it
+// replaces the content received before. On a read error, or if fewer bytes arrive than announced by
+// Content-Length, the bytes received are kept, to resume from them.
+func (d *download) receive(resp *http.Response) error {
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusPartialContent {
+		if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != int64(d.content.Len()) {
+			err := HttpError(fmt.Sprintf("unexpected Content-Range %q when resuming at %d bytes",
+				resp.Header.Get("Content-Range"), d.content.Len()))
+			d.restart()
+			return err
+		}
+	} else {
+		d.restart()
+		d.validator = resumeValidator(resp)
+		d.contentType = resp.Header.Get("Content-Type")
+	}
+	n, err := d.content.ReadFrom(resp.Body)
+	if err != nil {
+		return newHttpError(err)
+	}
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return HttpError(fmt.Sprintf("received %d of %d bytes announced by Content-Length", n, resp.ContentLength))
+	}
+	return nil
+}
+
+// resumeValidator returns the validator to resume the body of resp with, or "" if the server does not accept
+// byte ranges or gives no way to check that the file is unchanged. Weak ETags cannot be used in If-Range.
+func resumeValidator(resp *http.Response) string {
+	if !strings.EqualFold(resp.Header.Get("Accept-Ranges"), "bytes") {
+		return ""
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// contentRangeStart returns the first byte position of a Content-Range header such as "bytes 100-199/200".
+func contentRangeStart(header string) (int64, bool) {
+	rng, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	first, _, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	return start, err == nil
+}
+
+// downloadWithRetry downloads a file with a GET request, retried according to policy on network errors, including
+// connections dropped while receiving the body, and on responses with an HTTP status retried by the policy.
+// If the server supports byte ranges, a retry resumes from the bytes already received instead of starting over.
+// Responses with any other status than 200 or 206 fail with an HttpError without retrying.
+// Each attempt is subject to the export rate limit, and the transfer to the export bandwidth limit, if set.
+// Returns the file and its Content-Type.
+func (s *SynologySession) downloadWithRetry(endpoint string, params map[string]string, policy RetryPolicy, sleeper sleeper) ([]byte, string, error) {
+	maxAttempts := max(policy.MaxAttempts, 1)
+	var d download
+	var lastErr error
+	var delay time.Duration
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		// Only sleep between retries, not before the first attempt
+		if attempt > 1 {
+			s.observeAPIRetry(APIName(params["api"]), params["method"])
+			s.logRetry(APIName(params["api"]), params["method"], attempt, maxAttempts, delay, lastErr)
+			sleeper.Sleep(delay)
+		}
+		delay = policy.delay(attempt, rand.Float64)
+
+		s.exportLimiter.wait(1)
+		resp, err := s.httpGet(endpoint, params, RequestOption{Header: d.resumeHeader()})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if s.bandwidth != nil {
+			resp.Body = &throttledReader{ReadCloser: resp.Body, bucket: s.bandwidth}
+		}
+		switch {
+		case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent:
+			if err := d.receive(resp); err != nil {
+				lastErr = err
+				continue
+			}
+			return d.content.Bytes(), d.contentType, nil
+		case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && d.content.Len() > 0:
+			resp.Body.Close()
+			lastErr = HttpError(fmt.Sprintf("cannot resume at %d bytes: %s", d.content.Len(), resp.Status))
+			d.restart()
+		case policy.retryStatus(resp.StatusCode):
+			lastErr = fmt.Errorf("retryable error: %d %s", resp.StatusCode, resp.Status)
+			if retryAfter, ok := policy.retryAfter(resp, time.Now()); ok {
+				delay = retryAfter
+			}
+			resp.Body.Close()
+		default:
+			resp.Body.Close()
+			return nil, "", HttpError(fmt.Sprintf("unexpected status %s", resp.Status))
+		}
+	}
+
+	return nil, "", fmt.Errorf("after %d attempts, last error: %w", maxAttempts, lastErr)
+}
@@ -0,0 +1,142 @@
+package synology_drive_api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// downloadServer serves content, dropping the connection after dropAfter bytes on the first request.
+// etag is sent with Accept-Ranges if set, so that the download can be resumed.
+type downloadServer struct {
+	mu        sync.Mutex
+	content   []byte
+	etag      string
+	dropAfter int
+	ranges    []string // Range header of each request
+}
+
+func (ds *downloadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ds.mu.Lock()
+	ds.ranges = append(ds.ranges, r.Header.Get("Range"))
+	first := len(ds.ranges) == 1
+	content, etag := ds.content, ds.etag
+	ds.mu.Unlock()
+
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if first && ds.dropAfter > 0 {
+		if etag != "" {
+			w.Header().Set("Accept-Ranges", "bytes")
+		}
+		w.Header().Set("Content-Length", "1000000")
+		w.Write(content[:ds.dropAfter])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	if etag == "" {
+		w.Write(content)
+		return
+	}
+	// ServeContent supports Range and If-Range, and sets Accept-Ranges.
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+}
+
+func TestDownloadWithRetry(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 100))
+	policy := constantRetryPolicy(3, time.Second)
+	download := func(t *testing.T, handler http.Handler) ([]byte, *testSleeper, error) {
+		srv := httptest.NewServer(handler)
+		t.Cleanup(srv.Close)
+		session, err := NewSynologySession("test", "test", srv.URL)
+		require.NoError(t, err)
+		sleeper := &testSleeper{}
+		body, _, err := session.downloadWithRetry("entry.cgi/file.docx", nil, policy, sleeper)
+		return body, sleeper, err
+	}
+
+	t.Run("resumes a dropped download", func(t *testing.T) {
+		ds := &downloadServer{content: content, etag: `"v1"`, dropAfter: 400}
+		body, sleeper, err := download(t, ds)
+		require.NoError(t, err)
+		assert.Equal(t, content, body)
+		assert.Equal(t, []string{"", "bytes=400-"}, ds.ranges)
+		assert.Len(t, sleeper.sleepCalls, 1)
+	})
+
+	t.Run("starts over without range support", func(t *testing.T) {
+		ds := &downloadServer{content: content, dropAfter: 400}
+		body, _, err := download(t, ds)
+		require.NoError(t, err)
+		assert.Equal(t, content, body)
+		assert.Equal(t, []string{"", ""}, ds.ranges)
+	})
+
+	t.Run("starts over if the file changed", func(t *testing.T) {
+		changed := []byte(strings.Repeat("abcdefghij", 100))
+		ds := &downloadServer{content: content, etag: `"v1"`, dropAfter: 400}
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Range") != "" {
+				ds.mu.Lock()
+				ds.content, ds.etag = changed, `"v2"`
+				ds.mu.Unlock()
+			}
+			ds.ServeHTTP(w, r)
+		})
+		body, _, err := download(t, handler)
+		require.NoError(t, err)
+		assert.Equal(t, changed, body, "If-Range makes the server send the whole new file")
+	})
+
+	t.Run("retries server errors", func(t *testing.T) {
+		srv, requests := scriptedServer(t, `503 |Retry-After:5`, `200 content`)
+		session, err := NewSynologySession("test", "test", srv.URL)
+		require.NoError(t, err)
+		sleeper := &testSleeper{}
+		body, _, err := session.downloadWithRetry("entry.cgi/file.docx", nil, policy, sleeper)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("content"), body)
+		assert.Equal(t, 2, *requests)
+		assert.Equal(t, []time.Duration{5 * time.Second}, sleeper.sleepCalls)
+	})
+
+	t.Run("fails on other errors", func(t *testing.T) {
+		srv, requests := scriptedServer(t, `404 <html>not found</html>`)
+		session, err := NewSynologySession("test", "test", srv.URL)
+		require.NoError(t, err)
+		_, _, err = session.downloadWithRetry("entry.cgi/file.docx", nil, policy, &testSleeper{})
+		var httpErr HttpError
+		require.ErrorAs(t, err, &httpErr)
+		assert.Contains(t, err.Error(), "404")
+		assert.Equal(t, 1, *requests, "client errors are not retried")
+	})
+
+	t.Run("gives up after all attempts", func(t *testing.T) {
+		srv, requests := scriptedServer(t, `500 <html>error</html>`)
+		session, err := NewSynologySession("test", "test", srv.URL)
+		require.NoError(t, err)
+		_, _, err = session.downloadWithRetry("entry.cgi/file.docx", nil, policy, &testSleeper{})
+		assert.ErrorContains(t, err, "after 4 attempts")
+		assert.Equal(t, 4, *requests)
+	})
+}
+
+func TestContentRangeStart(t *testing.T) {
+	for header, want := range map[string]int64{"bytes 100-199/200": 100, "bytes 0-0/*": 0} {
+		start, ok := contentRangeStart(header)
+		assert.True(t, ok, header)
+		assert.Equal(t, want, start, header)
+	}
+	for _, header := range []string{"", "bytes */200", "items 1-2/3"} {
+		_, ok := contentRangeStart(header)
+		assert.False(t, ok, header)
+	}
+}
@@ -1,7 +1,10 @@
 package synology_drive_api
 
 import (
+	"errors"
 	"strconv"
+
+	"github.com/isseis/go-synology-office-exporter/redact"
 )
 
 // Common Synology API error codes (not exhaustive)
@@ -80,14 +83,20 @@ const SYNOLOGY_GET_ERROR_AT_DESTINATION = 1002
 // InvalidUrlError represents an error for invalid URL format
 type InvalidUrlError string
 
-// Error returns a formatted error message for InvalidUrlError
+// Error returns a formatted error message for InvalidUrlError, without any password in the URL
 func (e InvalidUrlError) Error() string {
-	return "invalid URL " + strconv.Quote(string(e)) + " in base_url"
+	return "invalid URL " + strconv.Quote(redact.String(string(e))) + " in base_url"
 }
 
 // HttpError represents a general HTTP error when communicating with the Synology API
 type HttpError string
 
+// newHttpError returns an HttpError for err. Errors of net/http include the request URL, so secrets such as
+// the password and session ID are removed from the message.
+func newHttpError(err error) HttpError {
+	return HttpError(redact.String(err.Error()))
+}
+
 // Error returns a formatted error message for HttpError
 func (e HttpError) Error() string {
 	return "http error " + strconv.Quote(string(e))
@@ -100,3 +109,27 @@ type SynologyError string
 func (e SynologyError) Error() string {
 	return "synology error " + strconv.Quote(string(e))
 }
+
+// SessionExpiredError is returned when the NAS rejects the session ID, because the session timed out, was
+// interrupted or is otherwise invalid. Logging in again starts a new session.
+type SessionExpiredError string
+
+// Error returns a formatted error message for SessionExpiredError
+func (e SessionExpiredError) Error() string {
+	return "synology session expired " + strconv.Quote(string(e))
+}
+
+// IsSessionExpired reports whether err, or an error it wraps, is a SessionExpiredError.
+func IsSessionExpired(err error) bool {
+	var expired SessionExpiredError
+	return errors.As(err, &expired)
+}
+
+// sessionExpiredCode reports whether code is a Synology error code reporting that the session ID was rejected.
+func sessionExpiredCode(code int) bool {
+	switch code {
+	case SYNOLOGY_COMMON_ERROR_SESSION_TIMEOUT, SYNOLOGY_COMMON_ERROR_SESSION_INTERRUPTED, SYNOLOGY_COMMON_ERROR_INVALID_SESSION:
+		return true
+	}
+	return false
+}
@@ -2,7 +2,7 @@ package synology_drive_api
 
 import (
 	"fmt"
-	"io"
+	"time"
 )
 
 // ExportResponse contains the result of exporting a file from Synology Drive, including the file name and raw content.
@@ -12,6 +12,8 @@ type ExportResponse struct {
 }
 
 // Export retrieves and converts a Synology Office file to the Microsoft Office format.
+// It looks up the name of the file with Get first; use ExportItem or ExportNamed to save that round trip
+// when the name is already known, e.g. from List.
 //   - fileID: The identifier of the file to export.
 //   - Returns an ExportResponse with the exported file content, or an error if the operation fails or the file type is unsupported.
 func (s *SynologySession) Export(fileID FileID) (*ExportResponse, error) {
@@ -19,10 +21,25 @@ func (s *SynologySession) Export(fileID FileID) (*ExportResponse, error) {
 	if err != nil {
 		return nil, SynologyError(err.Error())
 	}
+	return s.ExportNamed(ret.FileID, ret.Name)
+}
+
+// ExportItem exports the file described by item, as returned by List or SharedWithMe, like Export
+// but without looking up its name.
+func (s *SynologySession) ExportItem(item *ResponseItem) (*ExportResponse, error) {
+	return s.ExportNamed(item.FileID, item.Name)
+}
 
-	exportName := GetExportFileName(ret.Name)
+// ExportNamed retrieves and converts the Synology Office file with the given name to the Microsoft Office format.
+//   - fileID: The identifier of the file to export.
+//   - name: The name of the file on Synology Drive (e.g. "report.odoc"), which decides the export format and the name of the exported file.
+//   - Returns an ExportResponse with the exported file content, or an error if the operation fails or the file type is unsupported.
+//     The content is checked to be a file of the exported format, such as a zip archive with the main part of a
+//     document, so that error pages and truncated transfers fail with an ExportValidationError.
+func (s *SynologySession) ExportNamed(fileID FileID, name string) (*ExportResponse, error) {
+	exportName := GetExportFileName(name)
 	if exportName == "" {
-		return nil, SynologyError(fmt.Sprintf("Unsupported file type: [name=%s]", ret.Name))
+		return nil, SynologyError(fmt.Sprintf("Unsupported file type: [name=%s]", name))
 	}
 
 	endpoint := fmt.Sprintf("entry.cgi/%s", exportName)
@@ -30,19 +47,18 @@ func (s *SynologySession) Export(fileID FileID) (*ExportResponse, error) {
 		"api":     string(APINameSynologyOfficeExport),
 		"method":  "download",
 		"version": "1",
-		"path":    ret.FileID.toAPIParam(),
+		"path":    fileID.toAPIParam(),
 	}
 
-	// Use httpGet with empty ContentType for export operations
-	httpResponse, err := s.httpGet(endpoint, params, RequestOption{})
-	if err != nil {
-		return nil, err
+	// Export operations have no Content-Type; the download is retried and resumed according to the retry policy
+	start := time.Now()
+	body, contentType, err := s.downloadWithRetry(endpoint, params, s.retryPolicy, &realSleeper{})
+	if err == nil {
+		err = validateExport(exportName, contentType, body, s.minExportSize)
 	}
-
-	defer httpResponse.Body.Close()
-	body, err := io.ReadAll(httpResponse.Body)
+	s.observeAPICall(APINameSynologyOfficeExport, "download", start, err)
 	if err != nil {
-		return nil, HttpError(err.Error())
+		return nil, err
 	}
 
 	resp := &ExportResponse{
@@ -1,9 +1,12 @@
 package synology_drive_api
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -34,3 +37,41 @@ func TestExport(t *testing.T) {
 	}()
 	t.Log("Saved response to " + res.Name)
 }
+
+func TestExportNamed(t *testing.T) {
+	xlsx := newArchive(t, "[Content_Types].xml", "xl/workbook.xml")
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path+" "+r.URL.Query().Get("method"))
+		if r.URL.Query().Get("method") == "get" {
+			w.Write(cannedResponseGetFile)
+			return
+		}
+		w.Write(xlsx)
+	}))
+	defer srv.Close()
+	session, err := NewSynologySession("test", "test", srv.URL)
+	require.NoError(t, err)
+
+	res, err := session.ExportNamed("882614125167948399", "planning.osheet")
+	require.NoError(t, err)
+	assert.Equal(t, "planning.xlsx", res.Name)
+	assert.Equal(t, xlsx, res.Content)
+	assert.Equal(t, []string{"/webapi/entry.cgi/planning.xlsx download"}, requests, "no Get round trip")
+
+	requests = nil
+	_, err = session.ExportItem(&ResponseItem{FileID: "882614125167948399", Name: "notes.odoc"})
+	require.Error(t, err, "the content is not a document")
+	assert.Equal(t, []string{"/webapi/entry.cgi/notes.docx download"}, requests)
+
+	requests = nil
+	res, err = session.Export("882614125167948399")
+	require.NoError(t, err)
+	assert.Equal(t, "planning.xlsx", res.Name)
+	assert.Equal(t, []string{"/webapi/entry.cgi get", "/webapi/entry.cgi/planning.xlsx download"}, requests)
+
+	requests = nil
+	_, err = session.ExportNamed("882614125167948399", "photo.jpg")
+	assert.ErrorContains(t, err, "Unsupported file type")
+	assert.Empty(t, requests)
+}
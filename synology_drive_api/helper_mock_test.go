@@ -77,7 +77,8 @@ func mockSynologyHandler(w http.ResponseWriter, r *http.Request) {
 // handleMockAuth processes login and logout requests for the mock Synology NAS API.
 // It sets HTTP status codes and writes mock JSON responses for both login and logout methods.
 func handleMockAuth(w http.ResponseWriter, r *http.Request) {
-	method := r.URL.Query().Get("method")
+	// Login sends its parameters in a POST body, which FormValue reads as well as the query.
+	method := r.FormValue("method")
 	w.WriteHeader(http.StatusOK)
 	switch method {
 	case "login":
@@ -0,0 +1,71 @@
+package synology_drive_api
+
+import (
+	"fmt"
+	"iter"
+)
+
+// Paginate returns an iterator over the items of a paginated listing. fetch is called with the offset and
+// limit of each page and returns the items of the page and the total number of items. Pages are fetched
+// on demand, pageSize items at a time, so that no page is fetched after the consumer stops.
+// If fetch fails, the error is yielded once and the iteration ends.
+func Paginate[T any](pageSize int64, fetch func(offset, limit int64) ([]T, int64, error)) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for offset := int64(0); ; {
+			items, total, err := fetch(offset, pageSize)
+			if err != nil {
+				var zero T
+				yield(zero, fmt.Errorf("error listing items at offset %d: %w", offset, err))
+				return
+			}
+			for _, item := range items {
+				if !yield(item, nil) {
+					return
+				}
+			}
+			offset += int64(len(items))
+			if len(items) == 0 || offset >= total {
+				return
+			}
+		}
+	}
+}
+
+// ListItems returns an iterator over all items in a folder, fetching pages from List as they are consumed.
+func (s *SynologySession) ListItems(fileID FileID) iter.Seq2[*ResponseItem, error] {
+	return listItems(s, fileID)
+}
+
+// listItems returns an iterator over all items in a folder listed with l.
+func listItems(l Lister, fileID FileID) iter.Seq2[*ResponseItem, error] {
+	return Paginate(l.GetMaxPageSize(), func(offset, limit int64) ([]*ResponseItem, int64, error) {
+		resp, err := l.List(fileID, offset, limit)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.Items, resp.Total, nil
+	})
+}
+
+// TeamFolderItems returns an iterator over all team folders, fetching pages from TeamFolder as they are consumed.
+func (s *SynologySession) TeamFolderItems() iter.Seq2[*TeamFolderResponseItem, error] {
+	return Paginate(s.GetMaxPageSize(), func(offset, limit int64) ([]*TeamFolderResponseItem, int64, error) {
+		resp, err := s.TeamFolder(offset, limit)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.Items, resp.Total, nil
+	})
+}
+
+// SharedWithMeItems returns an iterator over all items shared with the user, fetching pages from SharedWithMe
+// as they are consumed.
+func (s *SynologySession) SharedWithMeItems() iter.Seq2[*ResponseItem, error] {
+	return Paginate(s.GetMaxPageSize(), func(offset, limit int64) ([]*ResponseItem, int64, error) {
+		resp, err := s.SharedWithMe(offset, limit)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.Items, resp.Total, nil
+	})
+}
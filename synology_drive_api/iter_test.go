@@ -0,0 +1,84 @@
+package synology_drive_api
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaginate(t *testing.T) {
+	var offsets []int64
+	pages := func(total int64) func(offset, limit int64) ([]int64, int64, error) {
+		return func(offset, limit int64) ([]int64, int64, error) {
+			offsets = append(offsets, offset)
+			var items []int64
+			for i := offset; i < min(offset+limit, total); i++ {
+				items = append(items, i)
+			}
+			return items, total, nil
+		}
+	}
+
+	var got []int64
+	for item, err := range Paginate(3, pages(7)) {
+		require.NoError(t, err)
+		got = append(got, item)
+	}
+	assert.Equal(t, []int64{0, 1, 2, 3, 4, 5, 6}, got)
+	assert.Equal(t, []int64{0, 3, 6}, offsets)
+
+	offsets = nil
+	for item := range Paginate(3, pages(7)) {
+		if item == 4 {
+			break
+		}
+	}
+	assert.Equal(t, []int64{0, 3}, offsets, "no page is fetched after the consumer stops")
+
+	offsets = nil
+	for range Paginate(3, pages(0)) {
+		t.Fatal("an empty listing has no items")
+	}
+	assert.Equal(t, []int64{0}, offsets)
+
+	fetchErr := errors.New("connection reset")
+	var errs []error
+	for _, err := range Paginate(3, func(offset, limit int64) ([]int64, int64, error) {
+		if offset > 0 {
+			return nil, 0, fetchErr
+		}
+		return []int64{1, 2, 3}, 10, nil
+	}) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	require.Len(t, errs, 1)
+	assert.ErrorIs(t, errs[0], fetchErr)
+	assert.Contains(t, errs[0].Error(), "offset 3")
+}
+
+func TestListItems(t *testing.T) {
+	srv, requests := scriptedServer(t,
+		`200 {"success": true, "data": {"total": 3, "items": [{"file_id": "1", "name": "a.odoc"}, {"file_id": "2", "name": "b.odoc"}]}}`,
+		`200 {"success": true, "data": {"total": 3, "items": [{"file_id": "3", "name": "c.odoc"}]}}`,
+	)
+	session, err := NewSynologySession("test", "test", srv.URL, WithMaxPageSize(2))
+	require.NoError(t, err)
+
+	var ids []FileID
+	for item, err := range session.ListItems(MyDrive) {
+		require.NoError(t, err)
+		ids = append(ids, item.FileID)
+	}
+	assert.Equal(t, []FileID{"1", "2", "3"}, ids)
+	assert.Equal(t, 2, *requests)
+
+	*requests = 0
+	for range session.ListItems(MyDrive) {
+		break
+	}
+	assert.Equal(t, 1, *requests, "stopping after the first item fetches one page")
+}
@@ -0,0 +1,102 @@
+package synology_drive_api
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a token bucket rate limiter, safe for concurrent use. Tokens are added at rate per second,
+// up to burst. A nil *tokenBucket does not limit.
+type tokenBucket struct {
+	mu      sync.Mutex
+	rate    float64 // Tokens added per second
+	burst   float64 // Maximum number of tokens
+	tokens  float64 // Tokens available; negative when taken in advance by waiting callers
+	last    time.Time
+	now     func() time.Time
+	sleeper sleeper
+}
+
+// newTokenBucket returns a full token bucket, or nil if rate is not positive.
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	b := &tokenBucket{
+		rate:    rate,
+		burst:   float64(max(burst, 1)),
+		now:     time.Now,
+		sleeper: &realSleeper{},
+	}
+	b.tokens = b.burst
+	b.last = b.now()
+	return b
+}
+
+// wait takes n tokens, sleeping until they are available. The tokens are reserved before sleeping, so that
+// concurrent callers are served in turn, and n may exceed the burst.
+func (b *tokenBucket) wait(n float64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	now := b.now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= n
+	var d time.Duration
+	if b.tokens < 0 {
+		d = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+	if d > 0 {
+		b.sleeper.Sleep(d)
+	}
+}
+
+// throttledReader limits the rate at which bytes are read from the underlying reader.
+type throttledReader struct {
+	io.ReadCloser
+	bucket *tokenBucket
+}
+
+// Read reads at most a burst of bytes, then waits until the bytes read are within the rate.
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > int(r.bucket.burst) {
+		p = p[:int(r.bucket.burst)]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.bucket.wait(float64(n))
+	return n, err
+}
+
+// WithRateLimit limits the API calls other than export downloads, such as List and Get, to perSecond calls
+// per second on average, with bursts of up to burst calls. Retries count as calls. The limit is shared by all
+// goroutines using the session. A rate of 0 disables the limit.
+func WithRateLimit(perSecond float64, burst int) SessionOption {
+	return func(s *SynologySession) {
+		s.apiLimiter = newTokenBucket(perSecond, burst)
+	}
+}
+
+// WithExportRateLimit limits export downloads to perSecond per second on average, with bursts of up to burst
+// downloads. Retries count as downloads. The limit is shared by all goroutines using the session.
+// A rate of 0 disables the limit.
+func WithExportRateLimit(perSecond float64, burst int) SessionOption {
+	return func(s *SynologySession) {
+		s.exportLimiter = newTokenBucket(perSecond, burst)
+	}
+}
+
+// WithExportBandwidth limits the transfer of exported files to bytesPerSecond, shared by all goroutines using
+// the session. A rate of 0 disables the limit.
+func WithExportBandwidth(bytesPerSecond int64) SessionOption {
+	return func(s *SynologySession) {
+		s.bandwidth = newTokenBucket(float64(bytesPerSecond), int(min(bytesPerSecond, maxBandwidthBurst)))
+	}
+}
+
+// maxBandwidthBurst limits the bytes transferred at once under WithExportBandwidth, so that the rate stays
+// smooth even when it is high.
+const maxBandwidthBurst = 256 * 1024
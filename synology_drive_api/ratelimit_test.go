@@ -0,0 +1,131 @@
+package synology_drive_api
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a clock for token buckets whose sleeps record the duration and, if advance is set, move the clock.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	advance bool
+	sleeps  []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sleeps = append(c.sleeps, d)
+	if c.advance {
+		c.now = c.now.Add(d)
+	}
+}
+
+// withFakeClock makes b use clock.
+func withFakeClock(b *tokenBucket, clock *fakeClock) *tokenBucket {
+	b.now = clock.Now
+	b.sleeper = clock
+	b.last = clock.Now()
+	return b
+}
+
+func TestTokenBucket(t *testing.T) {
+	assert.Nil(t, newTokenBucket(0, 10), "a rate of 0 does not limit")
+	var unlimited *tokenBucket
+	unlimited.wait(100)
+
+	clock := &fakeClock{now: time.Unix(0, 0), advance: true}
+	b := withFakeClock(newTokenBucket(2, 2), clock)
+	b.wait(1)
+	b.wait(1)
+	assert.Empty(t, clock.sleeps, "the burst is available at once")
+	b.wait(1)
+	b.wait(1)
+	assert.Equal(t, []time.Duration{500 * time.Millisecond, 500 * time.Millisecond}, clock.sleeps)
+
+	clock.now = clock.now.Add(time.Hour)
+	clock.sleeps = nil
+	b.wait(3)
+	assert.Equal(t, []time.Duration{500 * time.Millisecond}, clock.sleeps, "tokens accumulate up to the burst")
+}
+
+func TestTokenBucketConcurrent(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	b := withFakeClock(newTokenBucket(10, 1), clock)
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.wait(1)
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(clock.sleeps, func(i, j int) bool { return clock.sleeps[i] < clock.sleeps[j] })
+	var want []time.Duration
+	for i := 1; i < 10; i++ {
+		want = append(want, time.Duration(i)*100*time.Millisecond)
+	}
+	assert.Equal(t, want, clock.sleeps, "each caller waits for its own turn")
+}
+
+func TestThrottledReader(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0), advance: true}
+	b := withFakeClock(newTokenBucket(100, 100), clock)
+	content := bytes.Repeat([]byte("x"), 1000)
+	r := &throttledReader{ReadCloser: io.NopCloser(bytes.NewReader(content)), bucket: b}
+
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, content, got)
+	var total time.Duration
+	for _, d := range clock.sleeps {
+		total += d
+	}
+	assert.Equal(t, 9*time.Second, total, "the first 100 bytes are the burst")
+}
+
+func TestSessionRateLimits(t *testing.T) {
+	srv, requests := scriptedServer(t, `200 {"success": true}`)
+	session, err := NewSynologySession("test", "test", srv.URL,
+		WithRateLimit(1, 1), WithExportRateLimit(0.5, 1), WithExportBandwidth(1024))
+	require.NoError(t, err)
+	apiClock := &fakeClock{now: time.Unix(0, 0)}
+	exportClock := &fakeClock{now: time.Unix(0, 0), advance: true}
+	withFakeClock(session.apiLimiter, apiClock)
+	withFakeClock(session.exportLimiter, exportClock)
+	withFakeClock(session.bandwidth, exportClock)
+
+	for range 3 {
+		_, err := session.httpGetJSON("entry.cgi", nil)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, apiClock.sleeps)
+
+	for range 2 {
+		_, _, err := session.downloadWithRetry("entry.cgi/file.docx", nil, session.retryPolicy, &testSleeper{})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []time.Duration{2 * time.Second}, exportClock.sleeps, "the 18 bytes downloaded are within the burst")
+	assert.Equal(t, 5, *requests)
+
+	session, err = NewSynologySession("test", "test", srv.URL, WithRateLimit(0, 1))
+	require.NoError(t, err)
+	assert.Nil(t, session.apiLimiter)
+	assert.Nil(t, session.bandwidth)
+}
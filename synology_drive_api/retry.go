@@ -0,0 +1,175 @@
+package synology_drive_api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy decides which failed API requests are retried, and how long to wait before each retry.
+// Requests failing with a network error are always retried; other failures are retried as decided by
+// RetryStatus and RetrySynologyCode.
+type RetryPolicy struct {
+	MaxAttempts  int           // Number of attempts including the first one; 1 disables retries
+	InitialDelay time.Duration // Delay before the first retry
+	MaxDelay     time.Duration // Upper bound of the delay before a retry, before jitter
+	Multiplier   float64       // Factor by which the delay grows with each retry
+	// Jitter is the fraction, between 0 and 1, by which each delay is randomly shortened,
+	// so that clients failing together do not retry together.
+	Jitter float64
+	// MaxRetryAfter caps the delay requested by the Retry-After header of a response,
+	// which is honoured instead of the computed delay.
+	MaxRetryAfter time.Duration
+	// RetryStatus reports whether a response with the HTTP status code is retried.
+	// If nil, IsRetryableStatus is used.
+	RetryStatus func(code int) bool
+	// RetrySynologyCode reports whether a response reporting the Synology error code, in a body with
+	// "success": false, is retried. If nil, IsRetryableSynologyCode is used.
+	RetrySynologyCode func(code int) bool
+}
+
+// DefaultRetryPolicy returns the retry policy of sessions without WithRetryPolicy: up to 4 attempts,
+// with delays growing from 1s by a factor of 2 up to 30s, shortened by up to 20% at random.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:   4,
+		InitialDelay:  time.Second,
+		MaxDelay:      30 * time.Second,
+		Multiplier:    2,
+		Jitter:        0.2,
+		MaxRetryAfter: 2 * time.Minute,
+	}
+}
+
+// WithRetryPolicy sets the policy for retrying failed API requests.
+func WithRetryPolicy(policy RetryPolicy) SessionOption {
+	return func(s *SynologySession) {
+		s.retryPolicy = policy
+	}
+}
+
+// RetryLogger receives a message for each retried API request. logger.Logger satisfies it.
+type RetryLogger interface {
+	Warn(msg string, args ...interface{})
+}
+
+// WithRetryLogger logs each retried API request, with the reason and the delay, to l.
+func WithRetryLogger(l RetryLogger) SessionOption {
+	return func(s *SynologySession) {
+		s.retryLogger = l
+	}
+}
+
+// IsRetryableStatus reports whether a response with the HTTP status code is worth retrying:
+// 408 Request Timeout, 429 Too Many Requests and all 5xx errors. Authentication errors are not
+// retried, since sending the same request again cannot fix them.
+func IsRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, // 408
+		http.StatusTooManyRequests: // 429
+		return true
+	}
+	return code >= 500 && code < 600
+}
+
+// IsRetryableSynologyCode reports whether a Synology error code means that the NAS is temporarily busy
+// or its network unstable, so that the request may succeed later.
+func IsRetryableSynologyCode(code int) bool {
+	switch code {
+	case SYNOLOGY_COMMON_ERROR_NETWORK_UNSTABLE_OR_SYSTEM_BUSY,
+		SYNOLOGY_COMMON_ERROR_NETWORK_UNSTABLE_OR_SYSTEM_BUSY_2,
+		SYNOLOGY_COMMON_ERROR_NETWORK_UNSTABLE_OR_SYSTEM_BUSY_3,
+		SYNOLOGY_COMMON_ERROR_NETWORK_UNSTABLE_OR_SYSTEM_BUSY_4,
+		SYNOLOGY_COMMON_ERROR_NETWORK_UNSTABLE_OR_SYSTEM_BUSY_5:
+		return true
+	}
+	return false
+}
+
+// retryStatus reports whether the policy retries a response with the HTTP status code.
+func (p *RetryPolicy) retryStatus(code int) bool {
+	if p.RetryStatus != nil {
+		return p.RetryStatus(code)
+	}
+	return IsRetryableStatus(code)
+}
+
+// retrySynologyCode reports whether the policy retries a response with the Synology error code.
+func (p *RetryPolicy) retrySynologyCode(code int) bool {
+	if p.RetrySynologyCode != nil {
+		return p.RetrySynologyCode(code)
+	}
+	return IsRetryableSynologyCode(code)
+}
+
+// delay returns the delay before the given retry, counted from 1. random returns a number in [0, 1).
+func (p *RetryPolicy) delay(retry int, random func() float64) time.Duration {
+	d := float64(p.InitialDelay) * math.Pow(max(p.Multiplier, 1), float64(retry-1))
+	if p.MaxDelay > 0 {
+		d = min(d, float64(p.MaxDelay))
+	}
+	if p.Jitter > 0 {
+		d -= d * min(p.Jitter, 1) * random()
+	}
+	return time.Duration(d)
+}
+
+// retryAfter returns the delay requested by the Retry-After header of resp, capped at MaxRetryAfter,
+// and whether there is one. The header holds either a number of seconds or an HTTP date.
+func (p *RetryPolicy) retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	var d time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		d = time.Duration(seconds) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		d = t.Sub(now)
+	} else {
+		return 0, false
+	}
+	if p.MaxRetryAfter > 0 {
+		d = min(d, p.MaxRetryAfter)
+	}
+	return max(d, 0), true
+}
+
+// synologyErrorCode returns the error code of a JSON response body with "success": false, or 0.
+func synologyErrorCode(body []byte) int {
+	var res struct {
+		Success bool `json:"success"`
+		Error   struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &res) != nil || res.Success {
+		return 0
+	}
+	return res.Error.Code
+}
+
+// peekSynologyErrorCode returns the Synology error code in the body of resp, or 0 if it reports success or is not JSON.
+// The body is read and replaced, so that resp can still be processed.
+func peekSynologyErrorCode(resp *http.Response) (int, error) {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return 0, newHttpError(err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return synologyErrorCode(body), nil
+}
+
+// logRetry logs, if a retry logger is set, that a request of method of api is retried as the given attempt
+// out of maxAttempts after delay, because of err.
+func (s *SynologySession) logRetry(api APIName, method string, attempt, maxAttempts int, delay time.Duration, err error) {
+	if s.retryLogger != nil {
+		s.retryLogger.Warn("Retrying Synology API request", "api", api, "method", method,
+			"attempt", attempt, "max_attempts", maxAttempts, "delay", delay, "error", err)
+	}
+}
@@ -0,0 +1,177 @@
+package synology_drive_api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Second, Multiplier: 2}
+	noJitter := func() float64 { return 0 }
+	var delays []time.Duration
+	for retry := 1; retry <= 5; retry++ {
+		delays = append(delays, policy.delay(retry, noJitter))
+	}
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}, delays)
+
+	policy.Jitter = 0.5
+	assert.Equal(t, 4*time.Second, policy.delay(3, noJitter))
+	assert.Equal(t, 3*time.Second, policy.delay(3, func() float64 { return 0.5 }))
+	assert.Equal(t, 2*time.Second, policy.delay(3, func() float64 { return 1 }))
+}
+
+func TestRetryPolicyRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 7, 2, 0, 0, 0, time.UTC)
+	policy := RetryPolicy{MaxRetryAfter: time.Minute}
+	for value, want := range map[string]time.Duration{
+		"7":                             7 * time.Second,
+		"3600":                          time.Minute,
+		"Tue, 07 May 2024 02:00:30 GMT": 30 * time.Second,
+		"Tue, 07 May 2024 01:00:00 GMT": 0,
+	} {
+		resp := &http.Response{Header: http.Header{"Retry-After": {value}}}
+		d, ok := policy.retryAfter(resp, now)
+		assert.True(t, ok, value)
+		assert.Equal(t, want, d, value)
+	}
+	for _, value := range []string{"", "soon"} {
+		_, ok := policy.retryAfter(&http.Response{Header: http.Header{"Retry-After": {value}}}, now)
+		assert.False(t, ok, value)
+	}
+}
+
+// recordingRetryLogger records the arguments of each retry message.
+type recordingRetryLogger struct {
+	args [][]interface{}
+}
+
+func (l *recordingRetryLogger) Warn(msg string, args ...interface{}) {
+	l.args = append(l.args, args)
+}
+
+// scriptedServer starts a server answering the n-th request with the n-th of responses, given as
+// "status body" with optional headers after a "|", and the last response once they run out.
+func scriptedServer(t *testing.T, responses ...string) (*httptest.Server, *int) {
+	t.Helper()
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := responses[min(requests, len(responses)-1)]
+		requests++
+		response, headers, _ := strings.Cut(response, "|")
+		for _, header := range strings.Split(headers, ";") {
+			if name, value, ok := strings.Cut(header, ":"); ok {
+				w.Header().Set(name, value)
+			}
+		}
+		var status int
+		var body string
+		_, err := fmt.Sscanf(response, "%d", &status)
+		require.NoError(t, err)
+		_, body, _ = strings.Cut(response, " ")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestHTTPJSONWithRetry_Policy(t *testing.T) {
+	policy := constantRetryPolicy(3, time.Second)
+	params := map[string]string{"api": string(APINameSynologyDriveFiles), "method": "list"}
+
+	t.Run("authentication errors are not retried", func(t *testing.T) {
+		srv, requests := scriptedServer(t, `401 {"success": false, "error": {"code": 119}}`)
+		session, err := NewSynologySession("test", "test", srv.URL)
+		require.NoError(t, err)
+		sleeper := &testSleeper{}
+		resp, err := session.httpGetJSONWithRetry("entry.cgi", params, policy, sleeper)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, 1, *requests)
+		assert.Empty(t, sleeper.sleepCalls)
+	})
+
+	t.Run("Retry-After", func(t *testing.T) {
+		srv, requests := scriptedServer(t, `429 |Retry-After:7`, `503 `, `200 {"success": true}`)
+		session, err := NewSynologySession("test", "test", srv.URL)
+		require.NoError(t, err)
+		sleeper := &testSleeper{}
+		resp, err := session.httpGetJSONWithRetry("entry.cgi", params, policy, sleeper)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, 3, *requests)
+		assert.Equal(t, []time.Duration{7 * time.Second, time.Second}, sleeper.sleepCalls)
+	})
+
+	t.Run("busy Synology codes", func(t *testing.T) {
+		srv, requests := scriptedServer(t, `200 {"success": false, "error": {"code": 117}}`, `200 {"success": true, "data": {"total": 0}}`)
+		logger := &recordingRetryLogger{}
+		session, err := NewSynologySession("test", "test", srv.URL, WithRetryLogger(logger))
+		require.NoError(t, err)
+		sleeper := &testSleeper{}
+		resp, err := session.httpGetJSONWithRetry("entry.cgi", params, policy, sleeper)
+		require.NoError(t, err)
+		var res jsonListResponseV2
+		_, err = session.processAPIResponse(resp, &res, "list")
+		require.NoError(t, err, "the body is still readable after being checked")
+		assert.Equal(t, 2, *requests)
+		require.Len(t, logger.args, 1)
+		assert.Equal(t, []interface{}{"api", APINameSynologyDriveFiles, "method", "list", "attempt", 2, "max_attempts", 4,
+			"delay", time.Second, "error", SynologyError("NAS busy [code=117]")}, logger.args[0])
+	})
+
+	t.Run("busy until the last attempt", func(t *testing.T) {
+		srv, requests := scriptedServer(t, `200 {"success": false, "error": {"code": 109}}`)
+		session, err := NewSynologySession("test", "test", srv.URL)
+		require.NoError(t, err)
+		resp, err := session.httpGetJSONWithRetry("entry.cgi", params, policy, &testSleeper{})
+		require.NoError(t, err)
+		var res jsonListResponseV2
+		_, err = session.processAPIResponse(resp, &res, "list")
+		var synErr SynologyError
+		require.ErrorAs(t, err, &synErr, "the Synology error of the last attempt is reported")
+		assert.Contains(t, err.Error(), "code=109")
+		assert.Equal(t, 4, *requests)
+	})
+
+	t.Run("other Synology codes", func(t *testing.T) {
+		srv, requests := scriptedServer(t, `200 {"success": false, "error": {"code": 114}}`)
+		session, err := NewSynologySession("test", "test", srv.URL)
+		require.NoError(t, err)
+		resp, err := session.httpGetJSONWithRetry("entry.cgi", params, policy, &testSleeper{})
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, 1, *requests)
+	})
+
+	t.Run("custom rules", func(t *testing.T) {
+		srv, requests := scriptedServer(t, `404 `, `200 {"success": false, "error": {"code": 114}}`, `500 `)
+		session, err := NewSynologySession("test", "test", srv.URL)
+		require.NoError(t, err)
+		custom := policy
+		custom.RetryStatus = func(code int) bool { return code == http.StatusNotFound }
+		custom.RetrySynologyCode = func(code int) bool { return code == 114 }
+		resp, err := session.httpGetJSONWithRetry("entry.cgi", params, custom, &testSleeper{})
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		assert.Equal(t, 3, *requests)
+	})
+
+	t.Run("session policy", func(t *testing.T) {
+		srv, requests := scriptedServer(t, `503 `)
+		session, err := NewSynologySession("test", "test", srv.URL, WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+		require.NoError(t, err)
+		_, err = session.httpGetJSON("entry.cgi", params)
+		assert.ErrorContains(t, err, "after 1 attempts")
+		assert.Equal(t, 1, *requests)
+	})
+}
@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"io"
 	"maps"
+	"math/rand/v2"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"strings"
 	"time"
 )
 
@@ -22,14 +24,10 @@ func (s *realSleeper) Sleep(d time.Duration) {
 	time.Sleep(d)
 }
 
-const (
-	defaultMaxRetries = 3
-	defaultRetryDelay = 1 * time.Second
-)
-
 // RequestOption represents options for HTTP requests
 type RequestOption struct {
-	ContentType string // Content-Type header value, empty string means no Content-Type header will be set
+	ContentType string      // Content-Type header value, empty string means no Content-Type header will be set
+	Header      http.Header // Additional request headers, e.g. Range
 }
 
 var RequestOptionJSON = RequestOption{
@@ -47,6 +45,22 @@ func WithMaxPageSize(maxPageSize int64) SessionOption {
 	}
 }
 
+// APIObserver is notified of the API calls made by a session, for example to collect metrics.
+type APIObserver interface {
+	// ObserveAPICall is called when a call of method of api completes, with its duration including retries
+	// and the error it returned, if any.
+	ObserveAPICall(api APIName, method string, duration time.Duration, err error)
+	// ObserveAPIRetry is called each time a request of method of api is retried.
+	ObserveAPIRetry(api APIName, method string)
+}
+
+// WithAPIObserver sets an observer notified of every API call made by the session.
+func WithAPIObserver(observer APIObserver) SessionOption {
+	return func(s *SynologySession) {
+		s.observer = observer
+	}
+}
+
 // GetMaxPageSize returns the maximum number of items that can be requested per page.
 func (s *SynologySession) GetMaxPageSize() int64 {
 	return s.maxPageSize
@@ -54,13 +68,22 @@ func (s *SynologySession) GetMaxPageSize() int64 {
 
 // SynologySession represents a session with a Synology NAS
 type SynologySession struct {
-	username    string      // Username for login on Synology NAS
-	password    string      // Password for login on Synology NAS
-	hostname    string      // Hostname of Synology NAS
-	scheme      string      // URL scheme (http or https)
-	sid         SessionID   // Session ID (set after login)
-	http_client http.Client // HTTP client with cookie support
-	maxPageSize int64       // Maximum number of items per page for List operations
+	username      string           // Username for login on Synology NAS
+	password      string           // Password for login on Synology NAS
+	hostname      string           // Hostname of Synology NAS
+	scheme        string           // URL scheme (http or https)
+	sid           SessionID        // Session ID (set after login)
+	http_client   http.Client      // HTTP client with cookie support
+	maxPageSize   int64            // Maximum number of items per page for List operations
+	observer      APIObserver      // Notified of API calls; nil if not set
+	tls           tlsOptions       // TLS settings applied to http_client by NewSynologySession
+	transport     transportOptions // HTTP transport settings applied to http_client by NewSynologySession
+	retryPolicy   RetryPolicy      // Policy for retrying failed API requests
+	retryLogger   RetryLogger      // Logs retried API requests; nil if not set
+	minExportSize int64            // Size in bytes below which an exported file is rejected
+	apiLimiter    *tokenBucket     // Limits the rate of API calls other than export downloads; nil if unlimited
+	exportLimiter *tokenBucket     // Limits the rate of export downloads; nil if unlimited
+	bandwidth     *tokenBucket     // Limits the bytes per second of export downloads; nil if unlimited
 }
 
 // NewSynologySession creates a new Synology API session with the provided credentials and base URL.
@@ -75,6 +98,8 @@ type SynologySession struct {
 // Returns:
 //   - *SynologySession: A new session object
 //   - error: An error of type InvalidUrlError if the URL is invalid
+//   - error: An error of type TLSConfigError if the TLS options cannot be applied
+//   - error: An error of type TransportConfigError if the transport options contradict each other
 func NewSynologySession(username string, password string, base_url string, options ...SessionOption) (*SynologySession, error) {
 	parsed, err := url.Parse(base_url)
 	if err != nil {
@@ -82,18 +107,23 @@ func NewSynologySession(username string, password string, base_url string, optio
 	}
 	jar, _ := cookiejar.New(nil)
 	session := &SynologySession{
-		username:    username,
-		password:    password,
-		hostname:    parsed.Host,
-		scheme:      parsed.Scheme,
-		http_client: http.Client{Jar: jar},
-		maxPageSize: DefaultMaxPageSize, // Set default max page size
+		username:      username,
+		password:      password,
+		hostname:      parsed.Host,
+		scheme:        parsed.Scheme,
+		http_client:   http.Client{Jar: jar},
+		maxPageSize:   DefaultMaxPageSize, // Set default max page size
+		retryPolicy:   DefaultRetryPolicy(),
+		minExportSize: DefaultMinExportSize,
 	}
 
 	// Apply all options
 	for _, option := range options {
 		option(session)
 	}
+	if err := session.applyTransportOptions(); err != nil {
+		return nil, err
+	}
 
 	return session, nil
 }
@@ -128,27 +158,42 @@ func (s *SynologySession) buildUrl(endpoint string, params map[string]string) *u
 // Parameters:
 //   - method: The HTTP method to use (GET, POST, etc.)
 //   - endpoint: The API endpoint path
-//   - params: Query parameters to include in the URL
+//   - params: Parameters of the request; sent as a form-encoded body for POST, and in the URL otherwise
 //   - options: Request options including content type settings
 //
 // Returns:
 //   - *http.Response: The HTTP response from the API
 //   - error: An error of type HttpError if the request failed
 func (s *SynologySession) httpRequest(method string, endpoint string, params map[string]string, options RequestOption) (*http.Response, error) {
-	url := s.buildUrl(endpoint, params)
-	req, err := http.NewRequest(method, url.String(), nil)
+	var body io.Reader
+	contentType := options.ContentType
+	reqUrl := s.buildUrl(endpoint, params)
+	if method == http.MethodPost {
+		// Keep the parameters, which may include credentials, out of the URL that proxies and servers log.
+		reqUrl = s.buildUrl(endpoint, nil)
+		form := make(url.Values, len(params))
+		for param, value := range params {
+			form.Set(param, value)
+		}
+		body = strings.NewReader(form.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	}
+	req, err := http.NewRequest(method, reqUrl.String(), body)
 	if err != nil {
-		return nil, HttpError(err.Error())
+		return nil, newHttpError(err)
 	}
 
-	// Set Content-Type header only if specified in options
-	if options.ContentType != "" {
-		req.Header.Set("Content-Type", options.ContentType)
+	for name, values := range options.Header {
+		req.Header[name] = values
+	}
+	// Set Content-Type header only if specified in options or required by the body
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
 	}
 
 	res, err := s.http_client.Do(req)
 	if err != nil {
-		return nil, HttpError(err.Error())
+		return nil, newHttpError(err)
 	}
 
 	return res, nil
@@ -167,22 +212,7 @@ func (s *SynologySession) httpGet(endpoint string, params map[string]string, opt
 	return s.httpRequest(http.MethodGet, endpoint, params, options)
 }
 
-// httpGetJSONDirect sends a GET request to the Synology NAS API with JSON content type without retry
-// Parameters:
-//   - endpoint: The API endpoint path
-//   - params: Query parameters to include in the URL
-//
-// Returns:
-//   - *http.Response: The HTTP response from the API
-//   - error: An error if the request failed
-func (s *SynologySession) httpGetJSONDirect(endpoint string, params map[string]string) (*http.Response, error) {
-	options := RequestOption{
-		ContentType: "application/json",
-	}
-	return s.httpRequest(http.MethodGet, endpoint, params, options)
-}
-
-// httpGetJSON sends a GET request to the Synology NAS API with JSON content type and retry logic
+// httpGetJSON sends a GET request to the Synology NAS API with JSON content type, retried according to the retry policy
 // Parameters:
 //   - endpoint: The API endpoint path
 //   - params: Query parameters to include in the URL
@@ -191,50 +221,67 @@ func (s *SynologySession) httpGetJSONDirect(endpoint string, params map[string]s
 //   - *http.Response: The HTTP response from the API
 //   - error: An error if all retry attempts failed
 func (s *SynologySession) httpGetJSON(endpoint string, params map[string]string) (*http.Response, error) {
-	return s.httpGetJSONWithRetry(endpoint, params, defaultMaxRetries, defaultRetryDelay, &realSleeper{})
+	return s.httpGetJSONWithRetry(endpoint, params, s.retryPolicy, &realSleeper{})
 }
 
-// isRetryableStatus returns true if the HTTP status code is considered retryable.
-// Retries on all 5xx errors and selected 4xx errors (Request Timeout, Too Many Requests, Unauthorized, Forbidden).
-func isRetryableStatus(code int) bool {
-	switch code {
-	case http.StatusRequestTimeout, // 408
-		http.StatusTooManyRequests, // 429
-		http.StatusUnauthorized,    // 401
-		http.StatusForbidden:       // 403
-		return true
-	}
-	return code >= 500 && code < 600
+// httpPostFormJSON sends a POST request to the Synology NAS API with the parameters as a form-encoded body,
+// with the same retry logic as httpGetJSON. Use it for requests whose parameters include secrets.
+func (s *SynologySession) httpPostFormJSON(endpoint string, params map[string]string) (*http.Response, error) {
+	return s.httpJSONWithRetry(http.MethodPost, endpoint, params, s.retryPolicy, &realSleeper{})
 }
 
-// httpGetJSONWithRetry sends a GET request with retry logic
-// Retries on network errors, HTTP 5xx (server) errors, and selected 4xx errors (see isRetryableStatus).
+// httpGetJSONWithRetry sends a GET request, retried according to policy.
+// Retries on network errors, and on responses with an HTTP status or Synology error code retried by the policy.
 // Only sleeps between retries, not before the first attempt.
-// Returns the first successful response, or error after all retries.
-func (s *SynologySession) httpGetJSONWithRetry(endpoint string, params map[string]string, maxRetries int, retryDelay time.Duration, sleeper sleeper) (*http.Response, error) {
+// Returns the first response that is not retried, or error after all attempts failed.
+func (s *SynologySession) httpGetJSONWithRetry(endpoint string, params map[string]string, policy RetryPolicy, sleeper sleeper) (*http.Response, error) {
+	return s.httpJSONWithRetry(http.MethodGet, endpoint, params, policy, sleeper)
+}
+
+// httpJSONWithRetry sends a request with the given HTTP method and the retry logic of httpGetJSONWithRetry.
+// The request, including any body, is rebuilt for each attempt. A response whose Synology error code is
+// retryable is returned as is after the last attempt, so that the caller reports the Synology error.
+func (s *SynologySession) httpJSONWithRetry(method string, endpoint string, params map[string]string, policy RetryPolicy, sleeper sleeper) (*http.Response, error) {
+	maxAttempts := max(policy.MaxAttempts, 1)
 	var lastErr error
+	var delay time.Duration
 
-	for attempt := 0; attempt <= maxRetries; attempt++ {
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
 		// Only sleep between retries, not before the first attempt
-		if attempt > 0 {
-			sleeper.Sleep(retryDelay)
+		if attempt > 1 {
+			s.observeAPIRetry(APIName(params["api"]), params["method"])
+			s.logRetry(APIName(params["api"]), params["method"], attempt, maxAttempts, delay, lastErr)
+			sleeper.Sleep(delay)
 		}
+		delay = policy.delay(attempt, rand.Float64)
 
-		resp, err := s.httpGetJSONDirect(endpoint, params)
-		if err == nil {
-			// Retry on HTTP 5xx and selected 4xx errors
-			if isRetryableStatus(resp.StatusCode) {
-				lastErr = fmt.Errorf("retryable error: %d %s", resp.StatusCode, resp.Status)
-				resp.Body.Close()
-				continue
+		s.apiLimiter.wait(1)
+		resp, err := s.httpRequest(method, endpoint, params, RequestOptionJSON)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if policy.retryStatus(resp.StatusCode) {
+			lastErr = fmt.Errorf("retryable error: %d %s", resp.StatusCode, resp.Status)
+			if retryAfter, ok := policy.retryAfter(resp, time.Now()); ok {
+				delay = retryAfter
 			}
-			return resp, nil
+			resp.Body.Close()
+			continue
 		}
-
-		lastErr = err
+		code, err := peekSynologyErrorCode(resp)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if code != 0 && policy.retrySynologyCode(code) && attempt < maxAttempts {
+			lastErr = SynologyError(fmt.Sprintf("NAS busy [code=%d]", code))
+			continue
+		}
+		return resp, nil
 	}
 
-	return nil, fmt.Errorf("after %d attempts, last error: %w", maxRetries+1, lastErr)
+	return nil, fmt.Errorf("after %d attempts, last error: %w", maxAttempts, lastErr)
 }
 
 // apiRequest represents a Synology API request with its required parameters
@@ -243,6 +290,7 @@ type apiRequest struct {
 	method  string            // API method (e.g., "list", "get")
 	version string            // API version (e.g., "1", "2", "3")
 	params  map[string]string // Additional parameters
+	post    bool              // Send the parameters in a POST body instead of the URL, for requests carrying secrets
 }
 
 // callAPI handles an API call with required parameters explicitly defined.
@@ -273,12 +321,34 @@ func (s *SynologySession) callAPI(req apiRequest, synRes SynologyResponse, error
 		endpoint = "auth.cgi"
 	}
 
-	httpResponse, err := s.httpGetJSON(endpoint, params)
+	start := time.Now()
+	send := s.httpGetJSON
+	if req.post {
+		send = s.httpPostFormJSON
+	}
+	httpResponse, err := send(endpoint, params)
 	if err != nil {
+		s.observeAPICall(req.api, req.method, start, err)
 		return nil, err
 	}
 
-	return s.processAPIResponse(httpResponse, synRes, errorContext)
+	body, err := s.processAPIResponse(httpResponse, synRes, errorContext)
+	s.observeAPICall(req.api, req.method, start, err)
+	return body, err
+}
+
+// observeAPICall notifies the observer, if any, of a completed call of method of api that started at start.
+func (s *SynologySession) observeAPICall(api APIName, method string, start time.Time, err error) {
+	if s.observer != nil {
+		s.observer.ObserveAPICall(api, method, time.Since(start), err)
+	}
+}
+
+// observeAPIRetry notifies the observer, if any, of a retried request of method of api.
+func (s *SynologySession) observeAPIRetry(api APIName, method string) {
+	if s.observer != nil {
+		s.observer.ObserveAPIRetry(api, method)
+	}
 }
 
 // processAPIResponse processes the API response, unmarshals the JSON, and checks if it was successful
@@ -296,11 +366,15 @@ func (s *SynologySession) processAPIResponse(response *http.Response, synRes Syn
 	defer response.Body.Close()
 	body, err := io.ReadAll(response.Body)
 	if err != nil {
-		return nil, HttpError(err.Error())
+		return nil, newHttpError(err)
 	}
 
 	// Unmarshal the JSON
+	unauthorized := response.StatusCode == http.StatusUnauthorized
 	if err := json.Unmarshal(body, synRes); err != nil {
+		if unauthorized {
+			return body, SessionExpiredError(fmt.Sprintf("%s failed: %s", errorContext, response.Status))
+		}
 		return body, SynologyError(err.Error())
 	}
 
@@ -308,11 +382,16 @@ func (s *SynologySession) processAPIResponse(response *http.Response, synRes Syn
 		// Get error information
 		err := synRes.GetError()
 
+		msg := fmt.Sprintf("%s failed: [code=%d]", errorContext, err.Code)
 		if err.Errors.Message != "" {
-			return body, SynologyError(fmt.Sprintf("%s failed: %s [code=%d, line=%d]",
-				errorContext, err.Errors.Message, err.Code, err.Errors.Line))
+			msg = fmt.Sprintf("%s failed: %s [code=%d, line=%d]",
+				errorContext, err.Errors.Message, err.Code, err.Errors.Line)
+		}
+		// The NAS rejects an expired session ID with one of the session codes, or with 401 Unauthorized.
+		if unauthorized || sessionExpiredCode(err.Code) {
+			return body, SessionExpiredError(msg)
 		}
-		return body, SynologyError(fmt.Sprintf("%s failed: [code=%d]", errorContext, err.Code))
+		return body, SynologyError(msg)
 	}
 
 	return body, nil
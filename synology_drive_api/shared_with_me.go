@@ -28,7 +28,7 @@ type SharedWithMeResponse struct {
 
 // SharedWithMe retrieves a paginated list of files and folders shared with the user.
 //   - offset: The starting position (0-based)
-//   - limit: Maximum number of items to return (1-DefaultMaxPageSize)
+//   - limit: Maximum number of items to return (must be > 0 and <= session's maxPageSize)
 //   - Returns a SharedWithMeResponse containing the list of shared items and their details,
 //     or an error if the API request fails.
 func (s *SynologySession) SharedWithMe(offset, limit int64) (*SharedWithMeResponse, error) {
@@ -36,8 +36,8 @@ func (s *SynologySession) SharedWithMe(offset, limit int64) (*SharedWithMeRespon
 	if offset < 0 {
 		return nil, fmt.Errorf("offset must be >= 0, got %d", offset)
 	}
-	if limit <= 0 || limit > DefaultMaxPageSize {
-		return nil, fmt.Errorf("limit must be between 1 and %d, got %d", DefaultMaxPageSize, limit)
+	if limit <= 0 || limit > s.maxPageSize {
+		return nil, fmt.Errorf("limit must be between 1 and %d, got %d", s.maxPageSize, limit)
 	}
 
 	req := apiRequest{
@@ -62,7 +62,7 @@ func (j *jsonTeamFolderListItemV1) toTeamFolderResponseItem() *TeamFolderRespons
 // or an error if the API request fails.
 // TeamFolder retrieves a paginated list of team folders from the Synology Drive API.
 //   - offset: The starting position (0-based)
-//   - limit: Maximum number of items to return (1-DefaultMaxPageSize)
+//   - limit: Maximum number of items to return (must be > 0 and <= session's maxPageSize)
 //   - Returns a TeamFolderResponse containing the list of team folders and their details,
 //     or an error if the API request fails.
 func (s *SynologySession) TeamFolder(offset, limit int64) (*TeamFolderResponse, error) {
@@ -70,8 +70,8 @@ func (s *SynologySession) TeamFolder(offset, limit int64) (*TeamFolderResponse,
 	if offset < 0 {
 		return nil, fmt.Errorf("offset must be >= 0, got %d", offset)
 	}
-	if limit <= 0 || limit > DefaultMaxPageSize {
-		return nil, fmt.Errorf("limit must be between 1 and %d, got %d", DefaultMaxPageSize, limit)
+	if limit <= 0 || limit > s.maxPageSize {
+		return nil, fmt.Errorf("limit must be between 1 and %d, got %d", s.maxPageSize, limit)
 	}
 
 	req := apiRequest{
@@ -0,0 +1,170 @@
+package synology_drive_api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// pinPrefix marks a base64-encoded pin, as in the pin-sha256 directive of HTTP Public Key Pinning.
+const pinPrefix = "sha256/"
+
+// tlsOptions holds the TLS settings of a session. The zero value uses the system roots and Go's defaults.
+type tlsOptions struct {
+	caFile     string   // PEM file of CA certificates trusted in addition to the system roots
+	certFile   string   // PEM file of the client certificate for mutual TLS
+	keyFile    string   // PEM file of the private key of the client certificate
+	pins       []string // SHA-256 pins of the server certificate public keys, as given to WithCertificatePins
+	minVersion uint16   // Minimum TLS version; 0 means Go's default
+	insecure   bool     // Skip verification of the server certificate chain and host name
+}
+
+// TLSConfigError is returned by NewSynologySession when the TLS options cannot be applied,
+// for example because a certificate file cannot be read.
+type TLSConfigError string
+
+// Error returns a formatted error message for TLSConfigError
+func (e TLSConfigError) Error() string {
+	return "invalid TLS configuration: " + string(e)
+}
+
+// WithCAFile trusts the CA certificates in the PEM file at path, in addition to the system roots.
+// Use it for a NAS with a certificate from an internal CA.
+func WithCAFile(path string) SessionOption {
+	return func(s *SynologySession) {
+		s.tls.caFile = path
+	}
+}
+
+// WithClientCertificate presents the certificate in certFile, with the private key in keyFile, to a NAS
+// or reverse proxy that requires mutual TLS. Both files are PEM encoded.
+func WithClientCertificate(certFile, keyFile string) SessionOption {
+	return func(s *SynologySession) {
+		s.tls.certFile = certFile
+		s.tls.keyFile = keyFile
+	}
+}
+
+// WithCertificatePins accepts the server only if the public key of one of the certificates it presents has one
+// of the given SHA-256 hashes. A pin is either "sha256/" followed by the base64 hash, as printed by
+//
+//	openssl x509 -in cert.pem -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+//
+// or the hash in hex, optionally with colons. Pins are checked in addition to the usual verification,
+// and also when it is disabled by WithInsecureSkipVerify.
+func WithCertificatePins(pins ...string) SessionOption {
+	return func(s *SynologySession) {
+		s.tls.pins = append(s.tls.pins, pins...)
+	}
+}
+
+// WithMinTLSVersion sets the minimum TLS version, e.g. tls.VersionTLS13.
+func WithMinTLSVersion(version uint16) SessionOption {
+	return func(s *SynologySession) {
+		s.tls.minVersion = version
+	}
+}
+
+// WithInsecureSkipVerify disables verification of the server certificate chain and host name.
+// The connection is then open to man-in-the-middle attacks unless certificate pins are set;
+// only use it for lab NASes with self-signed certificates.
+func WithInsecureSkipVerify() SessionOption {
+	return func(s *SynologySession) {
+		s.tls.insecure = true
+	}
+}
+
+// isSet reports whether any option differs from the defaults.
+func (o *tlsOptions) isSet() bool {
+	return o.caFile != "" || o.certFile != "" || o.keyFile != "" || len(o.pins) > 0 || o.minVersion != 0 || o.insecure
+}
+
+// config returns the TLS configuration for the options.
+func (o *tlsOptions) config() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         o.minVersion,
+		InsecureSkipVerify: o.insecure,
+	}
+	if o.caFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(o.caFile)
+		if err != nil {
+			return nil, TLSConfigError(fmt.Sprintf("failed to read CA file: %v", err))
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, TLSConfigError(fmt.Sprintf("no PEM certificates found in CA file %q", o.caFile))
+		}
+		cfg.RootCAs = pool
+	}
+	if o.certFile != "" || o.keyFile != "" {
+		if o.certFile == "" || o.keyFile == "" {
+			return nil, TLSConfigError("a client certificate needs both a certificate and a key file")
+		}
+		cert, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+		if err != nil {
+			return nil, TLSConfigError(fmt.Sprintf("failed to load client certificate: %v", err))
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if len(o.pins) > 0 {
+		pins := make([][]byte, 0, len(o.pins))
+		for _, pin := range o.pins {
+			hash, err := parsePin(pin)
+			if err != nil {
+				return nil, err
+			}
+			pins = append(pins, hash)
+		}
+		cfg.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyPins(state.PeerCertificates, pins)
+		}
+	}
+	return cfg, nil
+}
+
+// parsePin decodes a pin given to WithCertificatePins into the SHA-256 hash it names.
+func parsePin(pin string) ([]byte, error) {
+	var hash []byte
+	var err error
+	if encoded, ok := strings.CutPrefix(pin, pinPrefix); ok {
+		hash, err = base64.StdEncoding.DecodeString(encoded)
+	} else {
+		hash, err = hex.DecodeString(strings.ReplaceAll(pin, ":", ""))
+	}
+	if err != nil || len(hash) != sha256.Size {
+		return nil, TLSConfigError(fmt.Sprintf("invalid certificate pin %q: want %q followed by a base64 SHA-256 hash, or the hash in hex", pin, pinPrefix))
+	}
+	return hash, nil
+}
+
+// verifyPins returns an error unless the public key of one of certs has one of the pinned hashes.
+func verifyPins(certs []*x509.Certificate, pins [][]byte) error {
+	for _, cert := range certs {
+		hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, pin := range pins {
+			if bytes.Equal(hash[:], pin) {
+				return nil
+			}
+		}
+	}
+	if len(certs) == 0 {
+		return fmt.Errorf("server presented no certificate to check against the pins")
+	}
+	return fmt.Errorf("server certificate does not match any pin (its public key is %s%s)",
+		pinPrefix, SPKIPin(certs[0]))
+}
+
+// SPKIPin returns the base64 SHA-256 hash of the public key of cert, as used in a "sha256/" pin.
+func SPKIPin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
@@ -0,0 +1,134 @@
+package synology_drive_api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writePEM writes a PEM block of the given type to a new file in dir and returns its path.
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+	return path
+}
+
+// newClientCertificate writes a self-signed client certificate and its key to dir and returns their paths.
+func newClientCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return writePEM(t, dir, "client.pem", "CERTIFICATE", der), writePEM(t, dir, "client-key.pem", "PRIVATE KEY", keyDER)
+}
+
+// newTLSTestServer starts a TLS server answering every request with an empty successful response.
+func newTLSTestServer(t *testing.T, configure func(*tls.Config)) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"success": true}`))
+	}))
+	srv.TLS = &tls.Config{}
+	if configure != nil {
+		configure(srv.TLS)
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// tlsGet sends a request through a session for srv created with options.
+func tlsGet(t *testing.T, srv *httptest.Server, options ...SessionOption) error {
+	t.Helper()
+	s, err := NewSynologySession("user", "pass", srv.URL, options...)
+	require.NoError(t, err)
+	resp, err := s.httpGet("entry.cgi", nil, RequestOption{})
+	if err == nil {
+		resp.Body.Close()
+	}
+	return err
+}
+
+func TestTLSOptions(t *testing.T) {
+	dir := t.TempDir()
+	srv := newTLSTestServer(t, nil)
+	caFile := writePEM(t, dir, "ca.pem", "CERTIFICATE", srv.Certificate().Raw)
+	pin := pinPrefix + SPKIPin(srv.Certificate())
+	hash := sha256.Sum256(srv.Certificate().RawSubjectPublicKeyInfo)
+	wrongPin := pinPrefix + "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+
+	t.Run("system roots reject internal CA", func(t *testing.T) {
+		assert.Error(t, tlsGet(t, srv))
+	})
+	t.Run("CA file", func(t *testing.T) {
+		assert.NoError(t, tlsGet(t, srv, WithCAFile(caFile)))
+	})
+	t.Run("insecure", func(t *testing.T) {
+		assert.NoError(t, tlsGet(t, srv, WithInsecureSkipVerify()))
+	})
+	t.Run("pins", func(t *testing.T) {
+		assert.NoError(t, tlsGet(t, srv, WithCAFile(caFile), WithCertificatePins(wrongPin, pin)))
+		assert.NoError(t, tlsGet(t, srv, WithInsecureSkipVerify(), WithCertificatePins(hex.EncodeToString(hash[:]))))
+		err := tlsGet(t, srv, WithInsecureSkipVerify(), WithCertificatePins(wrongPin))
+		require.Error(t, err, "pins are checked even without verification")
+		assert.Contains(t, err.Error(), pin, "the error names the actual pin")
+	})
+	t.Run("minimum version", func(t *testing.T) {
+		old := newTLSTestServer(t, func(c *tls.Config) { c.MaxVersion = tls.VersionTLS12 })
+		assert.NoError(t, tlsGet(t, old, WithInsecureSkipVerify(), WithMinTLSVersion(tls.VersionTLS12)))
+		assert.Error(t, tlsGet(t, old, WithInsecureSkipVerify(), WithMinTLSVersion(tls.VersionTLS13)))
+	})
+	t.Run("client certificate", func(t *testing.T) {
+		mtls := newTLSTestServer(t, func(c *tls.Config) { c.ClientAuth = tls.RequireAnyClientCert })
+		certFile, keyFile := newClientCertificate(t, dir)
+		assert.NoError(t, tlsGet(t, mtls, WithInsecureSkipVerify(), WithClientCertificate(certFile, keyFile)))
+		assert.Error(t, tlsGet(t, mtls, WithInsecureSkipVerify()))
+	})
+}
+
+func TestTLSOptionsErrors(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "not.pem")
+	require.NoError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0600))
+	certFile, _ := newClientCertificate(t, dir)
+
+	for name, option := range map[string]SessionOption{
+		"missing CA file":    WithCAFile(filepath.Join(dir, "missing.pem")),
+		"CA file not PEM":    WithCAFile(notPEM),
+		"certificate no key": WithClientCertificate(certFile, ""),
+		"unreadable key":     WithClientCertificate(certFile, notPEM),
+		"invalid pin":        WithCertificatePins("sha256/short"),
+		"invalid hex pin":    WithCertificatePins("zz"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewSynologySession("user", "pass", "https://nas.example.com:5001", option)
+			var tlsErr TLSConfigError
+			assert.ErrorAs(t, err, &tlsErr)
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package synology_drive_api
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Defaults of the HTTP transport built by NewSynologySession. The connection pool is sized for many small
+// API calls to a single NAS, possibly from several goroutines; there is no overall request timeout by default
+// because exporting a large file may take long.
+const (
+	DefaultDialTimeout           = 30 * time.Second
+	DefaultTLSHandshakeTimeout   = 10 * time.Second
+	DefaultResponseHeaderTimeout = 2 * time.Minute
+	DefaultMaxIdleConnsPerHost   = 16
+	DefaultIdleConnTimeout       = 90 * time.Second
+)
+
+// transportOptions holds the HTTP transport settings of a session. Zero values mean the defaults.
+type transportOptions struct {
+	client                *http.Client                                // Client to send requests with, if set by WithHTTPClient
+	roundTripper          http.RoundTripper                           // Transport replacing the built one, if set by WithRoundTripper
+	wrappers              []func(http.RoundTripper) http.RoundTripper // Middleware applied around the transport, innermost first
+	proxy                 *url.URL                                    // Proxy for all requests; nil means HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	dialTimeout           time.Duration
+	tlsHandshakeTimeout   time.Duration
+	responseHeaderTimeout time.Duration
+	requestTimeout        time.Duration // Overall time limit of a request, including reading the body; 0 means none
+	maxIdleConnsPerHost   int
+	idleConnTimeout       time.Duration
+}
+
+// TransportConfigError is returned by NewSynologySession when the transport options contradict each other,
+// for example when a custom RoundTripper is combined with options that configure the built one.
+type TransportConfigError string
+
+// Error returns a formatted error message for TransportConfigError
+func (e TransportConfigError) Error() string {
+	return "invalid HTTP transport configuration: " + string(e)
+}
+
+// WithHTTPClient sends requests with a copy of client. A cookie jar is added if the client has none.
+// If the client has a Transport, the options configuring the built transport (TLS, proxy, timeouts other
+// than WithRequestTimeout, connection pool) cannot be used.
+func WithHTTPClient(client *http.Client) SessionOption {
+	return func(s *SynologySession) {
+		s.transport.client = client
+	}
+}
+
+// WithRoundTripper sends requests through rt instead of the transport built from the other options,
+// which cannot be used together with it.
+func WithRoundTripper(rt http.RoundTripper) SessionOption {
+	return func(s *SynologySession) {
+		s.transport.roundTripper = rt
+	}
+}
+
+// WithRoundTripperMiddleware wraps the transport of the session, e.g. to add headers or log requests.
+// Middleware given first is closest to the network.
+func WithRoundTripperMiddleware(wrap func(http.RoundTripper) http.RoundTripper) SessionOption {
+	return func(s *SynologySession) {
+		s.transport.wrappers = append(s.transport.wrappers, wrap)
+	}
+}
+
+// WithProxy sends all requests through the proxy at proxyURL, which may be an http, https or socks5 URL.
+// Without it, the proxy is taken from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func WithProxy(proxyURL *url.URL) SessionOption {
+	return func(s *SynologySession) {
+		s.transport.proxy = proxyURL
+	}
+}
+
+// WithDialTimeout limits the time to establish a TCP connection (default DefaultDialTimeout).
+func WithDialTimeout(d time.Duration) SessionOption {
+	return func(s *SynologySession) {
+		s.transport.dialTimeout = d
+	}
+}
+
+// WithTLSHandshakeTimeout limits the time of the TLS handshake (default DefaultTLSHandshakeTimeout).
+func WithTLSHandshakeTimeout(d time.Duration) SessionOption {
+	return func(s *SynologySession) {
+		s.transport.tlsHandshakeTimeout = d
+	}
+}
+
+// WithResponseHeaderTimeout limits the time to wait for the response headers after sending a request
+// (default DefaultResponseHeaderTimeout). The NAS converts documents before answering an export request,
+// so keep it well above the conversion time of the largest file.
+func WithResponseHeaderTimeout(d time.Duration) SessionOption {
+	return func(s *SynologySession) {
+		s.transport.responseHeaderTimeout = d
+	}
+}
+
+// WithRequestTimeout limits the overall time of a request, including reading the response body.
+// There is no limit by default.
+func WithRequestTimeout(d time.Duration) SessionOption {
+	return func(s *SynologySession) {
+		s.transport.requestTimeout = d
+	}
+}
+
+// WithIdleConnections sets how many idle connections to the NAS are kept for reuse
+// (default DefaultMaxIdleConnsPerHost) and how long (default DefaultIdleConnTimeout).
+func WithIdleConnections(maxPerHost int, timeout time.Duration) SessionOption {
+	return func(s *SynologySession) {
+		s.transport.maxIdleConnsPerHost = maxPerHost
+		s.transport.idleConnTimeout = timeout
+	}
+}
+
+// tunesTransport reports whether any option configuring the built transport is set.
+func (o *transportOptions) tunesTransport() bool {
+	return o.proxy != nil || o.dialTimeout != 0 || o.tlsHandshakeTimeout != 0 || o.responseHeaderTimeout != 0 ||
+		o.maxIdleConnsPerHost != 0 || o.idleConnTimeout != 0
+}
+
+// orDefault returns v, or def if v is zero.
+func orDefault[T comparable](v, def T) T {
+	var zero T
+	if v == zero {
+		return def
+	}
+	return v
+}
+
+// newTransport returns a transport with the given TLS configuration, which may be nil, and the settings of the options.
+func (o *transportOptions) newTransport(tlsConfig *tls.Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if o.proxy != nil {
+		transport.Proxy = http.ProxyURL(o.proxy)
+	}
+	transport.DialContext = (&net.Dialer{
+		Timeout:   orDefault(o.dialTimeout, DefaultDialTimeout),
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = orDefault(o.tlsHandshakeTimeout, DefaultTLSHandshakeTimeout)
+	transport.ResponseHeaderTimeout = orDefault(o.responseHeaderTimeout, DefaultResponseHeaderTimeout)
+	transport.MaxIdleConnsPerHost = orDefault(o.maxIdleConnsPerHost, DefaultMaxIdleConnsPerHost)
+	transport.MaxIdleConns = max(transport.MaxIdleConns, transport.MaxIdleConnsPerHost)
+	transport.IdleConnTimeout = orDefault(o.idleConnTimeout, DefaultIdleConnTimeout)
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	return transport
+}
+
+// applyTransportOptions sets up the HTTP client of the session from its TLS and transport options.
+func (s *SynologySession) applyTransportOptions() error {
+	o := &s.transport
+	if o.client != nil {
+		jar := s.http_client.Jar
+		s.http_client = *o.client
+		if s.http_client.Jar == nil {
+			s.http_client.Jar = jar
+		}
+	}
+	custom := o.roundTripper
+	if custom == nil && o.client != nil {
+		custom = o.client.Transport
+	}
+
+	var rt http.RoundTripper
+	if custom != nil {
+		if s.tls.isSet() || o.tunesTransport() {
+			return TransportConfigError("TLS, proxy, timeout and connection options cannot be combined with a custom transport")
+		}
+		rt = custom
+	} else {
+		var tlsConfig *tls.Config
+		if s.tls.isSet() {
+			cfg, err := s.tls.config()
+			if err != nil {
+				return err
+			}
+			tlsConfig = cfg
+		}
+		rt = o.newTransport(tlsConfig)
+	}
+	for _, wrap := range o.wrappers {
+		rt = wrap(rt)
+	}
+	s.http_client.Transport = rt
+	if o.requestTimeout != 0 {
+		s.http_client.Timeout = o.requestTimeout
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package synology_drive_api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// roundTripperFunc adapts a function to http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// headerMiddleware returns middleware appending value to the X-Test header of each request.
+func headerMiddleware(value string) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req.Header.Add("X-Test", value)
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+func TestTransportDefaults(t *testing.T) {
+	s, err := NewSynologySession("user", "pass", "https://nas.example.com:5001")
+	require.NoError(t, err)
+	transport, ok := s.http_client.Transport.(*http.Transport)
+	require.True(t, ok)
+	assert.Equal(t, DefaultTLSHandshakeTimeout, transport.TLSHandshakeTimeout)
+	assert.Equal(t, DefaultResponseHeaderTimeout, transport.ResponseHeaderTimeout)
+	assert.Equal(t, DefaultMaxIdleConnsPerHost, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, DefaultIdleConnTimeout, transport.IdleConnTimeout)
+	assert.NotNil(t, transport.Proxy, "the proxy is taken from the environment")
+	assert.Zero(t, s.http_client.Timeout)
+	assert.NotNil(t, s.http_client.Jar)
+
+	s, err = NewSynologySession("user", "pass", "https://nas.example.com:5001",
+		WithTLSHandshakeTimeout(time.Second), WithResponseHeaderTimeout(2*time.Second),
+		WithIdleConnections(4, 3*time.Second), WithRequestTimeout(time.Minute))
+	require.NoError(t, err)
+	transport = s.http_client.Transport.(*http.Transport)
+	assert.Equal(t, time.Second, transport.TLSHandshakeTimeout)
+	assert.Equal(t, 2*time.Second, transport.ResponseHeaderTimeout)
+	assert.Equal(t, 4, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, 3*time.Second, transport.IdleConnTimeout)
+	assert.Equal(t, time.Minute, s.http_client.Timeout)
+}
+
+func TestTransportProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.Write([]byte(`{"success": true}`))
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	require.NoError(t, err)
+
+	s, err := NewSynologySession("user", "pass", "http://nas.invalid:5000", WithProxy(proxyURL))
+	require.NoError(t, err)
+	resp, err := s.httpGet("entry.cgi", map[string]string{"api": "SYNO.API.Info"}, RequestOption{})
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "http://nas.invalid:5000/webapi/entry.cgi?api=SYNO.API.Info", proxied)
+}
+
+func TestTransportCustom(t *testing.T) {
+	var headers []string
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		headers = req.Header.Values("X-Test")
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+	})
+
+	t.Run("round tripper with middleware", func(t *testing.T) {
+		s, err := NewSynologySession("user", "pass", "https://nas.example.com:5001", WithRoundTripper(rt),
+			WithRoundTripperMiddleware(headerMiddleware("inner")), WithRoundTripperMiddleware(headerMiddleware("outer")))
+		require.NoError(t, err)
+		_, err = s.httpGet("entry.cgi", nil, RequestOption{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"outer", "inner"}, headers, "middleware given first is closest to the network")
+	})
+
+	t.Run("client", func(t *testing.T) {
+		s, err := NewSynologySession("user", "pass", "https://nas.example.com:5001",
+			WithHTTPClient(&http.Client{Transport: rt, Timeout: time.Minute}))
+		require.NoError(t, err)
+		assert.Equal(t, time.Minute, s.http_client.Timeout)
+		assert.NotNil(t, s.http_client.Jar, "a cookie jar is added")
+		_, err = s.httpGet("entry.cgi", nil, RequestOption{})
+		require.NoError(t, err)
+	})
+
+	t.Run("client without transport", func(t *testing.T) {
+		s, err := NewSynologySession("user", "pass", "https://nas.example.com:5001",
+			WithHTTPClient(&http.Client{}), WithIdleConnections(4, 0))
+		require.NoError(t, err)
+		assert.Equal(t, 4, s.http_client.Transport.(*http.Transport).MaxIdleConnsPerHost)
+	})
+
+	for name, option := range map[string]SessionOption{
+		"proxy":   WithProxy(&url.URL{Scheme: "http", Host: "proxy:3128"}),
+		"timeout": WithDialTimeout(time.Second),
+		"TLS":     WithInsecureSkipVerify(),
+	} {
+		t.Run("conflicting "+name, func(t *testing.T) {
+			_, err := NewSynologySession("user", "pass", "https://nas.example.com:5001", WithRoundTripper(rt), option)
+			var transportErr TransportConfigError
+			assert.ErrorAs(t, err, &transportErr)
+		})
+	}
+}
+
+func TestTransportRequestTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	s, err := NewSynologySession("user", "pass", srv.URL, WithRequestTimeout(20*time.Millisecond))
+	require.NoError(t, err)
+	_, err = s.httpGet("entry.cgi", nil, RequestOption{})
+	var httpErr HttpError
+	assert.ErrorAs(t, err, &httpErr)
+}
@@ -0,0 +1,72 @@
+package synology_drive_api
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+)
+
+// DefaultMinExportSize is the size below which an exported file is rejected, unless changed by WithMinExportSize.
+// Office Open XML files are zip archives of several XML parts, so even an empty document is larger.
+const DefaultMinExportSize = 512
+
+// ooxmlMainParts maps the extension of an exported file to the main part its archive must contain.
+var ooxmlMainParts = map[string]string{
+	".docx": "word/document.xml",
+	".xlsx": "xl/workbook.xml",
+	".pptx": "ppt/presentation.xml",
+}
+
+// ExportValidationError is returned by Export when the downloaded content is not a valid file of the exported
+// format, for example an HTML error page or a truncated archive.
+type ExportValidationError string
+
+// Error returns a formatted error message for ExportValidationError
+func (e ExportValidationError) Error() string {
+	return "invalid exported file: " + string(e)
+}
+
+// WithMinExportSize sets the size in bytes below which an exported file is rejected (default DefaultMinExportSize).
+// 0 disables the check.
+func WithMinExportSize(size int64) SessionOption {
+	return func(s *SynologySession) {
+		s.minExportSize = size
+	}
+}
+
+// validateExport checks that content, received with contentType, is a file of the format given by the extension
+// of name. A JSON body reporting a Synology error is returned as a SynologyError; any other problem as an
+// ExportValidationError.
+func validateExport(name, contentType string, content []byte, minSize int64) error {
+	if code := synologyErrorCode(content); code != 0 {
+		return SynologyError(fmt.Sprintf("export of %s failed [code=%d]", name, code))
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil &&
+		(mediaType == "application/json" || strings.HasPrefix(mediaType, "text/")) {
+		return ExportValidationError(fmt.Sprintf("%s has Content-Type %q", name, contentType))
+	}
+	if int64(len(content)) < minSize {
+		return ExportValidationError(fmt.Sprintf("%s has only %d bytes", name, len(content)))
+	}
+	mainPart, ok := ooxmlMainParts[strings.ToLower(path.Ext(name))]
+	if !ok {
+		return nil
+	}
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return ExportValidationError(fmt.Sprintf("%s is not a zip archive: %v", name, err))
+	}
+	parts := make(map[string]bool, len(archive.File))
+	for _, f := range archive.File {
+		parts[f.Name] = true
+	}
+	for _, part := range []string{"[Content_Types].xml", mainPart} {
+		if !parts[part] {
+			return ExportValidationError(fmt.Sprintf("%s has no %s", name, part))
+		}
+	}
+	return nil
+}
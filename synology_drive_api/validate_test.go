@@ -0,0 +1,98 @@
+package synology_drive_api
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newArchive returns a zip archive with the given parts, each holding 1 KiB of uncompressed XML.
+func newArchive(t *testing.T, parts ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, part := range parts {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: part, Method: zip.Store})
+		require.NoError(t, err)
+		_, err = f.Write([]byte("<x>" + strings.Repeat(" ", 1017) + "</x>"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestValidateExport(t *testing.T) {
+	docx := newArchive(t, "[Content_Types].xml", "_rels/.rels", "word/document.xml")
+	const ooxml = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+
+	t.Run("valid", func(t *testing.T) {
+		assert.NoError(t, validateExport("report.docx", ooxml, docx, DefaultMinExportSize))
+		assert.NoError(t, validateExport("report.docx", "", docx, DefaultMinExportSize))
+		assert.NoError(t, validateExport("plan.xlsx", "application/octet-stream",
+			newArchive(t, "[Content_Types].xml", "xl/workbook.xml"), DefaultMinExportSize))
+		assert.NoError(t, validateExport("slides.PPTX", "", newArchive(t, "[Content_Types].xml", "ppt/presentation.xml"), DefaultMinExportSize))
+	})
+
+	t.Run("Synology error", func(t *testing.T) {
+		err := validateExport("report.docx", "application/json", []byte(`{"success": false, "error": {"code": 1002}}`), DefaultMinExportSize)
+		var synErr SynologyError
+		require.ErrorAs(t, err, &synErr)
+		assert.Contains(t, err.Error(), "code=1002")
+	})
+
+	for name, tc := range map[string]struct {
+		contentType string
+		content     []byte
+	}{
+		"HTML page":        {"text/html; charset=utf-8", append([]byte("<html>"), docx...)},
+		"JSON":             {"application/json", docx},
+		"too small":        {ooxml, []byte("PK")},
+		"not a zip":        {"", bytes.Repeat([]byte("x"), 1000)},
+		"truncated":        {ooxml, docx[:len(docx)-10]},
+		"no content types": {ooxml, newArchive(t, "word/document.xml")},
+		"wrong main part":  {ooxml, newArchive(t, "[Content_Types].xml", "xl/workbook.xml")},
+		"empty archive":    {ooxml, append(bytes.Repeat([]byte{0}, 600), newArchive(t)...)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := validateExport("report.docx", tc.contentType, tc.content, DefaultMinExportSize)
+			var validationErr ExportValidationError
+			assert.ErrorAs(t, err, &validationErr)
+		})
+	}
+}
+
+func TestExportValidatesContent(t *testing.T) {
+	xlsx := newArchive(t, "[Content_Types].xml", "xl/workbook.xml")
+	var download []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("method") == "get" {
+			w.Write(cannedResponseGetFile)
+			return
+		}
+		w.Write(download)
+	}))
+	defer srv.Close()
+
+	observer := &recordingObserver{}
+	session, err := NewSynologySession("test", "test", srv.URL, WithAPIObserver(observer))
+	require.NoError(t, err)
+
+	download = xlsx
+	res, err := session.Export("882614125167948399")
+	require.NoError(t, err)
+	assert.Equal(t, "planning.xlsx", res.Name)
+	assert.Equal(t, xlsx, res.Content)
+
+	download = []byte("<html><body>Internal error</body></html>")
+	_, err = session.Export("882614125167948399")
+	var validationErr ExportValidationError
+	require.ErrorAs(t, err, &validationErr)
+	require.Len(t, observer.errs, 4)
+	assert.ErrorAs(t, observer.errs[3], &validationErr, "a rejected file counts as a failed call")
+}
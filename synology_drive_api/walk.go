@@ -0,0 +1,158 @@
+package synology_drive_api
+
+import (
+	"io/fs"
+	"sync"
+	"sync/atomic"
+)
+
+// SkipDir and SkipAll are the io/fs values a WalkDirFunc returns to skip a folder or the rest of the walk.
+var (
+	SkipDir = fs.SkipDir
+	SkipAll = fs.SkipAll
+)
+
+// Lister lists the items of a folder page by page. SynologySession implements it.
+type Lister interface {
+	List(fileID FileID, offset, limit int64) (*ListResponse, error)
+	GetMaxPageSize() int64
+}
+
+// WalkDirFunc is the type of the function called by WalkDir to visit each item, modelled on fs.WalkDirFunc.
+//
+// The function is called with a nil err for each item before a folder is listed. Returning SkipDir for a
+// folder skips its contents; returning SkipDir for a file skips the remaining items of the folder containing it.
+// Returning SkipAll stops the walk, and WalkDir returns nil. Any other error stops the walk, and WalkDir
+// returns the error.
+//
+// If listing a folder fails, the function is called a second time for the folder with the error. Items are
+// visited as their pages arrive, so some contents of the folder may have been visited already. Returning nil
+// or SkipDir continues the walk with the next item after the folder.
+type WalkDirFunc func(item *ResponseItem, err error) error
+
+// walker holds the state of a walk started by WalkDir.
+type walker struct {
+	lister   Lister
+	fn       WalkDirFunc
+	maxDepth int           // Depth below which folders are not listed; negative for no limit
+	sem      chan struct{} // Slots for folders walked by other goroutines; nil if the walk is sequential
+	wg       sync.WaitGroup
+	stopped  atomic.Bool
+	errOnce  sync.Once
+	err      error
+}
+
+// WalkOption configures WalkDir.
+type WalkOption func(*walker)
+
+// WithMaxDepth limits the walk to items at most depth levels below the root. The children of the root are at
+// depth 1, and a depth of 0 visits only the root. Folders at the maximum depth are visited but not listed.
+func WithMaxDepth(depth int) WalkOption {
+	return func(w *walker) {
+		w.maxDepth = depth
+	}
+}
+
+// WithWalkConcurrency lets up to n goroutines list and walk folders at once. The function passed to WalkDir
+// is then called from several goroutines and must be safe for concurrent use, and the items of different
+// folders are visited in no particular order. SkipDir returned for a file still skips the remaining items of
+// its folder, but not the subfolders of that folder already being walked. n of 1 or less walks sequentially.
+func WithWalkConcurrency(n int) WalkOption {
+	return func(w *walker) {
+		if n > 1 {
+			w.sem = make(chan struct{}, n-1)
+		}
+	}
+}
+
+// WalkDir walks the tree rooted at root, calling fn for each item in the tree, including root. Folders are
+// listed with l page by page as the walk proceeds. Unless WithWalkConcurrency is given, the items are visited
+// in the order returned by List, and a folder's contents are visited before the items following it.
+func WalkDir(l Lister, root *ResponseItem, fn WalkDirFunc, opts ...WalkOption) error {
+	w := &walker{lister: l, fn: fn, maxDepth: -1}
+	for _, opt := range opts {
+		opt(w)
+	}
+	w.finish(w.walk(root, 0))
+	w.wg.Wait()
+	if w.err == SkipAll {
+		return nil
+	}
+	return w.err
+}
+
+// Walk walks the folder with ID rootID listed with l, as WalkDir does. The root item passed to fn only has its
+// Type and FileID set.
+func Walk(l Lister, rootID FileID, fn WalkDirFunc, opts ...WalkOption) error {
+	return WalkDir(l, &ResponseItem{Type: ObjectTypeDirectory, FileID: rootID}, fn, opts...)
+}
+
+// Walk walks the folder with ID rootID, as the package-level Walk does.
+func (s *SynologySession) Walk(rootID FileID, fn WalkDirFunc, opts ...WalkOption) error {
+	return Walk(s, rootID, fn, opts...)
+}
+
+// walk visits item at depth and, if it is a folder, its contents.
+func (w *walker) walk(item *ResponseItem, depth int) error {
+	if w.stopped.Load() {
+		return SkipAll
+	}
+	isDir := item.Type == ObjectTypeDirectory
+	if err := w.fn(item, nil); err != nil || !isDir {
+		if err == SkipDir && isDir {
+			err = nil
+		}
+		return err
+	}
+	if w.maxDepth >= 0 && depth >= w.maxDepth {
+		return nil
+	}
+	for child, err := range listItems(w.lister, item.FileID) {
+		if err != nil {
+			if w.stopped.Load() {
+				return SkipAll
+			}
+			if err := w.fn(item, err); err != SkipDir {
+				return err
+			}
+			return nil
+		}
+		if child.Type == ObjectTypeDirectory && w.spawn(child, depth+1) {
+			continue
+		}
+		if err := w.walk(child, depth+1); err != nil {
+			if err == SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// spawn walks dir in a new goroutine if a slot is free, and reports whether it did.
+func (w *walker) spawn(dir *ResponseItem, depth int) bool {
+	select {
+	case w.sem <- struct{}{}:
+	default:
+		return false
+	}
+	w.wg.Add(1)
+	go func() {
+		defer func() {
+			<-w.sem
+			w.wg.Done()
+		}()
+		w.finish(w.walk(dir, depth))
+	}()
+	return true
+}
+
+// finish records the result of walking a subtree, stopping the walk on the first error other than SkipDir.
+func (w *walker) finish(err error) {
+	if err == nil || err == SkipDir {
+		return
+	}
+	w.errOnce.Do(func() { w.err = err })
+	w.stopped.Store(true)
+}
@@ -0,0 +1,221 @@
+package synology_drive_api
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// treeLister lists an in-memory tree whose folders are keyed by file ID, two items per page.
+type treeLister struct {
+	mu      sync.Mutex
+	folders map[FileID][]*ResponseItem
+	errs    map[FileID]error
+	lists   []FileID
+}
+
+func (l *treeLister) List(fileID FileID, offset, limit int64) (*ListResponse, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lists = append(l.lists, fileID)
+	if err := l.errs[fileID]; err != nil && offset > 0 {
+		return nil, err
+	}
+	items := l.folders[fileID]
+	return &ListResponse{Items: items[min(offset, int64(len(items))):min(offset+limit, int64(len(items)))], Total: int64(len(items))}, nil
+}
+
+func (l *treeLister) GetMaxPageSize() int64 { return 2 }
+
+// newTreeLister returns a lister for the tree with the given paths; paths ending in "/" are folders.
+// The ID of each item is its path without the trailing slash, and the root folder has ID "/".
+func newTreeLister(paths ...string) *treeLister {
+	l := &treeLister{folders: map[FileID][]*ResponseItem{}}
+	for _, p := range paths {
+		item := &ResponseItem{Type: ObjectTypeFile, DisplayPath: path.Clean(p), FileID: FileID(path.Clean(p))}
+		if p[len(p)-1] == '/' {
+			item.Type = ObjectTypeDirectory
+		}
+		parent := FileID(path.Dir(item.DisplayPath))
+		l.folders[parent] = append(l.folders[parent], item)
+	}
+	return l
+}
+
+var testTreeRoot = &ResponseItem{Type: ObjectTypeDirectory, FileID: "/", DisplayPath: "/"}
+
+// walkPaths walks l from the test root and returns the visited paths and the result of WalkDir.
+func walkPaths(l Lister, fn func(item *ResponseItem, err error) error, opts ...WalkOption) ([]string, error) {
+	var mu sync.Mutex
+	var visited []string
+	err := WalkDir(l, testTreeRoot, func(item *ResponseItem, err error) error {
+		mu.Lock()
+		if err == nil {
+			visited = append(visited, item.DisplayPath)
+		}
+		mu.Unlock()
+		if fn != nil {
+			return fn(item, err)
+		}
+		return err
+	}, opts...)
+	return visited, err
+}
+
+func TestWalkDir(t *testing.T) {
+	tree := []string{"/a/", "/a/1.odoc", "/a/b/", "/a/b/2.odoc", "/a/3.odoc", "/c.odoc", "/d/", "/d/4.odoc"}
+
+	t.Run("visits items in order", func(t *testing.T) {
+		visited, err := walkPaths(newTreeLister(tree...), nil)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/", "/a", "/a/1.odoc", "/a/b", "/a/b/2.odoc", "/a/3.odoc", "/c.odoc", "/d", "/d/4.odoc"}, visited)
+	})
+
+	t.Run("SkipDir on a folder", func(t *testing.T) {
+		l := newTreeLister(tree...)
+		visited, err := walkPaths(l, func(item *ResponseItem, err error) error {
+			if item.DisplayPath == "/a" {
+				return SkipDir
+			}
+			return err
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/", "/a", "/c.odoc", "/d", "/d/4.odoc"}, visited)
+		assert.NotContains(t, l.lists, FileID("/a"), "a skipped folder is not listed")
+	})
+
+	t.Run("SkipDir on a file", func(t *testing.T) {
+		visited, err := walkPaths(newTreeLister(tree...), func(item *ResponseItem, err error) error {
+			if item.DisplayPath == "/a/1.odoc" {
+				return SkipDir
+			}
+			return err
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/", "/a", "/a/1.odoc", "/c.odoc", "/d", "/d/4.odoc"}, visited)
+	})
+
+	t.Run("SkipAll", func(t *testing.T) {
+		l := newTreeLister(tree...)
+		visited, err := walkPaths(l, func(item *ResponseItem, err error) error {
+			if item.DisplayPath == "/a/1.odoc" {
+				return SkipAll
+			}
+			return err
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/", "/a", "/a/1.odoc"}, visited)
+		assert.Equal(t, []FileID{"/", "/a"}, l.lists, "no page is fetched after the walk stops")
+	})
+
+	t.Run("error is returned", func(t *testing.T) {
+		stop := errors.New("stop")
+		visited, err := walkPaths(newTreeLister(tree...), func(item *ResponseItem, err error) error {
+			if item.DisplayPath == "/a/b" {
+				return stop
+			}
+			return err
+		})
+		assert.ErrorIs(t, err, stop)
+		assert.Equal(t, []string{"/", "/a", "/a/1.odoc", "/a/b"}, visited)
+	})
+
+	t.Run("listing errors", func(t *testing.T) {
+		listErr := errors.New("connection reset")
+		l := newTreeLister(tree...)
+		l.errs = map[FileID]error{"/a": listErr}
+		var failed []FileID
+		visited, err := walkPaths(l, func(item *ResponseItem, err error) error {
+			if err != nil {
+				assert.ErrorIs(t, err, listErr)
+				failed = append(failed, item.FileID)
+				return SkipDir
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []FileID{"/a"}, failed)
+		assert.Equal(t, []string{"/", "/a", "/a/1.odoc", "/a/b", "/a/b/2.odoc", "/c.odoc", "/d", "/d/4.odoc"}, visited,
+			"the first page of /a is visited before the second one fails")
+
+		_, err = walkPaths(l, nil)
+		assert.ErrorIs(t, err, listErr, "the error is returned if fn returns it")
+	})
+
+	t.Run("maximum depth", func(t *testing.T) {
+		l := newTreeLister(tree...)
+		visited, err := walkPaths(l, nil, WithMaxDepth(1))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/", "/a", "/c.odoc", "/d"}, visited)
+		assert.Equal(t, []FileID{"/", "/"}, l.lists, "only the two pages of the root are listed")
+
+		visited, err = walkPaths(newTreeLister(tree...), nil, WithMaxDepth(0))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/"}, visited)
+	})
+
+	t.Run("file root", func(t *testing.T) {
+		var visited []FileID
+		err := WalkDir(newTreeLister(), &ResponseItem{Type: ObjectTypeFile, FileID: "f"}, func(item *ResponseItem, err error) error {
+			visited = append(visited, item.FileID)
+			return err
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []FileID{"f"}, visited)
+	})
+}
+
+func TestWalkDirConcurrent(t *testing.T) {
+	var tree []string
+	for _, dir := range []string{"/a/", "/b/", "/c/", "/d/"} {
+		tree = append(tree, dir, dir+"x/", dir+"x/1.odoc", dir+"2.odoc", dir+"3.odoc")
+	}
+
+	sequential, err := walkPaths(newTreeLister(tree...), nil)
+	require.NoError(t, err)
+	concurrent, err := walkPaths(newTreeLister(tree...), nil, WithWalkConcurrency(4))
+	require.NoError(t, err)
+	sort.Strings(sequential)
+	sort.Strings(concurrent)
+	assert.Equal(t, sequential, concurrent)
+
+	stop := errors.New("stop")
+	_, err = walkPaths(newTreeLister(tree...), func(item *ResponseItem, err error) error {
+		if item.DisplayPath == "/c/x/1.odoc" {
+			return stop
+		}
+		return err
+	}, WithWalkConcurrency(4))
+	assert.ErrorIs(t, err, stop)
+}
+
+func TestWalk(t *testing.T) {
+	var visited []FileID
+	err := Walk(newTreeLister("/a/", "/a/1.odoc", "/b.odoc"), "/", func(item *ResponseItem, err error) error {
+		visited = append(visited, item.FileID)
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []FileID{"/", "/a", "/a/1.odoc", "/b.odoc"}, visited)
+}
+
+func TestSessionWalk(t *testing.T) {
+	srv, _ := scriptedServer(t,
+		`200 {"success": true, "data": {"total": 2, "items": [{"file_id": "1", "name": "a.odoc"}, {"file_id": "2", "name": "b.odoc"}]}}`,
+	)
+	session, err := NewSynologySession("test", "test", srv.URL)
+	require.NoError(t, err)
+
+	var visited []FileID
+	err = session.Walk(MyDrive, func(item *ResponseItem, err error) error {
+		visited = append(visited, item.FileID)
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []FileID{MyDrive, "1", "2"}, visited)
+}
@@ -0,0 +1,64 @@
+package synology_drive_exporter
+
+import (
+	"sync"
+	"time"
+
+	dh "github.com/isseis/go-synology-office-exporter/download_history"
+)
+
+// checkpointer saves the download history of a running export periodically,
+// so that an interrupted run can be resumed without exporting the same files again.
+// It is safe for concurrent use.
+type checkpointer struct {
+	mu       sync.Mutex // Guards pending and last
+	history  *dh.DownloadHistory
+	run      dh.InterruptedRun
+	resumed  bool          // The loaded history was checkpointed by an unfinished run
+	every    int           // Save after this many downloads; 0 disables the count trigger
+	interval time.Duration // Save when this much time has passed since the last save; 0 disables the time trigger
+	pending  int           // Downloads since the last save
+	last     time.Time     // Time of the last save, or of the start of the run
+	now      func() time.Time
+}
+
+// newCheckpointer prepares checkpoints of history for the run identified by runID.
+// If history was loaded from a checkpoint of an unfinished run, the new checkpoints keep that run's start time,
+// so files downloaded before the interruption are still recognized after another interruption.
+func newCheckpointer(history *dh.DownloadHistory, runID string, every int, interval time.Duration, now func() time.Time) *checkpointer {
+	start := now()
+	c := &checkpointer{
+		history:  history,
+		run:      dh.InterruptedRun{RunID: runID, Started: start},
+		every:    every,
+		interval: interval,
+		last:     start,
+		now:      now,
+	}
+	if prev, ok := history.Interrupted(); ok {
+		c.resumed = true
+		c.run.Started = prev.Started
+	}
+	return c
+}
+
+// downloadedBefore reports whether item was downloaded by the unfinished run this run resumes.
+func (c *checkpointer) downloadedBefore(item dh.DownloadItem) bool {
+	return c.resumed && !item.DownloadTime.Before(c.run.Started)
+}
+
+// fileDownloaded records a download and saves a checkpoint if the count or time trigger is reached.
+func (c *checkpointer) fileDownloaded() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending++
+	now := c.now()
+	if (c.every > 0 && c.pending >= c.every) || (c.interval > 0 && now.Sub(c.last) >= c.interval) {
+		if err := c.history.Checkpoint(c.run); err != nil {
+			return err
+		}
+		c.pending = 0
+		c.last = now
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package synology_drive_exporter
 import (
 	"errors"
 	"fmt"
+	"iter"
 	"path/filepath"
 	"strings"
 	"time"
@@ -14,19 +15,23 @@ import (
 
 // ExportItem represents an item to be exported.
 type ExportItem struct {
-	Type        synd.ObjectType
-	FileID      synd.FileID
-	DisplayPath string
-	Hash        synd.FileHash
+	Type         synd.ObjectType
+	FileID       synd.FileID
+	Name         string // Name of the item as listed, sent to the server when the item is exported
+	DisplayPath  string
+	Hash         synd.FileHash
+	ModifiedTime time.Time
 }
 
 // newExportItem creates a new ExportItem from a ResponseItem.
 func newExportItem(item *synd.ResponseItem) ExportItem {
 	return ExportItem{
-		Type:        item.Type,
-		FileID:      item.FileID,
-		DisplayPath: item.DisplayPath,
-		Hash:        item.Hash,
+		Type:         item.Type,
+		FileID:       item.FileID,
+		Name:         item.Name,
+		DisplayPath:  item.DisplayPath,
+		Hash:         item.Hash,
+		ModifiedTime: item.ModifiedTime,
 	}
 }
 
@@ -36,7 +41,12 @@ func makeLocalFileName(displayPath string) string {
 }
 
 // processItem processes a single item (file or directory). Directories are processed recursively, exportable files are exported, and errors are logged. DownloadItem.Status distinguishes loaded, downloaded, and skipped states.
+// Nothing is processed once the exporter has been interrupted.
 func (e *Exporter) processItem(item ExportItem, history *dh.DownloadHistory) {
+	if e.Interrupted() {
+		return
+	}
+	e.setCurrentPath(item.DisplayPath)
 	switch item.Type {
 	case synd.ObjectTypeDirectory:
 		e.processDirectory(item, history)
@@ -58,6 +68,11 @@ func (e *Exporter) processFile(item ExportItem, history *dh.DownloadHistory) {
 
 	localPath := makeLocalFileName(item.DisplayPath)
 
+	if e.reconcile {
+		e.reconcileFile(item, localPath, history)
+		return
+	}
+
 	// Check if we should skip based on hash and forceDownload flag
 	prev, downloaded, err := history.GetItem(localPath)
 	if err != nil {
@@ -66,8 +81,9 @@ func (e *Exporter) processFile(item ExportItem, history *dh.DownloadHistory) {
 		return
 	}
 
-	// Skip if file exists, hashes match, and we're not forcing a re-download
-	if !e.forceDownload && downloaded && prev.Hash == item.Hash {
+	// Skip if file exists, hashes match, and we're not forcing a re-download.
+	// A forced re-download still skips files the interrupted run being resumed has already downloaded.
+	if downloaded && prev.Hash == item.Hash && (!e.forceDownload || e.downloadedByResumedRun(prev)) {
 		e.getLogger().Debug("Skipping file due to unchanged hash", "path", localPath, "prev_hash", prev.Hash, "current_hash", item.Hash, "status", prev.DownloadStatus)
 		history.SkippedCount.Increment()
 		err := history.MarkSkipped(localPath)
@@ -97,18 +113,20 @@ func (e *Exporter) processFile(item ExportItem, history *dh.DownloadHistory) {
 		return
 	}
 	e.getLogger().Debug("Exporting file", "export_name", exportName)
-	resp, err := e.session.Export(item.FileID)
+	// The name is known from listing, so the session does not need to look it up.
+	resp, err := e.session.ExportNamed(item.FileID, item.Name)
 	if err != nil {
 		e.getLogger().Error("Failed to export file", "export_name", exportName, "error", err)
-		history.ErrorCount.Increment()
+		e.recordFailure(history, localPath, item, err)
 		return
 	}
 	downloadPath := filepath.Join(e.downloadDir, localPath)
 	if err := e.fs.CreateFile(downloadPath, resp.Content, 0755, 0644); err != nil {
 		e.getLogger().Error("Failed to write file", "path", downloadPath, "error", err)
-		history.ErrorCount.Increment()
+		e.recordFailure(history, localPath, item, err)
 		return
 	}
+	e.bytesWritten.Add(int64(len(resp.Content)))
 
 	e.getLogger().Debug("File exported successfully", "path", downloadPath)
 	// Update download history: if entry exists, mark as downloaded (only if loaded); otherwise add as new downloaded entry.
@@ -123,54 +141,165 @@ func (e *Exporter) processFile(item ExportItem, history *dh.DownloadHistory) {
 		e.getLogger().Warn("Failed to update download history", "path", localPath, "error", errHistory)
 	}
 	history.DownloadCount.Increment()
+	// After an interrupt the final checkpoint is written when the export is finalized.
+	if e.checkpoint != nil && !e.Interrupted() {
+		if err := e.checkpoint.fileDownloaded(); err != nil {
+			e.getLogger().Warn("Failed to save download history checkpoint", "error", err)
+		}
+	}
 }
 
-// processDirectory recursively processes a directory and its subdirectories, exporting convertible files and recording errors in history.
-func (e *Exporter) processDirectory(item ExportItem, history *dh.DownloadHistory) {
-	// Use listAll to handle pagination automatically
-	items, err := listAll(e.session, item.FileID)
-	if err != nil {
-		e.getLogger().Error("Failed to list directory", "path", item.DisplayPath, "error", err)
-		history.ErrorCount.Increment()
-		return
-	}
-	for _, child := range items {
-		e.processItem(newExportItem(child), history)
+// downloadedByResumedRun reports whether item was downloaded by the interrupted run the current run resumes.
+func (e *Exporter) downloadedByResumedRun(item dh.DownloadItem) bool {
+	return e.checkpoint != nil && e.checkpoint.downloadedBefore(item)
+}
+
+// recordFailure counts a failed file export and records it in the history journal.
+func (e *Exporter) recordFailure(history *dh.DownloadHistory, localPath string, item ExportItem, cause error) {
+	history.ErrorCount.Increment()
+	if err := history.RecordFailure(localPath, item.FileID, cause); err != nil {
+		e.getLogger().Warn("Failed to record failure in history journal", "path", localPath, "error", err)
 	}
 }
 
-// exportItemsWithHistory is an internal helper for exporting a slice of ExportItem with download history management.
+// processDirectory walks a directory and its subdirectories, exporting convertible files and recording errors in history.
+// Children are processed as their pages arrive; the next page is fetched only when needed.
+// With WithWalkConcurrency, files in different directories are exported concurrently.
+func (e *Exporter) processDirectory(item ExportItem, history *dh.DownloadHistory) {
+	root := &synd.ResponseItem{Type: item.Type, FileID: item.FileID, DisplayPath: item.DisplayPath}
+	// The walk function never fails, so neither does the walk.
+	_ = synd.WalkDir(e.session, root, func(child *synd.ResponseItem, err error) error {
+		if err != nil {
+			e.getLogger().Error("Failed to list directory", "path", child.DisplayPath, "error", err)
+			history.ErrorCount.Increment()
+			return synd.SkipDir
+		}
+		if e.Interrupted() {
+			return synd.SkipAll
+		}
+		e.setCurrentPath(child.DisplayPath)
+		if child.Type == synd.ObjectTypeFile {
+			e.processFile(newExportItem(child), history)
+		}
+		return nil
+	}, synd.WithWalkConcurrency(e.walkConcurrency))
+}
+
+// exportItemsWithHistory is an internal helper for exporting a slice of ExportItem with download history management,
+// as exportStreamWithHistory does.
+func (e *Exporter) exportItemsWithHistory(items []ExportItem, historyFile string) (ExportStats, error) {
+	return e.exportStreamWithHistory(func(yield func(ExportItem, error) bool) {
+		for _, item := range items {
+			if !yield(item, nil) {
+				return
+			}
+		}
+	}, historyFile)
+}
+
+// exportStreamWithHistory is an internal helper for exporting the items of a listing with download history management.
+// Each item is exported as soon as it is yielded. If the listing fails, the error is logged and counted like a directory
+// that cannot be listed, so that obsolete files are not cleaned up based on a partial listing.
 // Only one process can execute this function for a given history file at a time.
 // If another process is already processing the same history file, this function will return an error.
-func (e *Exporter) exportItemsWithHistory(
-	items []ExportItem,
+// If the exporter is interrupted, the history is saved as a checkpoint, obsolete files are not cleaned up
+// and ErrInterrupted is returned together with the statistics so far.
+func (e *Exporter) exportStreamWithHistory(
+	items iter.Seq2[ExportItem, error],
 	historyFile string,
 ) (ExportStats, error) {
+	if e.Interrupted() {
+		return ExportStats{}, ErrInterrupted
+	}
 	historyPath := filepath.Join(e.downloadDir, historyFile)
 
 	// Acquire a file lock to prevent concurrent execution for the same history file
-	unlock, err := filelock.TryLock(historyPath)
+	unlock, err := e.lockHistory(historyPath)
 	if err != nil {
 		if errors.Is(err, filelock.ErrLockHeld) {
-			return ExportStats{}, fmt.Errorf("another process is already exporting to %s", historyFile)
+			if e.Interrupted() {
+				return ExportStats{}, ErrInterrupted
+			}
+			return ExportStats{}, fmt.Errorf("another process is already exporting to %s%s", historyFile, describeLockHolder(historyPath))
 		}
 		return ExportStats{}, fmt.Errorf("failed to acquire lock for %s: %w", historyFile, err)
 	}
-	defer unlock()
+	active := &activeExport{unlock: unlock, runID: e.runID, historyFile: historyFile, started: time.Now()}
+	defer active.release()
+	e.bytesWritten.Store(0)
 
-	history, err := dh.NewDownloadHistory(historyPath)
+	// The journal records actual changes to the export directory, so it is not written in dry-run or reconcile mode.
+	var historyOpts []dh.Option
+	if !e.dryRun && !e.reconcile {
+		journal, err := dh.OpenJournal(JournalPath(historyPath), e.runID,
+			dh.WithJournalMaxSize(e.journalMaxSize),
+			dh.WithJournalMaxAge(e.journalMaxAge),
+			dh.WithJournalMaxBackups(e.journalMaxBackups))
+		if err != nil {
+			return ExportStats{}, &DownloadHistoryOperationError{Op: "open journal", Err: err}
+		}
+		defer func() {
+			if err := journal.Close(); err != nil {
+				e.getLogger().Warn("Failed to close history journal", "path", JournalPath(historyPath), "error", err)
+			}
+		}()
+		historyOpts = append(historyOpts, dh.WithJournal(journal))
+	}
+
+	history, err := dh.NewDownloadHistory(historyPath, historyOpts...)
 	if err != nil {
 		return ExportStats{}, &DownloadHistoryOperationError{Op: "create", Err: err}
 	}
-	if err := history.Load(); err != nil {
+	if e.reconcile {
+		// Start from an empty history; the existing file may be missing or corrupt.
+		if err := history.LoadEmpty(); err != nil {
+			return ExportStats{}, &DownloadHistoryOperationError{Op: "load", Err: err}
+		}
+	} else if err := history.Load(); err != nil {
 		return ExportStats{}, &DownloadHistoryOperationError{Op: "load", Err: err}
 	}
-	for _, item := range items {
+
+	// Checkpoints keep the progress of a long run if it is interrupted. The history is only marked complete
+	// by the final Save, so obsolete files are never cleaned up based on a partial run.
+	if !e.dryRun && !e.reconcile {
+		e.checkpoint = newCheckpointer(history, e.runID, e.checkpointEvery, e.checkpointInterval, time.Now)
+		defer func() { e.checkpoint = nil }()
+		if prev, ok := history.Interrupted(); ok {
+			e.getLogger().Info("Resuming interrupted run", "history", historyFile, "interrupted_run_id", prev.RunID, "started", prev.Started)
+		}
+	}
+	active.history = history
+	active.checkpoint = e.checkpoint
+	e.setActive(active)
+	defer e.setActive(nil)
+
+	for item, err := range items {
+		if err != nil {
+			e.getLogger().Error("Failed to list items", "history", historyFile, "error", err)
+			history.ErrorCount.Increment()
+			break
+		}
+		if e.Interrupted() {
+			break
+		}
 		e.processItem(item, history)
 	}
 
 	dlStats := history.GetStats()
 	exStats := toExportStats(dlStats)
+	exStats.BytesWritten = e.bytesWritten.Load()
+	exStats.HistorySize = history.Len()
+	if e.Interrupted() {
+		// Abort may have finalized the export already; otherwise save the progress so the next run resumes it.
+		if err := active.abort(); err != nil {
+			return exStats, &DownloadHistoryOperationError{Op: "checkpoint", Err: err}
+		}
+		e.getLogger().Warn("Export interrupted; history saved as checkpoint and cleanup skipped", "history", historyFile)
+		return exStats, ErrInterrupted
+	}
+	if !active.finish() {
+		return exStats, ErrInterrupted
+	}
 	if err := history.Save(); err != nil {
 		return exStats, &DownloadHistoryOperationError{Op: "save", Err: err}
 	}
@@ -181,6 +310,29 @@ func (e *Exporter) exportItemsWithHistory(
 	return exStats, nil
 }
 
+// lockHistory acquires the lock for the history file at historyPath.
+// If a lock wait is configured, it waits for a lock held by another process until the wait expires or the exporter is interrupted.
+func (e *Exporter) lockHistory(historyPath string) (func(), error) {
+	opts := []filelock.Option{
+		filelock.WithMaxAge(e.lockMaxAge),
+		filelock.WithForce(e.forceUnlock),
+		filelock.WithLogger(e.getLogger()),
+	}
+	if e.lockWait <= 0 {
+		return filelock.TryLock(historyPath, opts...)
+	}
+	return filelock.Lock(e.stopContext(), historyPath, append(opts, filelock.WithTimeout(e.lockWait))...)
+}
+
+// describeLockHolder returns a description of the process holding the lock for historyPath, for error messages.
+func describeLockHolder(historyPath string) string {
+	info, err := filelock.ReadLockInfo(historyPath)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf(" (PID %d on %s since %s)", info.PID, info.Hostname, info.Timestamp)
+}
+
 // ExportRootsWithHistory exports multiple root directories with download history management.
 func (e *Exporter) ExportRootsWithHistory(
 	rootIDs []synd.FileID,
@@ -188,12 +340,17 @@ func (e *Exporter) ExportRootsWithHistory(
 ) (ExportStats, error) {
 	var exportItems []ExportItem
 	for _, rootID := range rootIDs {
-		exportItems = append(exportItems, ExportItem{
-			Type:        synd.ObjectTypeDirectory,
-			FileID:      rootID,
-			DisplayPath: "",
-			Hash:        "",
-		})
+		exportItems = append(exportItems, rootItem(rootID))
 	}
 	return e.exportItemsWithHistory(exportItems, historyFile)
 }
+
+// rootItem returns the ExportItem of the root directory with ID rootID.
+func rootItem(rootID synd.FileID) ExportItem {
+	return ExportItem{
+		Type:        synd.ObjectTypeDirectory,
+		FileID:      rootID,
+		DisplayPath: "",
+		Hash:        "",
+	}
+}
@@ -2,11 +2,14 @@ package synology_drive_exporter
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	dh "github.com/isseis/go-synology-office-exporter/download_history"
+	"github.com/isseis/go-synology-office-exporter/filelock"
 	synd "github.com/isseis/go-synology-office-exporter/synology_drive_api"
 	"github.com/stretchr/testify/require"
 )
@@ -355,3 +358,409 @@ func TestExportItem_HistoryAndHash(t *testing.T) {
 		})
 	}
 }
+
+// TestExportItemsWithHistory_Journal verifies that each run appends its state changes to the history journal.
+func TestExportItemsWithHistory_Journal(t *testing.T) {
+	dir := t.TempDir()
+	session := &MockSynologySession{
+		ExportFunc: func(fid synd.FileID) (*synd.ExportResponse, error) {
+			if fid == "bad" {
+				return nil, errors.New("export failed")
+			}
+			return &synd.ExportResponse{Content: []byte("file content")}, nil
+		},
+	}
+	items := []ExportItem{
+		{Type: synd.ObjectTypeFile, FileID: "good", DisplayPath: "/doc/good.odoc", Hash: "h1"},
+		{Type: synd.ObjectTypeFile, FileID: "bad", DisplayPath: "/doc/bad.odoc", Hash: "h2"},
+	}
+
+	exporter := NewExporterWithDependencies(session, dir, NewMockFileSystem(), WithRunID("run1"))
+	_, err := exporter.exportItemsWithHistory(items, "history.json")
+	require.NoError(t, err)
+
+	exporter = NewExporterWithDependencies(session, dir, NewMockFileSystem(), WithRunID("run2"))
+	_, err = exporter.exportItemsWithHistory(items[:1], "history.json")
+	require.NoError(t, err)
+
+	records, err := dh.ReadJournal(JournalPath(filepath.Join(dir, "history.json")))
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	require.Equal(t, "run1", records[0].RunID)
+	require.Equal(t, dh.EventDownloaded, records[0].Event)
+	require.Equal(t, "doc/good.docx", records[0].Location)
+	require.Equal(t, dh.EventFailed, records[1].Event)
+	require.Equal(t, "doc/bad.docx", records[1].Location)
+	require.Equal(t, "run2", records[2].RunID)
+	require.Equal(t, dh.EventSkipped, records[2].Event)
+
+	t.Run("dry run does not write the journal", func(t *testing.T) {
+		dir := t.TempDir()
+		exporter := NewExporterWithDependencies(session, dir, NewMockFileSystem(), WithDryRun(true))
+		_, err := exporter.exportItemsWithHistory(items[:1], "history.json")
+		require.NoError(t, err)
+		_, err = os.Stat(JournalPath(filepath.Join(dir, "history.json")))
+		require.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("runs of one exporter are recorded separately", func(t *testing.T) {
+		dir := t.TempDir()
+		exporter := NewExporterWithDependencies(session, dir, NewMockFileSystem())
+		first := exporter.RunID()
+		stats, err := exporter.exportItemsWithHistory(items[:1], "history.json")
+		require.NoError(t, err)
+		require.Equal(t, int64(len("file content")), stats.BytesWritten)
+		require.Equal(t, 1, stats.HistorySize)
+		second := exporter.NewRun()
+		require.NotEqual(t, first, second)
+		stats, err = exporter.exportItemsWithHistory(items[:1], "history.json")
+		require.NoError(t, err)
+		require.Zero(t, stats.BytesWritten, "skipped files are not written")
+		require.Equal(t, 1, stats.HistorySize)
+
+		records, err := dh.ReadJournal(JournalPath(filepath.Join(dir, "history.json")))
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+		require.Equal(t, first, records[0].RunID)
+		require.Equal(t, second, records[1].RunID)
+		require.Error(t, exporter.Login(), "mock session cannot log in")
+	})
+}
+
+func TestExportItemsWithHistory_Checkpoint(t *testing.T) {
+	items := []ExportItem{
+		{Type: synd.ObjectTypeFile, FileID: "f1", DisplayPath: "/doc/a.odoc", Hash: "h1"},
+		{Type: synd.ObjectTypeFile, FileID: "f2", DisplayPath: "/doc/b.odoc", Hash: "h2"},
+		{Type: synd.ObjectTypeFile, FileID: "f3", DisplayPath: "/doc/c.odoc", Hash: "h3"},
+	}
+
+	t.Run("progress is saved during the run", func(t *testing.T) {
+		dir := t.TempDir()
+		historyPath := filepath.Join(dir, "history.json")
+		var checked bool
+		session := &MockSynologySession{
+			ExportFunc: func(fid synd.FileID) (*synd.ExportResponse, error) {
+				if fid == "f3" {
+					// Two files have been downloaded; with a checkpoint after every file both must be on disk.
+					history, err := dh.NewDownloadHistory(historyPath)
+					require.NoError(t, err)
+					require.NoError(t, history.Load())
+					run, ok := history.Interrupted()
+					require.True(t, ok)
+					require.Equal(t, "run1", run.RunID)
+					for _, location := range []string{"doc/a.docx", "doc/b.docx"} {
+						_, exists, err := history.GetItem(location)
+						require.NoError(t, err)
+						require.True(t, exists, location)
+					}
+					checked = true
+				}
+				return &synd.ExportResponse{Content: []byte("file content")}, nil
+			},
+		}
+
+		exporter := NewExporterWithDependencies(session, dir, NewMockFileSystem(), WithRunID("run1"), WithCheckpoint(1, 0))
+		stats, err := exporter.exportItemsWithHistory(items, "history.json")
+		require.NoError(t, err)
+		require.True(t, checked)
+		require.Equal(t, 3, stats.Downloaded)
+
+		history, err := dh.NewDownloadHistory(historyPath)
+		require.NoError(t, err)
+		require.NoError(t, history.Load())
+		_, ok := history.Interrupted()
+		require.False(t, ok, "a completed run must clear the interrupted mark")
+	})
+
+	t.Run("resume skips files downloaded by the interrupted run", func(t *testing.T) {
+		dir := t.TempDir()
+		historyPath := filepath.Join(dir, "history.json")
+		started := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+		// Simulate a run interrupted after downloading a.docx; b.docx and obsolete.docx predate it.
+		history, err := dh.NewDownloadHistory(historyPath)
+		require.NoError(t, err)
+		require.NoError(t, history.Load())
+		require.NoError(t, history.SetDownloaded("doc/a.docx", dh.DownloadItem{FileID: "f1", Hash: "h1", DownloadTime: started.Add(time.Minute)}))
+		require.NoError(t, history.SetDownloaded("doc/b.docx", dh.DownloadItem{FileID: "f2", Hash: "h2", DownloadTime: started.Add(-time.Hour)}))
+		require.NoError(t, history.SetDownloaded("doc/obsolete.docx", dh.DownloadItem{FileID: "f9", Hash: "h9", DownloadTime: started.Add(-time.Hour)}))
+		require.NoError(t, history.Checkpoint(dh.InterruptedRun{RunID: "run1", Started: started}))
+
+		var exported []synd.FileID
+		session := &MockSynologySession{
+			ExportFunc: func(fid synd.FileID) (*synd.ExportResponse, error) {
+				exported = append(exported, fid)
+				return &synd.ExportResponse{Content: []byte("file content")}, nil
+			},
+		}
+		fs := NewMockFileSystem()
+		exporter := NewExporterWithDependencies(session, dir, fs, WithRunID("run2"), WithForceDownload(true))
+		stats, err := exporter.exportItemsWithHistory(items, "history.json")
+		require.NoError(t, err)
+		require.Equal(t, []synd.FileID{"f2", "f3"}, exported)
+		require.Equal(t, 1, stats.Skipped)
+		require.Equal(t, 2, stats.Downloaded)
+		require.Equal(t, 1, stats.Removed, "cleanup runs once the resumed run completes")
+	})
+
+	t.Run("dry run does not checkpoint", func(t *testing.T) {
+		dir := t.TempDir()
+		session := &MockSynologySession{
+			ExportFunc: func(fid synd.FileID) (*synd.ExportResponse, error) {
+				t.Errorf("Export must not be called during dry run, got %s", fid)
+				return nil, os.ErrInvalid
+			},
+		}
+		exporter := NewExporterWithDependencies(session, dir, NewMockFileSystem(), WithDryRun(true), WithCheckpoint(1, 0))
+		_, err := exporter.exportItemsWithHistory(items, "history.json")
+		require.NoError(t, err)
+		require.Nil(t, exporter.checkpoint)
+	})
+}
+
+func TestExportItemsWithHistory_Interrupt(t *testing.T) {
+	items := []ExportItem{
+		{Type: synd.ObjectTypeFile, FileID: "f1", DisplayPath: "/doc/a.odoc", Hash: "h1"},
+		{Type: synd.ObjectTypeFile, FileID: "f2", DisplayPath: "/doc/b.odoc", Hash: "h2"},
+		{Type: synd.ObjectTypeFile, FileID: "f3", DisplayPath: "/doc/c.odoc", Hash: "h3"},
+	}
+
+	// prepare writes a completed history containing an entry that is obsolete for items.
+	prepare := func(t *testing.T) string {
+		dir := t.TempDir()
+		history, err := dh.NewDownloadHistory(filepath.Join(dir, "history.json"))
+		require.NoError(t, err)
+		require.NoError(t, history.Load())
+		require.NoError(t, history.SetDownloaded("doc/obsolete.docx", dh.DownloadItem{FileID: "f9", Hash: "h9", DownloadTime: time.Now()}))
+		require.NoError(t, history.Save())
+		return dir
+	}
+
+	// verify checks that the lock was released and the history was saved as a checkpoint
+	// containing the downloaded files and the obsolete entry.
+	verify := func(t *testing.T, dir string, downloaded []string) {
+		historyPath := filepath.Join(dir, "history.json")
+		_, err := os.Stat(historyPath + ".lock")
+		require.True(t, os.IsNotExist(err), "lock must be released")
+
+		history, err := dh.NewDownloadHistory(historyPath)
+		require.NoError(t, err)
+		require.NoError(t, history.Load())
+		run, ok := history.Interrupted()
+		require.True(t, ok)
+		require.Equal(t, "run1", run.RunID)
+		for _, location := range append(downloaded, "doc/obsolete.docx") {
+			_, exists, err := history.GetItem(location)
+			require.NoError(t, err)
+			require.True(t, exists, location)
+		}
+	}
+
+	t.Run("interrupt stops after the current file", func(t *testing.T) {
+		dir := prepare(t)
+		var exporter *Exporter
+		var exported []synd.FileID
+		session := &MockSynologySession{
+			ExportFunc: func(fid synd.FileID) (*synd.ExportResponse, error) {
+				exported = append(exported, fid)
+				if fid == "f2" {
+					exporter.Interrupt()
+				}
+				return &synd.ExportResponse{Content: []byte("file content")}, nil
+			},
+		}
+		exporter = NewExporterWithDependencies(session, dir, NewMockFileSystem(), WithRunID("run1"))
+		stats, err := exporter.exportItemsWithHistory(items, "history.json")
+		require.ErrorIs(t, err, ErrInterrupted)
+		require.Equal(t, []synd.FileID{"f1", "f2"}, exported)
+		require.Equal(t, 2, stats.Downloaded)
+		require.Equal(t, 0, stats.Removed, "cleanup must be skipped")
+		verify(t, dir, []string{"doc/a.docx", "doc/b.docx"})
+
+		_, err = exporter.exportItemsWithHistory(items, "history.json")
+		require.ErrorIs(t, err, ErrInterrupted, "later exports must not start")
+	})
+
+	t.Run("abort finalizes the export in progress", func(t *testing.T) {
+		dir := prepare(t)
+		var exporter *Exporter
+		session := &MockSynologySession{
+			ExportFunc: func(fid synd.FileID) (*synd.ExportResponse, error) {
+				if fid == "f2" {
+					require.NoError(t, exporter.Abort())
+					verify(t, dir, []string{"doc/a.docx"})
+				}
+				return &synd.ExportResponse{Content: []byte("file content")}, nil
+			},
+		}
+		exporter = NewExporterWithDependencies(session, dir, NewMockFileSystem(), WithRunID("run1"))
+		_, err := exporter.exportItemsWithHistory(items, "history.json")
+		require.ErrorIs(t, err, ErrInterrupted)
+
+		// The history written by Abort is not overwritten once the export returns.
+		history, err := dh.NewDownloadHistory(filepath.Join(dir, "history.json"))
+		require.NoError(t, err)
+		require.NoError(t, history.Load())
+		_, exists, err := history.GetItem("doc/b.docx")
+		require.NoError(t, err)
+		require.False(t, exists)
+		require.NoError(t, exporter.Abort(), "abort without an export in progress is a no-op")
+	})
+}
+
+func TestExportItemsWithHistory_StaleLock(t *testing.T) {
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		t.Skip("Skipping test because the hostname is unavailable")
+	}
+	items := []ExportItem{{Type: synd.ObjectTypeFile, FileID: "f1", DisplayPath: "/doc/a.odoc", Hash: "h1"}}
+	session := &MockSynologySession{
+		ExportFunc: func(fid synd.FileID) (*synd.ExportResponse, error) {
+			return &synd.ExportResponse{Content: []byte("file content")}, nil
+		},
+	}
+	writeLock := func(t *testing.T, dir string, pid int) {
+		lock := fmt.Sprintf(`{"pid": %d, "hostname": %q, "timestamp": %q}`, pid, hostname, time.Now().UTC().Format(time.RFC3339))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "history.json.lock"), []byte(lock), 0600))
+	}
+
+	t.Run("lock of a process that is not running is broken", func(t *testing.T) {
+		dir := t.TempDir()
+		writeLock(t, dir, 1<<30) // Above the largest PID the kernel assigns
+		exporter := NewExporterWithDependencies(session, dir, NewMockFileSystem())
+		stats, err := exporter.exportItemsWithHistory(items, "history.json")
+		require.NoError(t, err)
+		require.Equal(t, 1, stats.Downloaded)
+	})
+
+	t.Run("lock of a running process is honoured", func(t *testing.T) {
+		dir := t.TempDir()
+		unlock, err := filelock.TryLock(filepath.Join(dir, "history.json"))
+		require.NoError(t, err)
+		defer unlock()
+		exporter := NewExporterWithDependencies(session, dir, NewMockFileSystem())
+		_, err = exporter.exportItemsWithHistory(items, "history.json")
+		require.ErrorContains(t, err, "another process is already exporting")
+	})
+}
+
+func TestExportItemsWithHistory_LockWait(t *testing.T) {
+	items := []ExportItem{{Type: synd.ObjectTypeFile, FileID: "f1", DisplayPath: "/doc/a.odoc", Hash: "h1"}}
+	session := &MockSynologySession{
+		ExportFunc: func(fid synd.FileID) (*synd.ExportResponse, error) {
+			return &synd.ExportResponse{Content: []byte("file content")}, nil
+		},
+	}
+
+	t.Run("waits for the holder to finish", func(t *testing.T) {
+		dir := t.TempDir()
+		release, err := filelock.TryLock(filepath.Join(dir, "history.json"))
+		require.NoError(t, err)
+		time.AfterFunc(200*time.Millisecond, release)
+
+		exporter := NewExporterWithDependencies(session, dir, NewMockFileSystem(), WithLockWait(10*time.Second))
+		stats, err := exporter.exportItemsWithHistory(items, "history.json")
+		require.NoError(t, err)
+		require.Equal(t, 1, stats.Downloaded)
+	})
+
+	t.Run("gives up after the wait and reports the holder", func(t *testing.T) {
+		dir := t.TempDir()
+		release, err := filelock.TryLock(filepath.Join(dir, "history.json"))
+		require.NoError(t, err)
+		defer release()
+
+		exporter := NewExporterWithDependencies(session, dir, NewMockFileSystem(), WithLockWait(100*time.Millisecond))
+		_, err = exporter.exportItemsWithHistory(items, "history.json")
+		require.ErrorContains(t, err, "another process is already exporting")
+		require.ErrorContains(t, err, fmt.Sprintf("PID %d", os.Getpid()))
+	})
+
+	t.Run("interrupt stops waiting", func(t *testing.T) {
+		dir := t.TempDir()
+		release, err := filelock.TryLock(filepath.Join(dir, "history.json"))
+		require.NoError(t, err)
+		defer release()
+
+		exporter := NewExporterWithDependencies(session, dir, NewMockFileSystem(), WithLockWait(time.Minute))
+		time.AfterFunc(100*time.Millisecond, exporter.Interrupt)
+		start := time.Now()
+		_, err = exporter.exportItemsWithHistory(items, "history.json")
+		require.ErrorIs(t, err, ErrInterrupted)
+		require.Less(t, time.Since(start), 30*time.Second)
+	})
+}
+
+// TestExporterProgress verifies that the export in progress reports its current path and statistics.
+func TestExporterProgress(t *testing.T) {
+	items := []ExportItem{
+		{Type: synd.ObjectTypeFile, FileID: "f1", DisplayPath: "/doc/a.odoc", Hash: "h1"},
+		{Type: synd.ObjectTypeFile, FileID: "f2", DisplayPath: "/doc/b.odoc", Hash: "h2"},
+	}
+	var exporter *Exporter
+	var progress Progress
+	var inProgress bool
+	session := &MockSynologySession{
+		ExportFunc: func(fid synd.FileID) (*synd.ExportResponse, error) {
+			if fid == "f2" {
+				progress, inProgress = exporter.Progress()
+			}
+			return &synd.ExportResponse{Content: []byte("file content")}, nil
+		},
+	}
+	exporter = NewExporterWithDependencies(session, t.TempDir(), NewMockFileSystem(), WithRunID("run1"))
+
+	_, ok := exporter.Progress()
+	require.False(t, ok, "no export before the run")
+	_, err := exporter.exportItemsWithHistory(items, "history.json")
+	require.NoError(t, err)
+
+	require.True(t, inProgress)
+	require.Equal(t, "run1", progress.RunID)
+	require.Equal(t, "history.json", progress.HistoryFile)
+	require.Equal(t, "/doc/b.odoc", progress.CurrentPath)
+	require.Equal(t, 1, progress.Stats.Downloaded)
+	require.False(t, progress.Started.IsZero())
+	_, ok = exporter.Progress()
+	require.False(t, ok, "no export after the run")
+}
+
+// TestProcessDirectory_Streaming verifies that listed items are exported as their pages arrive.
+func TestProcessDirectory_Streaming(t *testing.T) {
+	var calls []string
+	session := &MockSynologySession{
+		MaxPageSize: 1,
+		ListFunc: func(rootDirID synd.FileID, offset, limit int64) (*synd.ListResponse, error) {
+			calls = append(calls, fmt.Sprintf("list %d", offset))
+			if offset == 2 {
+				return nil, errors.New("connection reset")
+			}
+			id := synd.FileID(fmt.Sprintf("file%d", offset))
+			return &synd.ListResponse{
+				Items: []*synd.ResponseItem{{Type: synd.ObjectTypeFile, FileID: id, DisplayPath: fmt.Sprintf("/doc/%s.odoc", id)}},
+				Total: 3,
+			}, nil
+		},
+		ExportFunc: func(fid synd.FileID) (*synd.ExportResponse, error) {
+			calls = append(calls, "export "+string(fid))
+			return &synd.ExportResponse{Content: []byte("file content")}, nil
+		},
+	}
+	th := dh.NewDownloadHistoryForTest(t, noDownloadItems)
+	defer th.Close()
+	history := th.DownloadHistory
+	exporter := NewExporterWithDependencies(session, "", NewMockFileSystem())
+
+	exporter.processDirectory(ExportItem{Type: synd.ObjectTypeDirectory, FileID: "dir", DisplayPath: "/doc"}, history)
+
+	want := []string{"list 0", "export file0", "list 1", "export file1", "list 2"}
+	if fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+	if got := history.DownloadCount.Get(); got != 2 {
+		t.Errorf("DownloadCount = %d, want 2", got)
+	}
+	if got := history.ErrorCount.Get(); got != 1 {
+		t.Errorf("ErrorCount = %d, want 1 for the failed page", got)
+	}
+}
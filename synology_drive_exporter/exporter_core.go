@@ -1,12 +1,45 @@
 package synology_drive_exporter
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	dh "github.com/isseis/go-synology-office-exporter/download_history"
 	"github.com/isseis/go-synology-office-exporter/logger"
 	synd "github.com/isseis/go-synology-office-exporter/synology_drive_api"
 )
 
+// History file names used for each export source, relative to the download directory.
+const (
+	MyDriveHistoryFile      = "mydrive_history.json"
+	TeamFolderHistoryFile   = "team_folder_history.json"
+	SharedWithMeHistoryFile = "shared_with_me_history.json"
+)
+
+// Default rotation limits of the download history journal.
+const (
+	DefaultJournalMaxSize = 10 * 1024 * 1024
+	DefaultJournalMaxAge  = 30 * 24 * time.Hour
+)
+
+// Default intervals between download history checkpoints during a run.
+const (
+	DefaultCheckpointEvery    = 100
+	DefaultCheckpointInterval = time.Minute
+)
+
+// journalSuffix is appended to a history file path to name its journal.
+const journalSuffix = ".journal"
+
+// JournalPath returns the path of the journal kept next to the given history file.
+func JournalPath(historyPath string) string {
+	return historyPath + journalSuffix
+}
+
 // Logger defines the interface for logging operations within the exporter.
 type Logger interface {
 	Debug(msg string, args ...any)
@@ -31,6 +64,57 @@ type Exporter struct {
 	// forceDownload controls whether to re-download files even if they exist and have matching hashes.
 	// Default is false.
 	forceDownload bool
+
+	// reconcile controls whether to rebuild download history from files already in downloadDir instead of exporting.
+	// Default is false.
+	reconcile bool
+
+	// runID identifies this run in the download history journal. Default is a new unique ID.
+	runID string
+
+	// journalMaxSize and journalMaxAge control rotation of the download history journal. Zero disables the limit.
+	journalMaxSize int64
+	journalMaxAge  time.Duration
+
+	// journalMaxBackups is the number of rotated journal files kept. Zero keeps all.
+	journalMaxBackups int
+
+	// checkpointEvery and checkpointInterval control how often the download history is saved during a run.
+	// Zero disables the corresponding trigger.
+	checkpointEvery    int
+	checkpointInterval time.Duration
+
+	// bytesWritten counts the bytes written to downloaded files by the running export.
+	bytesWritten atomic.Int64
+
+	// walkConcurrency is the number of directories walked at once. 1 or less walks sequentially.
+	walkConcurrency int
+
+	// sessionOptions configure the session created by NewExporter.
+	sessionOptions []synd.SessionOption
+
+	// checkpoint saves the download history of the running export. Nil outside exportItemsWithHistory and in dry-run mode.
+	checkpoint *checkpointer
+
+	// lockMaxAge and forceUnlock control when a history lock left behind by another process is broken.
+	// See filelock.WithMaxAge and filelock.WithForce.
+	lockMaxAge  time.Duration
+	forceUnlock bool
+
+	// lockWait is how long to wait for a history lock held by another process. Zero fails immediately.
+	lockWait time.Duration
+
+	// interrupted is set by Interrupt to stop scheduling further files.
+	interrupted atomic.Bool
+
+	// stopCtx is cancelled by Interrupt to stop waiting for locks. Created on first use by stopContext.
+	stopOnce   sync.Once
+	stopCtx    context.Context
+	stopCancel context.CancelFunc
+
+	// active is the export in progress, finalized by Abort. Guarded by activeMu.
+	activeMu sync.Mutex
+	active   *activeExport
 }
 
 // ExporterOption defines a function type to set options for Exporter.
@@ -52,6 +136,84 @@ func WithForceDownload(force bool) ExporterOption {
 	}
 }
 
+// WithReconcile sets the reconcile option for Exporter.
+// When true, no files are downloaded; instead, a fresh download history is built from
+// the files that already exist in the download directory and match the remote files.
+func WithReconcile(reconcile bool) ExporterOption {
+	return func(e *Exporter) {
+		e.reconcile = reconcile
+	}
+}
+
+// WithRunID sets the identifier recorded for this run in the download history journal.
+func WithRunID(runID string) ExporterOption {
+	return func(e *Exporter) {
+		e.runID = runID
+	}
+}
+
+// WithJournalRotation sets the size and age limits after which the download history journal is rotated.
+// A zero value disables the corresponding limit.
+func WithJournalRotation(maxSize int64, maxAge time.Duration) ExporterOption {
+	return func(e *Exporter) {
+		e.journalMaxSize = maxSize
+		e.journalMaxAge = maxAge
+	}
+}
+
+// WithJournalMaxBackups sets the number of rotated download history journal files to keep. Older files are
+// removed after each rotation, so runs recorded only in them can no longer be listed or replayed.
+// Zero, the default, keeps all files.
+func WithJournalMaxBackups(maxBackups int) ExporterOption {
+	return func(e *Exporter) {
+		e.journalMaxBackups = maxBackups
+	}
+}
+
+// WithCheckpoint sets how often the download history is saved while a run is in progress:
+// after every `every` downloaded files and whenever `interval` has passed since the last save.
+// A zero value disables the corresponding trigger.
+func WithCheckpoint(every int, interval time.Duration) ExporterOption {
+	return func(e *Exporter) {
+		e.checkpointEvery = every
+		e.checkpointInterval = interval
+	}
+}
+
+// WithWalkConcurrency lets up to n goroutines list directories and export files at once; see synd.WithWalkConcurrency.
+// Files are then exported in no particular order. A value of 1 or less exports sequentially, which is the default.
+func WithWalkConcurrency(n int) ExporterOption {
+	return func(e *Exporter) {
+		e.walkConcurrency = n
+	}
+}
+
+// WithStaleLock sets when a history lock left behind by another process is considered stale and broken.
+// Locks older than maxAge are stale (zero disables the age limit); locks from other hosts are only broken if force is set.
+// Locks whose process is no longer running on this host are always stale.
+func WithStaleLock(maxAge time.Duration, force bool) ExporterOption {
+	return func(e *Exporter) {
+		e.lockMaxAge = maxAge
+		e.forceUnlock = force
+	}
+}
+
+// WithLockWait sets how long to wait for a history lock held by another process, such as an overlapping
+// scheduled run, before failing. Zero fails immediately.
+func WithLockWait(wait time.Duration) ExporterOption {
+	return func(e *Exporter) {
+		e.lockWait = wait
+	}
+}
+
+// WithSessionOptions sets options for the session created by NewExporter, such as synd.WithAPIObserver.
+// It has no effect on the session passed to NewExporterWithDependencies.
+func WithSessionOptions(opts ...synd.SessionOption) ExporterOption {
+	return func(e *Exporter) {
+		e.sessionOptions = append(e.sessionOptions, opts...)
+	}
+}
+
 // WithLogger sets the logger for Exporter.
 // If not set, a fallback logger will be used for backward compatibility.
 func WithLogger(log Logger) ExporterOption {
@@ -68,6 +230,37 @@ func WithLogLevel(level logger.Level) ExporterOption {
 	}
 }
 
+// RunID returns the identifier recorded for this run in the download history journal.
+func (e *Exporter) RunID() string {
+	return e.runID
+}
+
+// NewRun assigns a new run ID to the exporter and returns it. Long-running processes that export with the
+// same exporter repeatedly call it before each run, so that every run is recorded separately in the journal.
+// It must not be called while an export is in progress.
+func (e *Exporter) NewRun() string {
+	e.runID = dh.NewRunID()
+	return e.runID
+}
+
+// Login logs in to the NAS again with the credentials of the exporter's session, replacing its session ID.
+// Long-running processes use it to recover from an expired session.
+// Returns an error if the session does not support logging in.
+func (e *Exporter) Login() error {
+	session, ok := e.session.(interface{ Login() error })
+	if !ok {
+		return errors.New("session does not support login")
+	}
+	return session.Login()
+}
+
+// CheckSession makes one lightweight API call, listing the first item of My Drive, to check that the session
+// is still logged in. If the NAS has ended the session, the error satisfies synd.IsSessionExpired.
+func (e *Exporter) CheckSession() error {
+	_, err := e.session.List(synd.MyDrive, 0, 1)
+	return err
+}
+
 // IsDryRun returns true if the exporter is in dry-run mode.
 func (e *Exporter) IsDryRun() bool {
 	return e.dryRun
@@ -141,14 +334,15 @@ func formatLogMessage(msg string, args ...any) string {
 // NewExporter constructs an Exporter with a real Synology session and the specified download directory. If downloadDir is empty, the current directory is used.
 // Additional runtime options can be specified via ExporterOption(s), such as WithDryRun.
 func NewExporter(username string, password string, base_url string, downloadDir string, opts ...ExporterOption) (*Exporter, error) {
-	session, err := synd.NewSynologySession(username, password, base_url)
+	exporter := NewExporterWithDependencies(nil, downloadDir, &DefaultFileSystem{}, opts...)
+	session, err := synd.NewSynologySession(username, password, base_url, exporter.sessionOptions...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create session: %w", err)
 	}
 	if err = session.Login(); err != nil {
 		return nil, fmt.Errorf("failed to login: %w", err)
 	}
-	exporter := NewExporterWithDependencies(session, downloadDir, &DefaultFileSystem{}, opts...)
+	exporter.session = session
 	return exporter, nil
 }
 
@@ -156,12 +350,18 @@ func NewExporter(username string, password string, base_url string, downloadDir
 // Additional runtime options can be specified via ExporterOption(s), such as WithDryRun.
 func NewExporterWithDependencies(session SessionInterface, downloadDir string, fs FileSystemOperations, opts ...ExporterOption) *Exporter {
 	e := &Exporter{
-		session:     session,
-		downloadDir: downloadDir,
-		fs:          fs,
-		dryRun:      false,            // default
-		logger:      nil,              // will use fallback logger if not set
-		logLevel:    logger.LevelWarn, // default log level
+		session:        session,
+		downloadDir:    downloadDir,
+		fs:             fs,
+		dryRun:         false,            // default
+		logger:         nil,              // will use fallback logger if not set
+		logLevel:       logger.LevelWarn, // default log level
+		runID:          dh.NewRunID(),
+		journalMaxSize: DefaultJournalMaxSize,
+		journalMaxAge:  DefaultJournalMaxAge,
+
+		checkpointEvery:    DefaultCheckpointEvery,
+		checkpointInterval: DefaultCheckpointInterval,
 	}
 	// Apply additional runtime options.
 	for _, opt := range opts {
@@ -174,35 +374,21 @@ func NewExporterWithDependencies(session SessionInterface, downloadDir string, f
 func (e *Exporter) ExportMyDrive() (ExportStats, error) {
 	return e.ExportRootsWithHistory(
 		[]synd.FileID{synd.MyDrive},
-		"mydrive_history.json",
+		MyDriveHistoryFile,
 	)
 }
 
 // ExportTeamFolder exports convertible files from all team folders, using download history to avoid duplicates.
 func (e *Exporter) ExportTeamFolder() (ExportStats, error) {
-	teamFolders, err := teamFoldersAll(e.session)
-	if err != nil {
-		return ExportStats{}, err
-	}
-	var rootIDs []synd.FileID
-	for _, item := range teamFolders {
-		rootIDs = append(rootIDs, item.FileID)
-	}
-	return e.ExportRootsWithHistory(
-		rootIDs,
-		"team_folder_history.json",
-	)
+	// Each team folder is exported as soon as it is listed.
+	teamFolders := exportItems(e.session.TeamFolderItems(), func(item *synd.TeamFolderResponseItem) ExportItem {
+		return rootItem(item.FileID)
+	})
+	return e.exportStreamWithHistory(teamFolders, TeamFolderHistoryFile)
 }
 
 // ExportSharedWithMe exports convertible files and directories shared with the user, using download history to avoid duplicates.
 func (e *Exporter) ExportSharedWithMe() (ExportStats, error) {
-	sharedItems, err := sharedWithMeAll(e.session)
-	if err != nil {
-		return ExportStats{}, err
-	}
-	var exportItems []ExportItem
-	for _, item := range sharedItems {
-		exportItems = append(exportItems, newExportItem(item))
-	}
-	return e.exportItemsWithHistory(exportItems, "shared_with_me_history.json")
+	// Each shared item is exported as soon as it is listed.
+	return e.exportStreamWithHistory(exportItems(e.session.SharedWithMeItems(), newExportItem), SharedWithMeHistoryFile)
 }
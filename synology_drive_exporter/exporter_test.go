@@ -2,7 +2,9 @@ package synology_drive_exporter
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -22,6 +24,8 @@ func TestExporterExportMyDrive(t *testing.T) {
 		fileOperationError error
 		expectedError      bool
 		expectedFiles      int
+		// expectedNames maps file IDs to the names the files are exported with, if checked.
+		expectedNames map[synd.FileID]string
 		// trackedListCalls tracks directory IDs listed during recursive traversal.
 		trackedListCalls map[synd.FileID]bool
 		// directoryResponses maps directory IDs to list responses for recursive traversal.
@@ -36,11 +40,13 @@ func TestExporterExportMyDrive(t *testing.T) {
 					{
 						Type:        synd.ObjectTypeFile,
 						FileID:      "file1",
+						Name:        "test1.odoc",
 						DisplayPath: "/doc/test1.odoc", // .docx -> .odoc
 					},
 					{
 						Type:        synd.ObjectTypeFile,
 						FileID:      "file2",
+						Name:        "test2.osheet",
 						DisplayPath: "/doc/test2.osheet", // .xlsx -> .osheet
 					},
 				},
@@ -50,6 +56,25 @@ func TestExporterExportMyDrive(t *testing.T) {
 				"file2": {Content: []byte("file2 content")},
 			},
 			expectedFiles: 2,
+			expectedNames: map[synd.FileID]string{"file1": "test1.odoc", "file2": "test2.osheet"},
+		},
+		{
+			name: "Export with the listed name, not the display path",
+			listResponse: &synd.ListResponse{
+				Items: []*synd.ResponseItem{
+					{
+						Type:        synd.ObjectTypeFile,
+						FileID:      "file1",
+						Name:        "budget.osheet",
+						DisplayPath: "/team/Finance/budget (shared).osheet",
+					},
+				},
+			},
+			exportResponse: map[synd.FileID]*synd.ExportResponse{
+				"file1": {Content: []byte("file1 content")},
+			},
+			expectedFiles: 1,
+			expectedNames: map[synd.FileID]string{"file1": "budget.osheet"},
 		},
 		{
 			name: "Skip files that are not export targets",
@@ -404,6 +429,11 @@ func TestExporterExportMyDrive(t *testing.T) {
 			if tt.expectedError && stats.DownloadErrs == 0 {
 				t.Errorf("Expected stats.DownloadErrs > 0, but got %d", stats.DownloadErrs)
 			}
+			for fileID, name := range tt.expectedNames {
+				if got := mockSession.ExportedNames[fileID]; got != name {
+					t.Errorf("Expected file %s to be exported with name %q, but got %q", fileID, name, got)
+				}
+			}
 
 			// Check if all expected directories were traversed
 			if tt.directoryResponses != nil {
@@ -531,3 +561,111 @@ func TestForceDownload(t *testing.T) {
 		})
 	}
 }
+
+// TestExporterStreamsTeamFoldersAndSharedItems verifies that team folders and shared items are exported as their
+// pages arrive, and that a failed page counts as an error.
+func TestExporterStreamsTeamFoldersAndSharedItems(t *testing.T) {
+	var calls []string
+	page := func(offset int64) error {
+		calls = append(calls, fmt.Sprintf("page %d", offset))
+		if offset == 2 {
+			return errors.New("connection reset")
+		}
+		return nil
+	}
+	session := &MockSynologySession{
+		MaxPageSize: 1,
+		TeamFolderFunc: func(offset, limit int64) (*synd.TeamFolderResponse, error) {
+			if err := page(offset); err != nil {
+				return nil, err
+			}
+			id := synd.FileID(fmt.Sprintf("team%d", offset))
+			return &synd.TeamFolderResponse{Items: []*synd.TeamFolderResponseItem{{FileID: id}}, Total: 3}, nil
+		},
+		SharedWithMeFunc: func(offset, limit int64) (*synd.SharedWithMeResponse, error) {
+			if err := page(offset); err != nil {
+				return nil, err
+			}
+			id := synd.FileID(fmt.Sprintf("shared%d", offset))
+			return &synd.SharedWithMeResponse{
+				Items: []*synd.ResponseItem{{Type: synd.ObjectTypeFile, FileID: id, DisplayPath: fmt.Sprintf("/shared/%s.odoc", id)}},
+				Total: 3,
+			}, nil
+		},
+		ListFunc: func(rootDirID synd.FileID, offset, limit int64) (*synd.ListResponse, error) {
+			calls = append(calls, "list "+string(rootDirID))
+			return &synd.ListResponse{}, nil
+		},
+		ExportFunc: func(fileID synd.FileID) (*synd.ExportResponse, error) {
+			calls = append(calls, "export "+string(fileID))
+			return &synd.ExportResponse{Content: []byte("file content")}, nil
+		},
+	}
+	exporter := NewExporterWithDependencies(session, t.TempDir(), NewMockFileSystem())
+
+	for _, tc := range []struct {
+		name   string
+		export func() (ExportStats, error)
+		want   []string
+	}{
+		{"team folders", exporter.ExportTeamFolder, []string{"page 0", "list team0", "page 1", "list team1", "page 2"}},
+		{"shared with me", exporter.ExportSharedWithMe, []string{"page 0", "export shared0", "page 1", "export shared1", "page 2"}},
+	} {
+		calls = nil
+		stats, err := tc.export()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if fmt.Sprint(calls) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: calls = %v, want %v", tc.name, calls, tc.want)
+		}
+		if stats.DownloadErrs != 1 {
+			t.Errorf("%s: DownloadErrs = %d, want 1 for the failed page", tc.name, stats.DownloadErrs)
+		}
+	}
+}
+
+// TestExporterWalkConcurrency verifies that a concurrent walk exports every file of the tree exactly once.
+func TestExporterWalkConcurrency(t *testing.T) {
+	folders := map[synd.FileID][]*synd.ResponseItem{}
+	var want []string
+	for d := range 5 {
+		dir := synd.FileID(fmt.Sprintf("dir%d", d))
+		folders[synd.MyDrive] = append(folders[synd.MyDrive], &synd.ResponseItem{Type: synd.ObjectTypeDirectory, FileID: dir, DisplayPath: "/" + string(dir)})
+		for f := range 4 {
+			path := fmt.Sprintf("/%s/file%d.odoc", dir, f)
+			folders[dir] = append(folders[dir], &synd.ResponseItem{Type: synd.ObjectTypeFile, FileID: synd.FileID(path), DisplayPath: path})
+			want = append(want, path)
+		}
+	}
+	session := &MockSynologySession{
+		MaxPageSize: 2,
+		ListFunc: func(rootDirID synd.FileID, offset, limit int64) (*synd.ListResponse, error) {
+			items := folders[rootDirID]
+			end := min(offset+limit, int64(len(items)))
+			return &synd.ListResponse{Items: items[offset:end], Total: int64(len(items))}, nil
+		},
+		ExportFunc: func(fileID synd.FileID) (*synd.ExportResponse, error) {
+			return &synd.ExportResponse{Content: []byte("file content")}, nil
+		},
+	}
+	mockFS := NewMockFileSystem()
+	downloadDir := t.TempDir()
+	exporter := NewExporterWithDependencies(session, downloadDir, mockFS, WithWalkConcurrency(4), WithCheckpoint(3, 0))
+
+	stats, err := exporter.ExportMyDrive()
+	if err != nil {
+		t.Fatalf("ExportMyDrive failed: %v", err)
+	}
+	if stats.Downloaded != len(want) || stats.TotalErrs() != 0 {
+		t.Errorf("Downloaded = %d, errors = %d; want %d downloads and no errors", stats.Downloaded, stats.TotalErrs(), len(want))
+	}
+	if stats.BytesWritten != int64(len(want)*len("file content")) {
+		t.Errorf("BytesWritten = %d, want %d", stats.BytesWritten, len(want)*len("file content"))
+	}
+	for _, path := range want {
+		if _, ok := mockFS.WrittenFiles[filepath.Join(downloadDir, makeLocalFileName(path))]; !ok {
+			t.Errorf("%s was not exported", path)
+		}
+	}
+}
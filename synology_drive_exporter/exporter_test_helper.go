@@ -5,7 +5,9 @@ package synology_drive_exporter
 
 import (
 	"errors"
+	"iter"
 	"os"
+	"sync"
 
 	synd "github.com/isseis/go-synology-office-exporter/synology_drive_api"
 )
@@ -14,8 +16,10 @@ import (
 type MockFileSystem struct {
 	CreateFileFunc func(string, []byte, os.FileMode, os.FileMode) error
 	RemoveFunc     func(path string) error
+	StatFunc       func(path string) (os.FileInfo, error)
 	WrittenFiles   map[string][]byte
 	RemovedFiles   map[string]bool
+	mu             sync.Mutex // Guards WrittenFiles and RemovedFiles while an export is running
 }
 
 // NewMockFileSystem creates a new MockFileSystem with default no-op implementations.
@@ -32,42 +36,66 @@ func NewMockFileSystem() *MockFileSystem {
 	}
 }
 
-// CreateFile simulates file creation for testing. It records written files in WrittenFiles.
 // CreateFile simulates file creation for testing. It records written files in WrittenFiles.
 func (m *MockFileSystem) CreateFile(filename string, data []byte, dirPerm os.FileMode, filePerm os.FileMode) error {
 	if m.CreateFileFunc != nil {
 		err := m.CreateFileFunc(filename, data, dirPerm, filePerm)
 		if err == nil {
-			m.WrittenFiles[filename] = data
+			m.recordWritten(filename, data)
 		}
 		return err
 	}
 
 	// If no custom function is provided, simulate file writing.
-	m.WrittenFiles[filename] = data
+	m.recordWritten(filename, data)
 	return nil
 }
 
+// recordWritten records data as written to filename.
+func (m *MockFileSystem) recordWritten(filename string, data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.WrittenFiles[filename] = data
+}
+
 // Remove simulates file removal for testing.
 func (m *MockFileSystem) Remove(path string) error {
 	if m.RemoveFunc != nil {
 		err := m.RemoveFunc(path)
 		if err == nil {
-			m.RemovedFiles[path] = true
+			m.recordRemoved(path)
 		}
 		return err
 	}
 	// If no custom function is provided, simulate file removal.
-	m.RemovedFiles[path] = true
+	m.recordRemoved(path)
 	return nil
 }
 
+// recordRemoved records path as removed.
+func (m *MockFileSystem) recordRemoved(path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.RemovedFiles[path] = true
+}
+
+// Stat returns file information using StatFunc, or os.ErrNotExist if StatFunc is not set.
+func (m *MockFileSystem) Stat(path string) (os.FileInfo, error) {
+	if m.StatFunc != nil {
+		return m.StatFunc(path)
+	}
+	return nil, os.ErrNotExist
+}
+
 type MockSynologySession struct {
 	ListFunc         func(rootDirID synd.FileID, offset, limit int64) (*synd.ListResponse, error)
 	ExportFunc       func(fileID synd.FileID) (*synd.ExportResponse, error)
 	TeamFolderFunc   func(offset, limit int64) (*synd.TeamFolderResponse, error)
 	SharedWithMeFunc func(offset, limit int64) (*synd.SharedWithMeResponse, error)
 	MaxPageSize      int64
+	// ExportedNames records the name passed with each exported file ID.
+	ExportedNames map[synd.FileID]string
+	mu            sync.Mutex // Guards ExportedNames while an export is running
 }
 
 func (m *MockSynologySession) List(rootDirID synd.FileID, offset, limit int64) (*synd.ListResponse, error) {
@@ -77,7 +105,13 @@ func (m *MockSynologySession) List(rootDirID synd.FileID, offset, limit int64) (
 	return m.ListFunc(rootDirID, offset, limit)
 }
 
-func (m *MockSynologySession) Export(fileID synd.FileID) (*synd.ExportResponse, error) {
+func (m *MockSynologySession) ExportNamed(fileID synd.FileID, name string) (*synd.ExportResponse, error) {
+	m.mu.Lock()
+	if m.ExportedNames == nil {
+		m.ExportedNames = make(map[synd.FileID]string)
+	}
+	m.ExportedNames[fileID] = name
+	m.mu.Unlock()
 	if m.ExportFunc != nil {
 		return m.ExportFunc(fileID)
 	}
@@ -98,6 +132,28 @@ func (m *MockSynologySession) SharedWithMe(offset, limit int64) (*synd.SharedWit
 	return nil, errors.New("SharedWithMeFunc not set")
 }
 
+// TeamFolderItems returns an iterator over the pages returned by TeamFolderFunc.
+func (m *MockSynologySession) TeamFolderItems() iter.Seq2[*synd.TeamFolderResponseItem, error] {
+	return synd.Paginate(m.GetMaxPageSize(), func(offset, limit int64) ([]*synd.TeamFolderResponseItem, int64, error) {
+		resp, err := m.TeamFolder(offset, limit)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.Items, resp.Total, nil
+	})
+}
+
+// SharedWithMeItems returns an iterator over the pages returned by SharedWithMeFunc.
+func (m *MockSynologySession) SharedWithMeItems() iter.Seq2[*synd.ResponseItem, error] {
+	return synd.Paginate(m.GetMaxPageSize(), func(offset, limit int64) ([]*synd.ResponseItem, int64, error) {
+		resp, err := m.SharedWithMe(offset, limit)
+		if err != nil {
+			return nil, 0, err
+		}
+		return resp.Items, resp.Total, nil
+	})
+}
+
 // GetMaxPageSize returns the maximum number of items that can be requested per page.
 func (m *MockSynologySession) GetMaxPageSize() int64 {
 	if m.MaxPageSize <= 0 {
@@ -46,6 +46,11 @@ func (e *Exporter) cleanupObsoleteFiles(history *dh.DownloadHistory, stats *Expo
 			e.getLogger().Error("Failed to remove obsolete file", "path", path, "error", err)
 		} else {
 			stats.IncrementRemoved() // Always count successful removals
+			if !e.IsDryRun() {
+				if err := history.RecordRemoved(path); err != nil {
+					e.getLogger().Warn("Failed to record removal in history journal", "path", path, "error", err)
+				}
+			}
 		}
 	}
 	return nil
@@ -12,6 +12,8 @@ type FileSystemOperations interface {
 	CreateFile(filename string, data []byte, dirPerm os.FileMode, filePerm os.FileMode) error
 	// Remove deletes the specified file from the filesystem.
 	Remove(path string) error
+	// Stat returns file information for the specified path.
+	Stat(path string) (os.FileInfo, error)
 }
 
 // DefaultFileSystem provides a production implementation of FileSystemOperations using the os package.
@@ -37,3 +39,8 @@ func (fs *DefaultFileSystem) CreateFile(filename string, data []byte, dirPerm os
 func (fs *DefaultFileSystem) Remove(path string) error {
 	return os.Remove(path)
 }
+
+// Stat returns file information for the specified path.
+func (fs *DefaultFileSystem) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
@@ -0,0 +1,51 @@
+package synology_drive_exporter
+
+import "time"
+
+// Progress describes the export in progress, as reported by Exporter.Progress.
+type Progress struct {
+	RunID       string
+	HistoryFile string      // History file of the export, which identifies its source
+	Started     time.Time   // When the export acquired its history lock
+	CurrentPath string      // Display path of the file or directory being processed
+	Stats       ExportStats // Statistics so far; obsolete files are only removed at the end
+}
+
+// Progress returns the progress of the export in progress.
+// The second return value is false if no export is in progress.
+// This method is safe to call from another goroutine.
+func (e *Exporter) Progress() (Progress, bool) {
+	e.activeMu.Lock()
+	active := e.active
+	e.activeMu.Unlock()
+	if active == nil {
+		return Progress{}, false
+	}
+
+	active.mu.Lock()
+	p := Progress{
+		RunID:       active.runID,
+		HistoryFile: active.historyFile,
+		Started:     active.started,
+		CurrentPath: active.currentPath,
+	}
+	history := active.history
+	active.mu.Unlock()
+	if history != nil {
+		p.Stats = toExportStats(history.GetStats())
+	}
+	return p, true
+}
+
+// setCurrentPath records the display path of the item being processed by the export in progress, if any.
+func (e *Exporter) setCurrentPath(path string) {
+	e.activeMu.Lock()
+	active := e.active
+	e.activeMu.Unlock()
+	if active == nil {
+		return
+	}
+	active.mu.Lock()
+	active.currentPath = path
+	active.mu.Unlock()
+}
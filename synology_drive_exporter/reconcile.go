@@ -0,0 +1,71 @@
+package synology_drive_exporter
+
+import (
+	"os"
+	"path/filepath"
+
+	dh "github.com/isseis/go-synology-office-exporter/download_history"
+)
+
+// mismatchReason describes why a local file cannot be adopted into the download history.
+type mismatchReason string
+
+const (
+	mismatchMissing  mismatchReason = "missing"
+	mismatchNotFile  mismatchReason = "not a regular file"
+	mismatchEmpty    mismatchReason = "empty"
+	mismatchOutdated mismatchReason = "older than remote modification"
+)
+
+// checkLocalFile compares the local export of item with the remote metadata.
+// It returns an empty reason if the local file can be trusted as an export of the current remote file.
+// The local size is not compared with the remote size because conversion changes it.
+func checkLocalFile(info os.FileInfo, item ExportItem) mismatchReason {
+	if !info.Mode().IsRegular() {
+		return mismatchNotFile
+	}
+	if info.Size() == 0 {
+		return mismatchEmpty
+	}
+	if !item.ModifiedTime.IsZero() && info.ModTime().Before(item.ModifiedTime) {
+		return mismatchOutdated
+	}
+	return ""
+}
+
+// reconcileFile records an existing local export of item in the history without downloading it.
+// Files that are missing or do not match the remote file are left out of the history, so the next run exports them again.
+func (e *Exporter) reconcileFile(item ExportItem, localPath string, history *dh.DownloadHistory) {
+	downloadPath := filepath.Join(e.downloadDir, localPath)
+	info, err := e.fs.Stat(downloadPath)
+	var reason mismatchReason
+	switch {
+	case os.IsNotExist(err):
+		reason = mismatchMissing
+	case err != nil:
+		e.getLogger().Error("Failed to inspect local file", "path", downloadPath, "error", err)
+		history.ErrorCount.Increment()
+		return
+	default:
+		reason = checkLocalFile(info, item)
+	}
+
+	if reason != "" {
+		e.getLogger().Warn("Local file does not match remote file; flagged for re-export", "path", localPath, "reason", string(reason))
+		history.MismatchCount.Increment()
+		return
+	}
+
+	newItem := dh.DownloadItem{
+		FileID:       item.FileID,
+		Hash:         item.Hash,
+		DownloadTime: info.ModTime(),
+	}
+	if err := history.SetDownloaded(localPath, newItem); err != nil {
+		e.getLogger().Error("Failed to record local file in history", "path", localPath, "error", err)
+		history.ErrorCount.Increment()
+		return
+	}
+	e.getLogger().Debug("Reconciled local file", "path", localPath)
+	history.SkippedCount.Increment()
+}
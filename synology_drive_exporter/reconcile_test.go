@@ -0,0 +1,73 @@
+package synology_drive_exporter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dh "github.com/isseis/go-synology-office-exporter/download_history"
+	synd "github.com/isseis/go-synology-office-exporter/synology_drive_api"
+)
+
+func TestExporter_Reconcile(t *testing.T) {
+	dir := t.TempDir()
+	remoteModified := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	writeLocal := func(name string, content string, mtime time.Time) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		require.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+	writeLocal("doc/match.docx", "content", remoteModified.Add(time.Minute))
+	writeLocal("doc/outdated.docx", "content", remoteModified.Add(-time.Minute))
+	writeLocal("doc/empty.xlsx", "", remoteModified.Add(time.Minute))
+
+	// A corrupt history file must be replaced rather than cause a failure.
+	historyPath := filepath.Join(dir, MyDriveHistoryFile)
+	require.NoError(t, os.WriteFile(historyPath, []byte("not json"), 0644))
+
+	session := &MockSynologySession{
+		ListFunc: func(rootDirID synd.FileID, offset, limit int64) (*synd.ListResponse, error) {
+			items := []*synd.ResponseItem{
+				{Type: synd.ObjectTypeFile, FileID: "f1", DisplayPath: "/doc/match.odoc", Hash: "h1", ModifiedTime: remoteModified},
+				{Type: synd.ObjectTypeFile, FileID: "f2", DisplayPath: "/doc/outdated.odoc", Hash: "h2", ModifiedTime: remoteModified},
+				{Type: synd.ObjectTypeFile, FileID: "f3", DisplayPath: "/doc/empty.osheet", Hash: "h3", ModifiedTime: remoteModified},
+				{Type: synd.ObjectTypeFile, FileID: "f4", DisplayPath: "/doc/missing.oslides", Hash: "h4", ModifiedTime: remoteModified},
+			}
+			return &synd.ListResponse{Items: items, Total: int64(len(items))}, nil
+		},
+		ExportFunc: func(fileID synd.FileID) (*synd.ExportResponse, error) {
+			t.Errorf("Export must not be called during reconcile, got %s", fileID)
+			return nil, os.ErrInvalid
+		},
+	}
+
+	exporter := NewExporterWithDependencies(session, dir, &DefaultFileSystem{}, WithReconcile(true))
+	stats, err := exporter.ExportMyDrive()
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Downloaded)
+	assert.Equal(t, 1, stats.Skipped)
+	assert.Equal(t, 3, stats.Mismatched)
+	assert.Equal(t, 0, stats.TotalErrs())
+
+	history, err := dh.NewDownloadHistory(historyPath)
+	require.NoError(t, err)
+	require.NoError(t, history.Load())
+	entries, err := history.FindItems(dh.Query{})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "doc/match.docx", entries[0].Location)
+	assert.Equal(t, synd.FileID("f1"), entries[0].FileID)
+	assert.Equal(t, synd.FileHash("h1"), entries[0].Hash)
+
+	// Local files must be left untouched.
+	for _, name := range []string{"doc/match.docx", "doc/outdated.docx", "doc/empty.xlsx"} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		assert.NoError(t, err, name)
+	}
+}
@@ -0,0 +1,118 @@
+package synology_drive_exporter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	dh "github.com/isseis/go-synology-office-exporter/download_history"
+)
+
+// ErrInterrupted is returned by export methods when the export was stopped by Interrupt or Abort before it completed.
+// The download history is saved as a checkpoint of the unfinished run and obsolete files are not cleaned up.
+var ErrInterrupted = errors.New("export interrupted")
+
+// activeExport tracks the history and lock of the export in progress, so that Abort can finalize it
+// and Progress can report on it from another goroutine. Exactly one of finish and abort takes effect.
+type activeExport struct {
+	mu          sync.Mutex
+	history     *dh.DownloadHistory
+	checkpoint  *checkpointer // Nil if the export does not save checkpoints
+	unlock      func()
+	finalized   bool
+	released    bool
+	runID       string
+	historyFile string
+	started     time.Time
+	currentPath string
+}
+
+// finish claims the export for normal completion. It returns false if the export has already been aborted,
+// in which case the history must not be written again.
+func (a *activeExport) finish() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.finalized {
+		return false
+	}
+	a.finalized = true
+	return true
+}
+
+// abort saves a checkpoint of the history and releases the lock, unless the export has already been finalized.
+func (a *activeExport) abort() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.finalized {
+		return nil
+	}
+	a.finalized = true
+	var err error
+	if a.checkpoint != nil {
+		err = a.history.Checkpoint(a.checkpoint.run)
+	}
+	a.releaseLocked()
+	return err
+}
+
+// release releases the lock if it is still held.
+func (a *activeExport) release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.releaseLocked()
+}
+
+// releaseLocked releases the lock if it is still held. The caller must hold a.mu.
+func (a *activeExport) releaseLocked() {
+	if !a.released {
+		a.released = true
+		a.unlock()
+	}
+}
+
+// Interrupt stops the export in progress from starting work on any further file or directory.
+// The file being exported is completed, after which the export saves its history as a checkpoint,
+// releases its lock and returns ErrInterrupted. An export waiting for its history lock stops waiting and
+// returns ErrInterrupted. Later exports return ErrInterrupted immediately.
+// This method is safe to call from another goroutine, such as a signal handler.
+func (e *Exporter) Interrupt() {
+	e.interrupted.Store(true)
+	e.stopContext()
+	e.stopCancel()
+}
+
+// stopContext returns a context that is cancelled when the exporter is interrupted.
+func (e *Exporter) stopContext() context.Context {
+	e.stopOnce.Do(func() {
+		e.stopCtx, e.stopCancel = context.WithCancel(context.Background())
+	})
+	return e.stopCtx
+}
+
+// Interrupted reports whether Interrupt or Abort has been called.
+func (e *Exporter) Interrupted() bool {
+	return e.interrupted.Load()
+}
+
+// Abort interrupts the exporter and finalizes the export in progress immediately, without waiting for the
+// file being exported: its history is saved as a checkpoint and its lock is released.
+// It is intended for when the grace period after Interrupt has expired and the process is about to exit.
+// This method is safe to call from another goroutine.
+func (e *Exporter) Abort() error {
+	e.Interrupt()
+	e.activeMu.Lock()
+	active := e.active
+	e.activeMu.Unlock()
+	if active == nil {
+		return nil
+	}
+	return active.abort()
+}
+
+// setActive records the export in progress, or clears it if a is nil.
+func (e *Exporter) setActive(a *activeExport) {
+	e.activeMu.Lock()
+	defer e.activeMu.Unlock()
+	e.active = a
+}
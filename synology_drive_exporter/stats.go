@@ -8,12 +8,15 @@ import (
 
 // ExportStats holds the statistics of the export operation
 type ExportStats struct {
-	Downloaded   int // Number of successfully downloaded files
-	Skipped      int // Number of skipped files (already up-to-date)
-	Ignored      int // Number of ignored files (not exportable)
-	Removed      int // Number of successfully removed files
-	DownloadErrs int // Number of errors occurred during download
-	RemoveErrs   int // Number of errors occurred during removal
+	Downloaded   int   // Number of successfully downloaded files
+	Skipped      int   // Number of skipped files (already up-to-date)
+	Ignored      int   // Number of ignored files (not exportable)
+	Removed      int   // Number of successfully removed files
+	DownloadErrs int   // Number of errors occurred during download
+	RemoveErrs   int   // Number of errors occurred during removal
+	Mismatched   int   // Number of local files flagged for re-export during reconcile
+	BytesWritten int64 // Number of bytes written to downloaded files
+	HistorySize  int   // Number of entries in the download history after the export
 }
 
 // String returns a string representation of the export statistics
@@ -49,5 +52,6 @@ func toExportStats(stats dh.ExportStats) ExportStats {
 		Removed:      0,
 		DownloadErrs: stats.Errors,
 		RemoveErrs:   0,
+		Mismatched:   stats.Mismatched,
 	}
 }